@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"io/ioutil"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/engine"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/metrics"
 	"os"
+	"strings"
 )
 
 var (
@@ -26,27 +30,199 @@ const (
 
 	// ExitCodeInvalidConfig something wrong with config.
 	ExitCodeInvalidConfig = 2
+
+	// ExitCodeVaultAccess a secret could not be retrieved from a vault.
+	ExitCodeVaultAccess = 3
+
+	// ExitCodeProcessing processing failed for a reason not covered by other exit codes.
+	ExitCodeProcessing = 4
+
+	// ExitCodeTransformation a transformation step failed.
+	ExitCodeTransformation = 5
+
+	// ExitCodeSink a variable could not be written to a sink.
+	ExitCodeSink = 6
 )
 
+// errorReport is the machine-readable form of an error, printed with -error-format json
+type errorReport struct {
+	Category       string `json:"category"`
+	Message        string `json:"message"`
+	ExitCode       int    `json:"exitCode"`
+	Vault          string `json:"vault,omitempty"`
+	Secret         string `json:"secret,omitempty"`
+	Transformation string `json:"transformation,omitempty"`
+	Output         string `json:"output,omitempty"`
+	Sink           string `json:"sink,omitempty"`
+	Var            string `json:"var,omitempty"`
+	File           string `json:"file,omitempty"`
+	Line           int    `json:"line,omitempty"`
+	Column         int    `json:"column,omitempty"`
+}
+
+// newConfigErrorReport adds the position of a configuration error, if known
+func newConfigErrorReport(err error) errorReport {
+	res := errorReport{Category: "config", ExitCode: ExitCodeInvalidConfig}
+
+	var errConfig core.ConfigError
+	if errors.As(err, &errConfig) {
+		res.File, res.Line, res.Column = errConfig.Pos.File, errConfig.Pos.Line, errConfig.Pos.Column
+	}
+	return res
+}
+
+// newErrorReport classifies a processing error by its type
+func newErrorReport(err error) errorReport {
+	var errVaultAccess core.ErrVaultAccess
+	var errTransformation core.ErrTransformation
+	var errSink core.ErrSink
+
+	switch {
+	case errors.As(err, &errVaultAccess):
+		return errorReport{Category: "vault-access", ExitCode: ExitCodeVaultAccess,
+			Vault: errVaultAccess.Vault, Secret: errVaultAccess.Secret}
+	case errors.As(err, &errTransformation):
+		return errorReport{Category: "transformation", ExitCode: ExitCodeTransformation,
+			Transformation: errTransformation.Type, Output: errTransformation.Output}
+	case errors.As(err, &errSink):
+		return errorReport{Category: "sink", ExitCode: ExitCodeSink,
+			Sink: errSink.Type, Var: errSink.Var}
+	}
+	return errorReport{Category: "processing", ExitCode: ExitCodeProcessing}
+}
+
+// exitWithError prints the report in the given format to stderr and exits with its exit code
+func exitWithError(format string, report errorReport, err error) {
+	report.Message = err.Error()
+	if format == "json" {
+		if err := json.NewEncoder(os.Stderr).Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, report.Message)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, report.Message)
+	}
+	os.Exit(report.ExitCode)
+}
+
+// repeatedFlag collects the values of a repeated flag, e.g. -c
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// newFactory creates the builtin factory, along with the types of the given plugins. Plugins
+// must not provide types that are builtin or provided by another plugin. The returned func
+// stops the plugins.
+func newFactory(l *logging.Logger, plugins []string) (core.Factory, func(), error) {
+	f := cliFactory{adapters.NewBuiltinFactory(l, afero.NewOsFs())}
+
+	started := make([]*adapters.PluginFactory, 0, len(plugins))
+	stop := func() {
+		for idx, p := range started {
+			if err := p.Close(); err != nil {
+				l.Warn("Plugin stopped with error", logging.String("plugin", plugins[idx]), logging.Err(err))
+			}
+		}
+	}
+	for _, plugin := range plugins {
+		p, err := adapters.StartPlugin(l, plugin)
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+		started = append(started, p)
+
+		if err := f.RegisterFactory(p); err != nil {
+			stop()
+			return nil, nil, fmt.Errorf("unable to use plugin %s: %w", plugin, err)
+		}
+	}
+
+	return f, stop, nil
+}
+
+// cliFactory provides the builtin types but the collect sink, as collected secrets would be
+// discarded by the run command
+type cliFactory struct {
+	*adapters.BuiltinFactory
+}
+
+// SinkTypes returns the sink types without the collect sink
+func (f cliFactory) SinkTypes() []string {
+	res := make([]string, 0)
+	for _, t := range f.BuiltinFactory.SinkTypes() {
+		if t != adapters.CollectSinkType {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// NewSinkWriter creates a sink writer for all sink types but the collect sink
+func (f cliFactory) NewSinkWriter(sinkType string) core.SinkWriterPort {
+	if sinkType == adapters.CollectSinkType {
+		return nil
+	}
+	return f.BuiltinFactory.NewSinkWriter(sinkType)
+}
+
 func usage() {
-	fmt.Println("Usage: go-secretshelper [-v] [-e] [-c config] <command>")
+	fmt.Println("Usage: go-secretshelper [-v] [-e] [-strict-env] [-error-format text|json] [-log-format text|json] [-log-level <level>] [-plugin <executable>]... <command>")
 	fmt.Println("where commands are")
-	fmt.Println("  version		print out version")
-	fmt.Println("  run			run specified config")
+	fmt.Println("  version                              print out version")
+	fmt.Println("  run -c <config>...                   run specified config")
+	fmt.Println("  lint [-c <config>...] [<config>...]  check specified config and warn about questionable settings")
+	fmt.Println("  schema                               print json schema of configuration files")
 }
 
 func main() {
 
-	verboseFlag := flag.Bool("v", false, "Enables verbose output")
+	verboseFlag := flag.Bool("v", false, "Enables verbose output, same as -log-level debug")
 	envFlag := flag.Bool("e", false, "Enables environment variable substitution")
+	strictEnvFlag := flag.Bool("strict-env", false, "Enables environment variable substitution, failing on undefined variables")
+	errorFormatFlag := flag.String("error-format", "text", "Format of error output, text or json")
+	logFormatFlag := flag.String("log-format", "text", "Format of log output, text or json")
+	logLevelFlag := flag.String("log-level", "", "Minimum level of log output, debug, info, warn, error or off (default off)")
+	var pluginFlag repeatedFlag
+	flag.Var(&pluginFlag, "plugin", "plugin executable providing further types, may be repeated")
 	flag.Parse()
 
-	var l *log.Logger
+	if *errorFormatFlag != "text" && *errorFormatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "invalid error format: %s\n", *errorFormatFlag)
+		os.Exit(ExitCodeNoOrUnknownCommand)
+	}
+
+	envSubstMode := core.NoEnvSubst
+	if *envFlag {
+		envSubstMode = core.LenientEnvSubst
+	}
+	if *strictEnvFlag {
+		envSubstMode = core.StrictEnvSubst
+	}
+
+	logFormat, err := logging.ParseFormat(*logFormatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitCodeNoOrUnknownCommand)
+	}
+	// errors are reported on their own, so that stderr stays readable, e.g. with -error-format json
+	logLevel := logging.LevelOff
 	if *verboseFlag {
-		l = log.New(os.Stderr, "", log.LstdFlags)
-	} else {
-		l = log.New(ioutil.Discard, "", 0)
+		logLevel = logging.LevelDebug
+	}
+	if *logLevelFlag != "" {
+		if logLevel, err = logging.ParseLevel(*logLevelFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitCodeNoOrUnknownCommand)
+		}
 	}
+	l := logging.New(os.Stderr, logFormat, logLevel)
 
 	values := flag.Args()
 	if len(values) == 0 {
@@ -54,46 +230,149 @@ func main() {
 		os.Exit(ExitCodeNoOrUnknownCommand)
 	}
 
+	stopPlugins := func() {}
+	factory := func() core.Factory {
+		f, stop, err := newFactory(l, pluginFlag)
+		if err != nil {
+			exitWithError(*errorFormatFlag, errorReport{Category: "plugin", ExitCode: ExitCodeProcessing}, err)
+		}
+		stopPlugins = stop
+		return f
+	}
+	// exit stops the plugins started so far before exiting with an error
+	exit := func(report errorReport, err error) {
+		stopPlugins()
+		exitWithError(*errorFormatFlag, report, err)
+	}
+
 	switch values[0] {
 	case "version":
 		fmt.Printf("%s (%s)\n", commit, date)
 		os.Exit(ExitCodeOk)
 
+	case "schema":
+		f := factory()
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(core.NewConfigSchema(f)); err != nil {
+			exit(errorReport{Category: "processing", ExitCode: ExitCodeProcessing}, err)
+		}
+		stopPlugins()
+		os.Exit(ExitCodeOk)
+
+	case "lint":
+
+		fs := flag.NewFlagSet("lint", flag.ExitOnError)
+		var configFlag repeatedFlag
+		fs.Var(&configFlag, "c", "configuration file, may be repeated to merge files")
+		strictFlag := fs.Bool("strict", false, "fail on warnings, too")
+
+		if err := fs.Parse(values[1:]); err != nil {
+			exit(errorReport{Category: "usage", ExitCode: ExitCodeNoOrUnknownCommand},
+				fmt.Errorf("error parsing commands: %s", err))
+		}
+
+		// the files given in -c are merged, further configuration files may be given
+		// as arguments, e.g. by a pre-commit hook, and are checked one by one
+		configs := make([][]string, 0)
+		if len(configFlag) > 0 {
+			configs = append(configs, configFlag)
+		}
+		for _, fileName := range fs.Args() {
+			configs = append(configs, []string{fileName})
+		}
+		if len(configs) == 0 {
+			exit(errorReport{Category: "usage", ExitCode: ExitCodeNoOrUnknownCommand},
+				errors.New("no configuration file given, see -c"))
+		}
+
+		f := factory()
+		failed := false
+		for _, fileNames := range configs {
+			config, err := core.NewConfigFromFiles(fileNames, envSubstMode)
+			if err != nil {
+				exit(newConfigErrorReport(err),
+					fmt.Errorf("unable to read config from file %s: %s", strings.Join(fileNames, ", "), err))
+			}
+
+			for _, finding := range config.Lint(f) {
+				fmt.Println(finding)
+				if finding.Severity == core.SeverityError || *strictFlag {
+					failed = true
+				}
+			}
+		}
+		stopPlugins()
+		if failed {
+			os.Exit(ExitCodeInvalidConfig)
+		}
+		os.Exit(ExitCodeOk)
+
 	case "run":
 
 		fs := flag.NewFlagSet("run", flag.ExitOnError)
-		configFlag := fs.String("c", "", "configuration file")
+		var configFlag repeatedFlag
+		fs.Var(&configFlag, "c", "configuration file, may be repeated to merge files")
+		keepGoingFlag := fs.Bool("keep-going", false, "continue after failed steps, skip only steps depending on them")
+		reportFlag := fs.String("report", "", "print a report of all steps, as table or json")
+		profileFlag := fs.String("profile", "", "apply the named profile of the configuration")
+		metricsTextfileFlag := fs.String("metrics-textfile", "", "write metrics to a file for the textfile collector, ending with .prom")
 
 		if err := fs.Parse(values[1:]); err != nil {
-			fmt.Fprintf(os.Stderr, "error parsind commands: %s\n", err)
-			os.Exit(ExitCodeNoOrUnknownCommand)
+			exit(errorReport{Category: "usage", ExitCode: ExitCodeNoOrUnknownCommand},
+				fmt.Errorf("error parsing commands: %s", err))
+		}
+		if len(configFlag) == 0 {
+			exit(errorReport{Category: "usage", ExitCode: ExitCodeNoOrUnknownCommand},
+				errors.New("no configuration file given, see -c"))
+		}
+		if *reportFlag != "" && *reportFlag != "table" && *reportFlag != "json" {
+			exit(errorReport{Category: "usage", ExitCode: ExitCodeNoOrUnknownCommand},
+				fmt.Errorf("invalid report format: %s", *reportFlag))
 		}
 
-		config, err := core.NewConfigFromFile(*configFlag, *envFlag)
+		config, err := core.NewConfigFromFiles(configFlag, envSubstMode)
 		if err != nil {
-			fmt.Printf("Unable to read config from file %s: %s\n", *configFlag, err)
-			os.Exit(ExitCodeInvalidConfig)
+			exit(newConfigErrorReport(err),
+				fmt.Errorf("unable to read config from file %s: %s", configFlag.String(), err))
 		}
 
-		f := adapters.NewBuiltinFactory(l, afero.NewOsFs())
-		if err = config.Validate(f); err != nil {
-			fmt.Fprintf(os.Stderr, "Error validating configuration: %s\n", err)
-			os.Exit(ExitCodeInvalidConfig)
+		opts := []engine.Option{
+			engine.WithLogger(l),
+			engine.WithFactory(factory()),
+			engine.WithKeepGoing(*keepGoingFlag),
+			engine.WithProfile(*profileFlag),
+		}
+		var m *metrics.Metrics
+		if *metricsTextfileFlag != "" {
+			m = metrics.New()
+			opts = append(opts, engine.WithHooks(m.Hooks()))
 		}
 
-		cmd := core.NewMainUseCaseImpl(l)
+		result, err := engine.New(opts...).Run(context.Background(), config)
+		var errInvalidConfig engine.ErrInvalidConfig
+		if errors.As(err, &errInvalidConfig) {
+			exit(newConfigErrorReport(err), err)
+		}
 
-		err = cmd.Process(context.Background(), f,
-			&config.Defaults,
-			&config.Vaults,
-			&config.Secrets,
-			&config.Transformations,
-			&config.Sinks)
+		if m != nil {
+			if errMetrics := m.WriteTextfile(*metricsTextfileFlag); errMetrics != nil {
+				l.Error("Unable to write metrics", logging.String("path", *metricsTextfileFlag), logging.Err(errMetrics))
+			}
+		}
+
+		switch *reportFlag {
+		case "table":
+			result.Report.WriteTable(os.Stdout)
+		case "json":
+			result.Report.WriteJSON(os.Stdout)
+		}
 
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(4)
+			exit(newErrorReport(err), err)
 		}
+		stopPlugins()
 		os.Exit(ExitCodeOk)
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", values[0])
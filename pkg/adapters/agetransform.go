@@ -6,8 +6,8 @@ import (
 	"filippo.io/age/armor"
 	"fmt"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"io"
-	"log"
 	"strings"
 )
 
@@ -17,7 +17,7 @@ const AgeEncryptTransformationType = "age"
 // AgeEncryptTransformation is an transformation port adapter capable of
 // encrypting a secret using the age encryption scheme.
 type AgeEncryptTransformation struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // AgeEncryptionTransformationSpec is the specification part for the configuration
@@ -28,22 +28,24 @@ type AgeEncryptionTransformationSpec struct {
 
 // NewAgeEncryptionTransformationSpec creates an AgeEncryptionTransformationSpec from a generic map
 func NewAgeEncryptionTransformationSpec(in map[interface{}]interface{}) (AgeEncryptionTransformationSpec, error) {
-	recipient, ok := in["recipient"]
-	if !ok {
-		return AgeEncryptionTransformationSpec{}, fmt.Errorf("recipient element is required in spec of age transform")
-	}
-	recipientStr, ok := recipient.(string)
-	if !ok {
-		return AgeEncryptionTransformationSpec{}, fmt.Errorf("recipient element must be a string in spec of age transform")
-	}
+	var res AgeEncryptionTransformationSpec
+	err := core.DecodeSpec(in, &res)
+	return res, err
+}
 
-	return AgeEncryptionTransformationSpec{
-		Recipient: recipientStr,
-	}, nil
+// ValidateSpec checks if given spec can be decoded into an AgeEncryptionTransformationSpec
+func (aet *AgeEncryptTransformation) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewAgeEncryptionTransformationSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of AgeEncryptionTransformationSpec
+func (aet *AgeEncryptTransformation) SpecSchema() *core.Schema {
+	return core.SchemaOf(AgeEncryptionTransformationSpec{})
 }
 
 // NewAgeEncryptTransformation returns a new instance of AgeEncrypt transformation
-func NewAgeEncryptTransformation(log *log.Logger) *AgeEncryptTransformation {
+func NewAgeEncryptTransformation(log *logging.Logger) *AgeEncryptTransformation {
 	return &AgeEncryptTransformation{log: log}
 }
 
@@ -78,7 +80,7 @@ func (aet *AgeEncryptTransformation) ProcessSecret(ctx context.Context, defaults
 	a := armor.NewWriter(b)
 	defer func() {
 		if err := a.Close(); err != nil {
-			aet.log.Printf("AgeTransformation: error closing writer: %v", err)
+			aet.log.Warn("Unable to close armored writer", logging.Transformation(AgeEncryptTransformationType), logging.Err(err))
 		}
 	}()
 
@@ -3,16 +3,13 @@ package adapters
 import (
 	"bufio"
 	"context"
-	"encoding/json"
-	"errors"
 	"filippo.io/age"
 	"filippo.io/age/armor"
 	"fmt"
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"gopkg.in/yaml.v2"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"io"
-	"log"
 	"os"
 	"strings"
 )
@@ -23,12 +20,12 @@ const AgeVaultType = "age-file"
 // AgeVault is a core.VaultAccessorPort which pulls secrets from an age-encrypted file
 // Currently armored files and non-encrypted age-identites are supported.
 type AgeVault struct {
-	log *log.Logger
+	log *logging.Logger
 	fs  afero.Fs
 }
 
 // NewAgeVault creates a new age vault
-func NewAgeVault(log *log.Logger, fs afero.Fs) *AgeVault {
+func NewAgeVault(log *logging.Logger, fs afero.Fs) *AgeVault {
 	return &AgeVault{
 		log: log,
 		fs:  fs,
@@ -38,43 +35,35 @@ func NewAgeVault(log *log.Logger, fs afero.Fs) *AgeVault {
 // AgeVaultSpec describes access to both age and identity files
 type AgeVaultSpec struct {
 	// Path points to armored, age-encrypted file
-	Path string
+	Path string `yaml:"path" validate:"required"`
 
 	// IdentityFile points to unencrypted age identity file
-	IdentityFile string
+	IdentityFile string `yaml:"identity" validate:"required"`
 }
 
 // NewAgeVaultSpec creates a new vault spec from the generic interface map
 func NewAgeVaultSpec(in map[interface{}]interface{}) (AgeVaultSpec, error) {
 	var res AgeVaultSpec
-	var ok bool
-
-	v, ex := in["path"]
-	if !ex {
-		return res, errors.New("must provide a path element for an age-based vault spec")
-	}
-	res.Path, ok = v.(string)
-	if !ok {
-		return res, errors.New("must provide a path element for an age-based vault spec")
-	}
+	err := core.DecodeSpec(in, &res)
+	return res, err
+}
 
-	v, ex = in["identity"]
-	if !ex {
-		return res, errors.New("must provide an identity element for an age-based vault spec")
-	}
-	res.IdentityFile, ok = v.(string)
-	if !ok {
-		return res, errors.New("must provide a identity element for an age-based vault spec")
-	}
+// ValidateSpec checks if given spec can be decoded into an AgeVaultSpec
+func (v *AgeVault) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewAgeVaultSpec(in)
+	return err
+}
 
-	return res, nil
+// SpecSchema returns the schema of AgeVaultSpec
+func (v *AgeVault) SpecSchema() *core.Schema {
+	return core.SchemaOf(AgeVaultSpec{})
 }
 
 // given path to an age file and the identity file, this method decodes
 // the file and returns it as a byte array
 func (v *AgeVault) readFromAgeFile(path, identity string) ([]byte, error) {
 	// parse identity file
-	identities, err := v.parseIdentitiesFile(identity)
+	identities, err := parseAgeIdentitiesFile(v.fs, identity)
 	if err != nil {
 		return nil, err
 	}
@@ -126,42 +115,44 @@ func (v *AgeVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
 		return nil, err
 	}
 
-	// parse json or yaml or as-is
-	var data map[string]string
-	err = json.Unmarshal(res, &data)
+	// parse json or yaml, anything but an object is the secret as a whole
+	doc, _, err := parseDocument(res)
 	if err != nil {
-		// try yaml
-		err = yaml.Unmarshal(res, &data)
-		if err != nil {
-			// treat the secret as-is
-			return &core.Secret{
-				RawContent:     res,
-				RawContentType: "",
-				Name:           secret.Name,
-				Type:           secret.Type,
-				VaultName:      secret.VaultName,
-			}, nil
-		}
+		return nil, fmt.Errorf("unable to parse %s: %w", spec.Path, err)
 	}
-	content, found := data[secret.Name]
-	if !found {
-		return nil, fmt.Errorf("unable to find secret %s in vault %s", secret.Name, vault.Name)
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return &core.Secret{
+			RawContent:     res,
+			RawContentType: "",
+			Name:           secret.Name,
+			Type:           secret.Type,
+			VaultName:      secret.VaultName,
+		}, nil
+	}
+
+	value, err := lookupKeyPath(doc, secret.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find secret %s in vault %s: %w", secret.Name, vault.Name, err)
+	}
+	content, contentType, err := encodeValue(value)
+	if err != nil {
+		return nil, err
 	}
 
 	return &core.Secret{
-		RawContent:     []byte(content),
-		RawContentType: "",
+		RawContent:     content,
+		RawContentType: contentType,
 		Name:           secret.Name,
 		Type:           secret.Type,
 		VaultName:      secret.VaultName,
 	}, nil
 }
 
-// parseIdentitiesFile parses a file that contains age or SSH keys. It returns
+// parseAgeIdentitiesFile parses a file that contains age or SSH keys. It returns
 // one or more of *age.X25519Identity, *agessh.RSAIdentity, *agessh.Ed25519Identity,
 // *agessh.EncryptedSSHIdentity, or *EncryptedIdentity.
-func (v *AgeVault) parseIdentitiesFile(name string) ([]age.Identity, error) {
-	f, err := v.fs.OpenFile(name, os.O_RDONLY, 0)
+func parseAgeIdentitiesFile(fs afero.Fs, name string) ([]age.Identity, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %v", err)
 	}
@@ -0,0 +1,136 @@
+package adapters
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"net/http"
+	"net/url"
+)
+
+// AWSCredentialsSpec specifies region and credentials of AWS vaults. Without any of them,
+// the credentials of the environment are used. As every vault creates its own session,
+// vaults of different accounts can be used within one configuration.
+type AWSCredentialsSpec struct {
+	// Region overrides the region of the environment
+	Region string `yaml:"region"`
+
+	// Profile selects a profile of the shared configuration and credentials files
+	Profile string `yaml:"profile"`
+
+	// AccessKeyID is the id of a static access key, e.g. taken from another vault
+	AccessKeyID string `yaml:"accessKeyId"`
+
+	// SecretAccessKey is the secret of the static access key
+	SecretAccessKey string `yaml:"secretAccessKey"`
+
+	// SessionToken is the token of temporary credentials given by the access key
+	SessionToken string `yaml:"sessionToken"`
+
+	// RoleArn is a role to assume, using the credentials given by profile or the environment
+	RoleArn string `yaml:"roleArn"`
+
+	// ExternalID is passed when assuming the role
+	ExternalID string `yaml:"externalId"`
+
+	// Endpoint overrides the endpoint of the service, e.g. for VPC endpoints or emulators
+	Endpoint string `yaml:"endpoint"`
+}
+
+// validate checks combinations of settings
+func (s *AWSCredentialsSpec) validate() error {
+	switch {
+	case s.AccessKeyID != "" && s.SecretAccessKey == "":
+		return core.SpecError{Field: "secretAccessKey", Message: "is required with accessKeyId"}
+	case s.AccessKeyID == "" && s.SecretAccessKey != "":
+		return core.SpecError{Field: "accessKeyId", Message: "is required with secretAccessKey"}
+	case s.AccessKeyID == "" && s.SessionToken != "":
+		return core.SpecError{Field: "sessionToken", Message: "requires accessKeyId and secretAccessKey"}
+	case s.AccessKeyID != "" && s.Profile != "":
+		return core.SpecError{Field: "profile", Message: "cannot be combined with accessKeyId"}
+	}
+	if s.ExternalID != "" && s.RoleArn == "" {
+		return core.SpecError{Field: "externalId", Message: "requires roleArn"}
+	}
+	if s.Endpoint != "" {
+		u, err := url.Parse(s.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return core.SpecError{Field: "endpoint", Message: "invalid url: must be an absolute url"}
+		}
+	}
+	return nil
+}
+
+// newSession returns a session for the credentials, along with the configuration for service
+// clients. If httpClient is not nil, it is used for all requests.
+func (s *AWSCredentialsSpec) newSession(httpClient *http.Client) (*session.Session, *aws.Config, error) {
+	config := aws.NewConfig()
+	if s.Region != "" {
+		config.Region = aws.String(s.Region)
+	}
+	if s.AccessKeyID != "" {
+		config.Credentials = credentials.NewStaticCredentials(s.AccessKeyID, s.SecretAccessKey, s.SessionToken)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           s.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create session: %w", err)
+	}
+	if httpClient != nil {
+		// set after creating the session, so that AWS_CA_BUNDLE does not alter it
+		sess.Config.HTTPClient = httpClient
+	}
+
+	// the endpoint applies to the service only, not to assuming the role. Requests are not
+	// retried by the SDK, so that the retry policy of the vault alone decides on retries.
+	serviceConfig := aws.NewConfig().WithMaxRetries(0)
+	if s.RoleArn != "" {
+		serviceConfig.Credentials = stscreds.NewCredentials(sess, s.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			if s.ExternalID != "" {
+				p.ExternalID = aws.String(s.ExternalID)
+			}
+		})
+	}
+	if s.Endpoint != "" {
+		serviceConfig.Endpoint = aws.String(s.Endpoint)
+	}
+
+	return sess, serviceConfig, nil
+}
+
+// isAWSRetryable returns true for throttling, 5xx responses and other errors the AWS SDK
+// considers transient
+func isAWSRetryable(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) && rerr.StatusCode() >= 500 {
+		return true
+	}
+	return request.IsErrorThrottle(aerr) || request.IsErrorRetryable(aerr)
+}
+
+// awsNotFound marks errors with one of the given codes as core.ErrNotFound
+func awsNotFound(err error, codes ...string) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		for _, code := range codes {
+			if aerr.Code() == code {
+				return core.ErrNotFound{Err: err}
+			}
+		}
+	}
+	return err
+}
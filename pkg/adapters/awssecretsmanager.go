@@ -2,11 +2,12 @@ package adapters
 
 import (
 	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 )
 
 // AWSSecretsManagerType is the type of this adapter, to be used in configuration files
@@ -14,31 +15,43 @@ const AWSSecretsManagerType = "aws-secretsmanager"
 
 // AWSSecretsManager is a VaultAccessPort for the AWS Secrets Manager service.
 type AWSSecretsManager struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // NewAWSSecretsManager returns a new AWSSecretsManager.
-func NewAWSSecretsManager(log *log.Logger) *AWSSecretsManager {
+func NewAWSSecretsManager(log *logging.Logger) *AWSSecretsManager {
 	return &AWSSecretsManager{log: log}
 }
 
 // AWSSecretsManagerSpec specifies the configuration for an AWSSecretsManager.
 type AWSSecretsManagerSpec struct {
-	Region string `yaml:"region"`
+	AWSCredentialsSpec `yaml:",inline"`
+	TLSSpec            `yaml:",inline"`
 }
 
 // NewAWSSecretsManagerSpec returns a new AWSSecretsManagerSpec.
 func NewAWSSecretsManagerSpec(in map[interface{}]interface{}) (*AWSSecretsManagerSpec, error) {
 	var res AWSSecretsManagerSpec
-
-	v, ex := in["region"]
-	if ex {
-		res.Region = v.(string)
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return nil, err
+	}
+	if err := res.validate(); err != nil {
+		return nil, err
 	}
-
 	return &res, nil
 }
 
+// ValidateSpec checks if given spec can be decoded into an AWSSecretsManagerSpec
+func (v *AWSSecretsManager) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewAWSSecretsManagerSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of AWSSecretsManagerSpec
+func (v *AWSSecretsManager) SpecSchema() *core.Schema {
+	return core.SchemaOf(AWSSecretsManagerSpec{})
+}
+
 // RetrieveSecret retrieves a secret from the aws' secrets manager
 func (v *AWSSecretsManager) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
 	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
@@ -48,35 +61,28 @@ func (v *AWSSecretsManager) RetrieveSecret(ctx context.Context, defaults *core.D
 		return nil, err
 	}
 
-	config := aws.NewConfig()
-	if spec.Region != "" {
-		config.Region = &spec.Region
-	}
-
-	session, err := session.NewSession(config)
+	httpClient, err := spec.httpClient()
 	if err != nil {
 		return nil, err
 	}
 
-	svc := secretsmanager.New(session)
-
-	/*secretDescription, err := svc.DescribeSecret(&secretsmanager.DescribeSecretInput{
-		SecretId:     aws.String(secret.Name),
-	})
+	sess, config, err := spec.newSession(httpClient)
 	if err != nil {
 		return nil, err
 	}
-	v.log.Printf("AWSSecretsManager[%s]: %#v\n", vault.Name, *secretDescription)*/
 
-	result, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+	svc := secretsmanager.New(sess, config)
+
+	result, err := svc.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId:     aws.String(secret.Name),
 		VersionStage: aws.String("AWSCURRENT"),
 	})
 	if err != nil {
-		return nil, err
+		return nil, awsNotFound(err, secretsmanager.ErrCodeResourceNotFoundException)
 	}
 
-	v.log.Printf("AWSSecretsManager[%s]: Retrieved secret name=%s, arn=%s, v=%s\n", vault.Name, secret.Name, *result.ARN, *result.VersionId)
+	v.log.Debug("Retrieved secret", logging.Type(AWSSecretsManagerType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+		logging.String("arn", *result.ARN), logging.String("version", *result.VersionId))
 
 	return &core.Secret{
 		RawContent:     []byte(*result.SecretString),
@@ -86,3 +92,13 @@ func (v *AWSSecretsManager) RetrieveSecret(ctx context.Context, defaults *core.D
 		VaultName:      secret.VaultName,
 	}, nil
 }
+
+// IsRetryable returns true for throttling (e.g. ThrottlingException), internal service
+// errors and other errors the AWS SDK considers transient.
+func (v *AWSSecretsManager) IsRetryable(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeInternalServiceError {
+		return true
+	}
+	return isAWSRetryable(err)
+}
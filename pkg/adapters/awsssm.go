@@ -0,0 +1,202 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+)
+
+// AWSSSMType is the type of this adapter, to be used in configuration files
+const AWSSSMType = "aws-ssm"
+
+// AWSSSM is a VaultAccessPort for the AWS Systems Manager Parameter Store.
+type AWSSSM struct {
+	log *logging.Logger
+}
+
+// NewAWSSSM returns a new AWSSSM.
+func NewAWSSSM(log *logging.Logger) *AWSSSM {
+	return &AWSSSM{log: log}
+}
+
+// AWSSSMSpec specifies the configuration for an AWSSSM.
+type AWSSSMSpec struct {
+	AWSCredentialsSpec `yaml:",inline"`
+	TLSSpec            `yaml:",inline"`
+
+	// Path is prepended to the names of secrets, e.g. /myapp/prod
+	Path string `yaml:"path"`
+
+	// Recursive retrieves all parameters below the name of a secret as a json object
+	Recursive bool `yaml:"recursive"`
+
+	// Version selects a version of all parameters instead of the latest. A single secret
+	// selects a version by its key instead, e.g. db-password:3.
+	Version int64 `yaml:"version" validate:"gte=0"`
+
+	// Label selects the version of all parameters with the label. A single secret selects a
+	// label by its key instead, e.g. db-password:stable.
+	Label string `yaml:"label"`
+}
+
+// NewAWSSSMSpec returns a new AWSSSMSpec.
+func NewAWSSSMSpec(in map[interface{}]interface{}) (*AWSSSMSpec, error) {
+	var res AWSSSMSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return nil, err
+	}
+	if err := res.validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.Path != "" && !strings.HasPrefix(res.Path, "/"):
+		return nil, core.SpecError{Field: "path", Message: "must start with /"}
+	case res.Version > 0 && res.Label != "":
+		return nil, core.SpecError{Field: "label", Message: "cannot be combined with version"}
+	case res.Recursive && (res.Version > 0 || res.Label != ""):
+		return nil, core.SpecError{Field: "recursive", Message: "cannot be combined with version or label"}
+	}
+
+	return &res, nil
+}
+
+// parameterName returns the name of the parameter of a secret, including the path
+func (s *AWSSSMSpec) parameterName(secret *core.Secret) string {
+	if s.Path == "" {
+		return secret.Name
+	}
+	return strings.TrimSuffix(s.Path, "/") + "/" + strings.TrimPrefix(secret.Name, "/")
+}
+
+// selector returns the name of the parameter along with the version or label to retrieve
+func (s *AWSSSMSpec) selector(name string) string {
+	switch {
+	case s.Version > 0:
+		return fmt.Sprintf("%s:%d", name, s.Version)
+	case s.Label != "":
+		return fmt.Sprintf("%s:%s", name, s.Label)
+	}
+	return name
+}
+
+// ValidateSpec checks if given spec can be decoded into an AWSSSMSpec
+func (v *AWSSSM) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewAWSSSMSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of AWSSSMSpec
+func (v *AWSSSM) SpecSchema() *core.Schema {
+	return core.SchemaOf(AWSSSMSpec{})
+}
+
+// RetrieveSecret retrieves a parameter, decrypting SecureString parameters. The key of the
+// secret may select a version or label of the parameter, e.g. db-password:3, unless the vault
+// selects them for all parameters. With recursive, all parameters below the name are retrieved
+// as a json object, mapping their names relative to the name of the secret to their values.
+func (v *AWSSSM) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	spec, err := NewAWSSSMSpec(vault.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := spec.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	sess, config, err := spec.newSession(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := ssm.New(sess, config)
+
+	// names of parameters cannot contain colons, so a colon starts the selector of a secret
+	if strings.Contains(secret.Name, ":") && (spec.Version > 0 || spec.Label != "" || spec.Recursive) {
+		return nil, fmt.Errorf("AWSSSM[%s]: secret %s selects a version or label, which cannot be combined with version, label or recursive of the vault",
+			vault.Name, secret.Name)
+	}
+
+	name := spec.parameterName(secret)
+	res := &core.Secret{
+		Name:      secret.Name,
+		Type:      secret.Type,
+		VaultName: secret.VaultName,
+	}
+
+	if spec.Recursive {
+		content, err := v.retrieveByPath(ctx, svc, name)
+		if err != nil {
+			return nil, err
+		}
+		v.log.Debug("Retrieved parameters by path", logging.Type(AWSSSMType), logging.Vault(vault.Name),
+			logging.Secret(secret.Name), logging.String("path", name))
+
+		res.RawContent = content
+		res.RawContentType = "application/json"
+		return res, nil
+	}
+
+	result, err := svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(spec.selector(name)),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, awsNotFound(err, ssm.ErrCodeParameterNotFound, ssm.ErrCodeParameterVersionNotFound)
+	}
+	if result.Parameter == nil || result.Parameter.Value == nil {
+		return nil, fmt.Errorf("AWSSSM[%s]: parameter %s was empty or malformed", vault.Name, name)
+	}
+
+	v.log.Debug("Retrieved secret", logging.Type(AWSSSMType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+		logging.String("parameter", name), logging.Int("version", aws.Int64Value(result.Parameter.Version)))
+
+	res.RawContent = []byte(*result.Parameter.Value)
+	return res, nil
+}
+
+// retrieveByPath returns all parameters below path as json
+func (v *AWSSSM) retrieveByPath(ctx context.Context, svc *ssm.SSM, path string) ([]byte, error) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	values := make(map[string]string)
+
+	err := svc.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, p := range page.Parameters {
+			values[strings.TrimPrefix(aws.StringValue(p.Name), prefix)] = aws.StringValue(p.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, core.ErrNotFound{Err: awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("no parameters found below %s", path), nil)}
+	}
+
+	return json.Marshal(values)
+}
+
+// IsRetryable returns true for throttling, internal server errors and other errors the
+// AWS SDK considers transient.
+func (v *AWSSSM) IsRetryable(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == ssm.ErrCodeInternalServerError {
+		return true
+	}
+	return isAWSRetryable(err)
+}
@@ -2,11 +2,17 @@ package adapters
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/profiles/preview/keyvault/keyvault"
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/auth"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	azureauth "github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"net/http"
 	"net/url"
 )
 
@@ -15,42 +21,141 @@ const AzureKeyVaultType = "azure-key-vault"
 
 // AzureKeyVault is a core.VaultAccessorPort which pulls secrets from a Key Vault within an Azure subscription
 type AzureKeyVault struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // NewAzureKeyVault creates a new age vault
-func NewAzureKeyVault(l *log.Logger) *AzureKeyVault {
+func NewAzureKeyVault(l *logging.Logger) *AzureKeyVault {
 	return &AzureKeyVault{
 		log: l,
 	}
 }
 
-// AzureKeyVaultSpec describes access to the vault
+// AzureKeyVaultSpec describes access to the vault. Without credentials, they are taken
+// from the environment.
 type AzureKeyVaultSpec struct {
 	URL string `yaml:"url"`
+
+	// TenantID is the tenant of the service principal given by ClientID
+	TenantID string `yaml:"tenantId"`
+
+	// ClientID is the application id of a service principal authenticating with a certificate
+	// or a client secret
+	ClientID string `yaml:"clientId"`
+
+	// ClientSecret is the secret of the service principal, e.g. taken from another vault
+	ClientSecret string `yaml:"clientSecret"`
+
+	// CertificateFile is the path to a PKCS#12 file with the certificate and private key of the service principal
+	CertificateFile string `yaml:"certificateFile"`
+
+	// CertificatePassword decrypts the certificate file
+	CertificatePassword string `yaml:"certificatePassword"`
+
+	// ManagedIdentityClientID selects a user-assigned managed identity
+	ManagedIdentityClientID string `yaml:"managedIdentityClientId"`
+
+	// ActiveDirectoryEndpoint overrides the endpoint to request tokens for the certificate from
+	ActiveDirectoryEndpoint string `yaml:"activeDirectoryEndpoint"`
+
+	TLSSpec `yaml:",inline"`
 }
 
 // NewAzureKeyVaultSpec creates a new vault spec from the generic interface map
 func NewAzureKeyVaultSpec(in map[interface{}]interface{}) (AzureKeyVaultSpec, error) {
 	var res AzureKeyVaultSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return res, err
+	}
 
-	v, ex := in["url"]
-	if ex {
-		res.URL = v.(string)
-
+	if res.URL != "" {
 		// check if url is valid
 		u, err := url.Parse(res.URL)
 		if err != nil {
-			return res, fmt.Errorf("invalid url: %s", err)
+			return res, core.SpecError{Field: "url", Message: fmt.Sprintf("invalid url: %s", err)}
 		}
 		if u.Scheme != "https" || u.Host == "" {
-			return res, fmt.Errorf("invalid url: %s", err)
+			return res, core.SpecError{Field: "url", Message: "invalid url: must be an https url with a host"}
 		}
 	}
 
+	servicePrincipal := res.CertificateFile != "" || res.ClientSecret != ""
+	switch {
+	case res.CertificateFile != "" && res.ClientSecret != "":
+		return res, core.SpecError{Field: "clientSecret", Message: "cannot be combined with certificateFile"}
+	case res.CertificateFile != "" && res.ManagedIdentityClientID != "":
+		return res, core.SpecError{Field: "managedIdentityClientId", Message: "cannot be combined with certificateFile"}
+	case res.ClientSecret != "" && res.ManagedIdentityClientID != "":
+		return res, core.SpecError{Field: "managedIdentityClientId", Message: "cannot be combined with clientSecret"}
+	case servicePrincipal && res.TenantID == "":
+		return res, core.SpecError{Field: "tenantId", Message: "is required with certificateFile or clientSecret"}
+	case servicePrincipal && res.ClientID == "":
+		return res, core.SpecError{Field: "clientId", Message: "is required with certificateFile or clientSecret"}
+	case !servicePrincipal && (res.TenantID != "" || res.ClientID != ""):
+		return res, core.SpecError{Field: "certificateFile", Message: "or clientSecret is required with tenantId and clientId"}
+	case res.CertificateFile == "" && res.CertificatePassword != "":
+		return res, core.SpecError{Field: "certificatePassword", Message: "requires certificateFile"}
+	case !servicePrincipal && res.ActiveDirectoryEndpoint != "":
+		return res, core.SpecError{Field: "activeDirectoryEndpoint", Message: "requires certificateFile or clientSecret"}
+	}
+
 	return res, nil
 }
 
+// authorizer returns an authorizer for the credentials of the spec, or from the environment.
+// If httpClient is not nil, tokens for the credentials of the spec are requested with it.
+func (s AzureKeyVaultSpec) authorizer(httpClient *http.Client) (autorest.Authorizer, error) {
+	resource := azure.PublicCloud.ResourceIdentifiers.KeyVault
+
+	var spt *adal.ServicePrincipalToken
+	var err error
+	switch {
+	case s.CertificateFile != "":
+		c := azureauth.NewClientCertificateConfig(s.CertificateFile, s.CertificatePassword, s.ClientID, s.TenantID)
+		c.Resource = resource
+		if s.ActiveDirectoryEndpoint != "" {
+			c.AADEndpoint = s.ActiveDirectoryEndpoint
+		}
+		spt, err = c.ServicePrincipalToken()
+	case s.ClientSecret != "":
+		c := azureauth.NewClientCredentialsConfig(s.ClientID, s.ClientSecret, s.TenantID)
+		c.Resource = resource
+		if s.ActiveDirectoryEndpoint != "" {
+			c.AADEndpoint = s.ActiveDirectoryEndpoint
+		}
+		spt, err = c.ServicePrincipalToken()
+	case s.ManagedIdentityClientID != "":
+		c := azureauth.NewMSIConfig()
+		c.Resource = resource
+		c.ClientID = s.ManagedIdentityClientID
+		spt, err = c.ServicePrincipalToken()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if spt != nil {
+		if httpClient != nil {
+			spt.SetSender(httpClient)
+		}
+		return autorest.NewBearerAuthorizer(spt), nil
+	}
+
+	// see https://docs.microsoft.com/de-de/azure/developer/go/azure-sdk-authorization
+	// see also https://github.com/Azure-Samples/azure-sdk-for-go-samples/blob/master/internal/iam/authorizers.go
+	return auth.NewAuthorizerFromEnvironment()
+}
+
+// ValidateSpec checks if given spec can be decoded into an AzureKeyVaultSpec
+func (v *AzureKeyVault) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewAzureKeyVaultSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of AzureKeyVaultSpec
+func (v *AzureKeyVault) SpecSchema() *core.Schema {
+	return core.SchemaOf(AzureKeyVaultSpec{})
+}
+
 // RetrieveSecret decodes both identity and age file according to vault.Spec and
 // reads the secret.
 func (v *AzureKeyVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
@@ -66,14 +171,20 @@ func (v *AzureKeyVault) RetrieveSecret(ctx context.Context, defaults *core.Defau
 	if len(url) == 0 {
 		// compose url from key vault name
 		url = fmt.Sprintf("https://%s.vault.azure.net/", vault.Name)
-		v.log.Printf("AzureKeyVault: using url: %s", url)
+		v.log.Debug("Using url of key vault", logging.Type(AzureKeyVaultType), logging.Vault(vault.Name), logging.String("url", url))
 	}
 
 	client := keyvault.New()
 
-	// see https://docs.microsoft.com/de-de/azure/developer/go/azure-sdk-authorization
-	// see also https://github.com/Azure-Samples/azure-sdk-for-go-samples/blob/master/internal/iam/authorizers.go
-	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	httpClient, err := spec.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	if httpClient != nil {
+		client.Sender = httpClient
+	}
+
+	authorizer, err := spec.authorizer(httpClient)
 	if err != nil {
 		return nil, err
 	}
@@ -84,12 +195,12 @@ func (v *AzureKeyVault) RetrieveSecret(ctx context.Context, defaults *core.Defau
 		secret.Name,
 		"")
 	if err != nil {
+		if sc, ok := azureStatusCode(err); ok && sc == http.StatusNotFound {
+			return nil, core.ErrNotFound{Err: err}
+		}
 		return nil, err
 	}
 
-	//secretBundeJSON := secretBundle.MarshalJSON()
-	//v.log.Printf("%s\n", string(secretBundeJSON))
-
 	if secretBundle.Value == nil || secretBundle.ID == nil {
 		return nil, fmt.Errorf("AzureKeyVault[%s]: secret %s was empty or malformed", vault.Name, secret.Name)
 	}
@@ -99,7 +210,8 @@ func (v *AzureKeyVault) RetrieveSecret(ctx context.Context, defaults *core.Defau
 		ct = *secretBundle.ContentType
 	}
 
-	v.log.Printf("AzureKeyVault[%s]: Retrieved secret name=%s, id=%s, ct=%s", vault.Name, secret.Name, *secretBundle.ID, ct)
+	v.log.Debug("Retrieved secret", logging.Type(AzureKeyVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+		logging.String("id", *secretBundle.ID), logging.String("contentType", ct))
 
 	return &core.Secret{
 		RawContent:     []byte(*secretBundle.Value),
@@ -109,3 +221,22 @@ func (v *AzureKeyVault) RetrieveSecret(ctx context.Context, defaults *core.Defau
 		VaultName:      secret.VaultName,
 	}, nil
 }
+
+// IsRetryable returns true if the key vault responded with 429 (throttling) or a 5xx status code.
+func (v *AzureKeyVault) IsRetryable(err error) bool {
+	sc, ok := azureStatusCode(err)
+	if !ok {
+		return false
+	}
+	return sc == http.StatusTooManyRequests || (sc >= 500 && sc != http.StatusNotImplemented)
+}
+
+// azureStatusCode returns the http status code the key vault responded with, if any
+func azureStatusCode(err error) (int, bool) {
+	var derr autorest.DetailedError
+	if !errors.As(err, &derr) {
+		return 0, false
+	}
+	sc, ok := derr.StatusCode.(int)
+	return sc, ok
+}
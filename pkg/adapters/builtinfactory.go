@@ -3,88 +3,73 @@ package adapters
 import (
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 )
 
 // BuiltinFactory is able to create all builtin components of
-// the adapters package
+// the adapters package. Further types can be registered, see core.RegistryFactory.
 type BuiltinFactory struct {
-	log *log.Logger
+	*core.RegistryFactory
+
+	log *logging.Logger
 	fs  afero.Fs
 }
 
 // NewBuiltinFactory creates the Builtin Factory
-func NewBuiltinFactory(log *log.Logger, fs afero.Fs) *BuiltinFactory {
-	return &BuiltinFactory{
+func NewBuiltinFactory(log *logging.Logger, fs afero.Fs) *BuiltinFactory {
+	f := &BuiltinFactory{
+		RegistryFactory: core.NewRegistryFactory(func() core.Repository {
+			return NewBuiltinRepository()
+		}),
 		log: log,
 		fs:  fs,
 	}
-}
-
-// SinkTypes returns valid sink types
-func (f *BuiltinFactory) SinkTypes() []string {
-	return []string{
-		FileSinkType,
-	}
-}
-
-// TransformationTypes returns valid transformation types
-func (f *BuiltinFactory) TransformationTypes() []string {
-	return []string{
-		TemplateTransformationType,
-		AgeEncryptTransformationType,
-		JQTransformationType,
-	}
-}
 
-// VaultAccessorTypes returns valid vault types
-func (f *BuiltinFactory) VaultAccessorTypes() []string {
-	return []string{
-		AgeVaultType,
-		AzureKeyVaultType,
-		AWSSecretsManagerType,
-		GCPSecretManagerType,
-	}
-}
-
-// NewRepository creates a new repository
-func (f *BuiltinFactory) NewRepository() core.Repository {
-	return NewBuiltinRepository()
-}
-
-// NewSinkWriter creates a new sink writer for a supported type
-func (f *BuiltinFactory) NewSinkWriter(sinkType string) core.SinkWriterPort {
-	switch sinkType {
-	case FileSinkType:
-		return NewFileSink(f.log, f.fs)
-	}
-	return nil
-}
+	// builtin types are unique, so registering them does not fail
+	f.RegisterVault(AgeVaultType, func() core.VaultAccessorPort {
+		return NewAgeVault(f.log, f.fs)
+	})
+	f.RegisterVault(AzureKeyVaultType, func() core.VaultAccessorPort {
+		return NewAzureKeyVault(f.log)
+	})
+	f.RegisterVault(AWSSecretsManagerType, func() core.VaultAccessorPort {
+		return NewAWSSecretsManager(f.log)
+	})
+	f.RegisterVault(AWSSSMType, func() core.VaultAccessorPort {
+		return NewAWSSSM(f.log)
+	})
+	f.RegisterVault(EnvVaultType, func() core.VaultAccessorPort {
+		return NewEnvVault(f.log, f.fs)
+	})
+	f.RegisterVault(ExecVaultType, func() core.VaultAccessorPort {
+		return NewExecVault(f.log)
+	})
+	f.RegisterVault(FileVaultType, func() core.VaultAccessorPort {
+		return NewFileVault(f.log, f.fs)
+	})
+	f.RegisterVault(GCPSecretManagerType, func() core.VaultAccessorPort {
+		return NewGCPSecretManager(f.log)
+	})
+	f.RegisterVault(SopsFileType, func() core.VaultAccessorPort {
+		return NewSopsFile(f.log, f.fs)
+	})
 
-// NewTransformation creates a new transformation for a supported type
-func (f *BuiltinFactory) NewTransformation(transformationType string) core.TransformationPort {
-	switch transformationType {
-	case TemplateTransformationType:
+	f.RegisterTransformation(TemplateTransformationType, func() core.TransformationPort {
 		return NewTemplateTransformation(f.log)
-	case AgeEncryptTransformationType:
+	})
+	f.RegisterTransformation(AgeEncryptTransformationType, func() core.TransformationPort {
 		return NewAgeEncryptTransformation(f.log)
-	case JQTransformationType:
+	})
+	f.RegisterTransformation(JQTransformationType, func() core.TransformationPort {
 		return NewJQTransformation(f.log)
-	}
-	return nil
-}
+	})
 
-// NewVaultAccessor creates a new vault accessor for a supported type
-func (f *BuiltinFactory) NewVaultAccessor(vaultType string) core.VaultAccessorPort {
-	switch vaultType {
-	case AgeVaultType:
-		return NewAgeVault(f.log, f.fs)
-	case AzureKeyVaultType:
-		return NewAzureKeyVault(f.log)
-	case AWSSecretsManagerType:
-		return NewAWSSecretsManager(f.log)
-	case GCPSecretManagerType:
-		return NewGCPSecretManager(f.log)
-	}
-	return nil
+	f.RegisterSink(FileSinkType, func() core.SinkWriterPort {
+		return NewFileSink(f.log, f.fs)
+	})
+	f.RegisterSink(CollectSinkType, func() core.SinkWriterPort {
+		return NewCollectSink(f.log)
+	})
+
+	return f
 }
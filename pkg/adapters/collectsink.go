@@ -0,0 +1,121 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"sync"
+)
+
+// CollectSinkType is the type of the collect sink
+const CollectSinkType = "collect"
+
+// CollectSinkSpec is the spec of the collect sink, it has no properties
+type CollectSinkSpec struct {
+}
+
+// Collector holds the secrets written to collect sinks, by variable name
+type Collector struct {
+	mu      sync.Mutex
+	secrets map[string]*core.Secret
+}
+
+// NewCollector creates an empty collector
+func NewCollector() *Collector {
+	return &Collector{
+		secrets: make(map[string]*core.Secret),
+	}
+}
+
+// Put (re)places the secret of a variable
+func (c *Collector) Put(varName string, secret *core.Secret) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.secrets[varName] = secret
+}
+
+// Get returns the secret of a variable, or nil
+func (c *Collector) Get(varName string) *core.Secret {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.secrets[varName]
+}
+
+// Secrets returns a copy of all collected secrets, by variable name
+func (c *Collector) Secrets() map[string]*core.Secret {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make(map[string]*core.Secret, len(c.secrets))
+	for k, v := range c.secrets {
+		res[k] = v
+	}
+	return res
+}
+
+type collectorKey struct{}
+
+// WithCollector returns a context carrying the collector that collect sinks write to
+func WithCollector(ctx context.Context, c *Collector) context.Context {
+	return context.WithValue(ctx, collectorKey{}, c)
+}
+
+// CollectorFrom returns the collector of the context, or nil
+func CollectorFrom(ctx context.Context) *Collector {
+	c, _ := ctx.Value(collectorKey{}).(*Collector)
+	return c
+}
+
+// CollectSink keeps secrets in memory instead of writing them somewhere. It puts them
+// into the Collector of the context, see WithCollector.
+type CollectSink struct {
+	log *logging.Logger
+}
+
+// NewCollectSink creates a new CollectSink
+func NewCollectSink(log *logging.Logger) *CollectSink {
+	return &CollectSink{
+		log: log,
+	}
+}
+
+// SpecSchema returns the schema of CollectSinkSpec
+func (s *CollectSink) SpecSchema() *core.Schema {
+	return core.SchemaOf(CollectSinkSpec{})
+}
+
+// ValidateSpec checks if given spec can be decoded into a CollectSinkSpec
+func (s *CollectSink) ValidateSpec(in map[interface{}]interface{}) error {
+	var spec CollectSinkSpec
+	return core.DecodeSpec(in, &spec)
+}
+
+// Write puts the secret into the collector of the context
+func (s *CollectSink) Write(ctx context.Context, defaults *core.Defaults, secret *core.Secret, sink *core.Sink) error {
+	c := CollectorFrom(ctx)
+	if c == nil {
+		return errors.New("no collector in context, see adapters.WithCollector")
+	}
+	c.Put(sink.Var, secret)
+
+	s.log.Debug("Collected secret", logging.Sink(CollectSinkType), logging.Var(sink.Var))
+
+	return nil
+}
+
+// Remove removes the variable from the collector of the context
+func (s *CollectSink) Remove(ctx context.Context, defaults *core.Defaults, sink *core.Sink) error {
+	c := CollectorFrom(ctx)
+	if c == nil {
+		return errors.New("no collector in context, see adapters.WithCollector")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.secrets, sink.Var)
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// EnvVaultType is the type name for environment variable vaults
+const EnvVaultType = "env"
+
+// EnvVault is a core.VaultAccessorPort which reads secrets from environment variables of the
+// process, e.g. masked variables injected by CI systems.
+type EnvVault struct {
+	log *logging.Logger
+	fs  afero.Fs
+}
+
+// NewEnvVault creates a new environment variable vault. Files of _FILE variables are read from fs.
+func NewEnvVault(log *logging.Logger, fs afero.Fs) *EnvVault {
+	return &EnvVault{
+		log: log,
+		fs:  fs,
+	}
+}
+
+// EnvVaultSpec describes how names of secrets map to environment variables
+type EnvVaultSpec struct {
+	// Prefix is prepended to the names of secrets, e.g. APP_
+	Prefix string `yaml:"prefix"`
+
+	// Normalize converts names of secrets to upper case and replaces other characters than
+	// letters, digits and underscores by underscores, e.g. db-password to DB_PASSWORD
+	Normalize bool `yaml:"normalize"`
+}
+
+// NewEnvVaultSpec creates a new vault spec from the generic interface map
+func NewEnvVaultSpec(in map[interface{}]interface{}) (EnvVaultSpec, error) {
+	var res EnvVaultSpec
+	err := core.DecodeSpec(in, &res)
+	return res, err
+}
+
+// ValidateSpec checks if given spec can be decoded into an EnvVaultSpec
+func (v *EnvVault) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewEnvVaultSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of EnvVaultSpec
+func (v *EnvVault) SpecSchema() *core.Schema {
+	return core.SchemaOf(EnvVaultSpec{})
+}
+
+var envNameInvalidChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// variableName returns the name of the environment variable of a secret
+func (s *EnvVaultSpec) variableName(secret *core.Secret) string {
+	if !s.Normalize {
+		return s.Prefix + secret.Name
+	}
+	return s.Prefix + envNameInvalidChars.ReplaceAllString(strings.ToUpper(secret.Name), "_")
+}
+
+// RetrieveSecret reads the environment variable of the secret. If it is not set, but the
+// variable suffixed by _FILE is, the secret is read from the file it points to.
+func (v *EnvVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	spec, err := NewEnvVaultSpec(vault.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &core.Secret{
+		Name:      secret.Name,
+		Type:      secret.Type,
+		VaultName: secret.VaultName,
+	}
+
+	name := spec.variableName(secret)
+	value, isSet := os.LookupEnv(name)
+	fileName, isFileSet := os.LookupEnv(name + "_FILE")
+
+	switch {
+	case isSet && isFileSet:
+		return nil, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	case isSet:
+		res.RawContent = []byte(value)
+		v.log.Debug("Retrieved secret", logging.Type(EnvVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+			logging.String("env", name))
+	case isFileSet:
+		if res.RawContent, err = afero.ReadFile(v.fs, fileName); err != nil {
+			return nil, fmt.Errorf("unable to read %s_FILE: %w", name, err)
+		}
+		v.log.Debug("Retrieved secret", logging.Type(EnvVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+			logging.String("env", name+"_FILE"))
+	default:
+		return nil, core.ErrNotFound{Err: fmt.Errorf("environment variable %s is not set", name)}
+	}
+
+	return res, nil
+}
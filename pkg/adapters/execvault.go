@@ -0,0 +1,253 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecVaultType is the type name for vaults backed by external commands
+const ExecVaultType = "exec"
+
+// ExecProtocolVersion is the version of the json protocol between the exec vault and commands
+const ExecProtocolVersion = 1
+
+// DefaultExecTimeout limits a single run of a command, unless the spec sets a timeout
+const DefaultExecTimeout = 30 * time.Second
+
+// ExecVault is a core.VaultAccessorPort which runs an external command to retrieve a
+// secret, e.g. the CLI of a password manager such as pass, op, bw or gopass.
+type ExecVault struct {
+	log *logging.Logger
+}
+
+// NewExecVault creates a new exec vault
+func NewExecVault(log *logging.Logger) *ExecVault {
+	return &ExecVault{log: log}
+}
+
+// ExecVaultSpec describes the command of an exec vault and how to talk to it
+type ExecVaultSpec struct {
+	// Command is the executable and its arguments, it is not run by a shell
+	Command []string `yaml:"command" validate:"required,min=1"`
+
+	// Protocol is either args, passing the name of the secret as last argument and reading it
+	// from stdout, or json, exchanging an ExecRequest and an ExecResponse via stdin and stdout
+	Protocol string `yaml:"protocol" validate:"omitempty,oneof=args json"`
+
+	// Timeout limits a single run of the command, defaults to DefaultExecTimeout
+	Timeout core.Duration `yaml:"timeout" schema:"string|number" validate:"gte=0"`
+
+	// TrimNewline removes a trailing newline from the output of the args protocol
+	TrimNewline bool `yaml:"trimNewline"`
+}
+
+// ExecRequest is written as json to stdin of the command with the json protocol
+type ExecRequest struct {
+	// Version is the version of the protocol, i.e. ExecProtocolVersion
+	Version int `json:"version"`
+
+	// Vault is the name of the vault
+	Vault string `json:"vault"`
+
+	// Name is the name of the secret
+	Name string `json:"name"`
+
+	// Type is the type of the secret
+	Type string `json:"type"`
+}
+
+// ExecResponse is read as json from stdout of the command with the json protocol
+type ExecResponse struct {
+	// Value is the secret
+	Value string `json:"value"`
+
+	// Encoding is empty for plain text or base64 for binary values
+	Encoding string `json:"encoding,omitempty"`
+
+	// ContentType is the content type of the secret, e.g. application/json
+	ContentType string `json:"contentType,omitempty"`
+
+	// Error fails the retrieval with the message, if not empty
+	Error string `json:"error,omitempty"`
+
+	// Retryable marks the error as transient, so that retry policies apply
+	Retryable bool `json:"retryable,omitempty"`
+
+	// NotFound marks the error as a missing secret, so that optional secrets are absent
+	NotFound bool `json:"notFound,omitempty"`
+}
+
+// execError is an error of a command, which can be transient or denote a missing secret
+type execError struct {
+	msg       string
+	retryable bool
+	notFound  bool
+}
+
+func (e *execError) Error() string {
+	return e.msg
+}
+
+// Is returns true for os.ErrNotExist if the secret is missing
+func (e *execError) Is(target error) bool {
+	return e.notFound && target == os.ErrNotExist
+}
+
+// NewExecVaultSpec creates a new vault spec from the generic interface map
+func NewExecVaultSpec(in map[interface{}]interface{}) (ExecVaultSpec, error) {
+	var res ExecVaultSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return res, err
+	}
+
+	if res.Command[0] == "" {
+		return res, core.SpecError{Field: "command", Message: "executable must not be empty"}
+	}
+	if res.Protocol == "" {
+		res.Protocol = "args"
+	}
+	if res.TrimNewline && res.Protocol != "args" {
+		return res, core.SpecError{Field: "trimNewline", Message: "requires protocol args"}
+	}
+	if res.Timeout == 0 {
+		res.Timeout = core.Duration(DefaultExecTimeout)
+	}
+
+	return res, nil
+}
+
+// ValidateSpec checks if given spec can be decoded into an ExecVaultSpec
+func (v *ExecVault) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewExecVaultSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of ExecVaultSpec
+func (v *ExecVault) SpecSchema() *core.Schema {
+	return core.SchemaOf(ExecVaultSpec{})
+}
+
+// RetrieveSecret runs the command of the vault to retrieve the secret
+func (v *ExecVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	spec, err := NewExecVaultSpec(vault.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(spec.Timeout))
+	defer cancel()
+
+	args := spec.Command[1:]
+	stdin := &bytes.Buffer{}
+	if spec.Protocol == "args" {
+		args = append(append([]string{}, args...), secret.Name)
+	} else {
+		if err := json.NewEncoder(stdin).Encode(ExecRequest{
+			Version: ExecProtocolVersion,
+			Vault:   vault.Name,
+			Name:    secret.Name,
+			Type:    secret.Type,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(spec.Command[0], args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := runCommand(ctx, cmd); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &execError{msg: fmt.Sprintf("%s timed out after %s", spec.Command[0], spec.Timeout), retryable: true}
+		}
+		// a missing executable must not be mistaken for a missing secret
+		var errExit *exec.ExitError
+		if !errors.As(err, &errExit) {
+			return nil, fmt.Errorf("%s failed: %s", spec.Command[0], err)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s failed: %w: %s", spec.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s failed: %w", spec.Command[0], err)
+	}
+
+	res := &core.Secret{
+		Name:      secret.Name,
+		Type:      secret.Type,
+		VaultName: secret.VaultName,
+	}
+
+	if spec.Protocol == "args" {
+		res.RawContent = stdout.Bytes()
+		if spec.TrimNewline {
+			res.RawContent = bytes.TrimSuffix(bytes.TrimSuffix(res.RawContent, []byte("\n")), []byte("\r"))
+		}
+	} else {
+		var resp ExecResponse
+		if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+			return nil, fmt.Errorf("invalid response of %s: %w", spec.Command[0], err)
+		}
+		if resp.Error != "" {
+			return nil, &execError{msg: fmt.Sprintf("%s failed: %s", spec.Command[0], resp.Error), retryable: resp.Retryable,
+				notFound: resp.NotFound}
+		}
+
+		switch resp.Encoding {
+		case "":
+			res.RawContent = []byte(resp.Value)
+		case "base64":
+			if res.RawContent, err = base64.StdEncoding.DecodeString(resp.Value); err != nil {
+				return nil, fmt.Errorf("invalid response of %s: %w", spec.Command[0], err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid response of %s: unknown encoding %s", spec.Command[0], resp.Encoding)
+		}
+		res.RawContentType = resp.ContentType
+	}
+
+	v.log.Debug("Retrieved secret", logging.Type(ExecVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name))
+
+	return res, nil
+}
+
+// runCommand runs cmd until it exits or ctx is done. Then the process group of cmd is killed,
+// so that processes started by it do not keep its output open.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		return <-done
+	}
+}
+
+// IsRetryable returns true for timeouts and errors marked as retryable by the command
+func (v *ExecVault) IsRetryable(err error) bool {
+	var eerr *execError
+	return errors.As(err, &eerr) && eerr.retryable
+}
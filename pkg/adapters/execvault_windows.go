@@ -0,0 +1,13 @@
+package adapters
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing, as there are no process groups to kill on windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command only
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
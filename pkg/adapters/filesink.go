@@ -3,10 +3,12 @@ package adapters
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
@@ -15,86 +17,87 @@ const FileSinkType = "file"
 
 // FileSinkSpec is a specialisation of the SinkSpec interface for file sink
 type FileSinkSpec struct {
-	Path    string  `yaml:"path" validate:"required"`
-	Mode    *uint32 `yaml:"mode,omitempty" validate:"required"`
-	UserID  *int    `yaml:"user,omitempty" validate:"required"`
-	GroupID *int    `yaml:"group,omitempty" validate:"required"`
+	Path string `yaml:"path" validate:"required"`
+
+	// Mode is the file mode, written as octal digits, e.g. 640 or 0640
+	Mode    *uint32 `yaml:"mode,omitempty" schema:"integer|string"`
+	UserID  *int    `yaml:"user,omitempty" schema:"integer|string"`
+	GroupID *int    `yaml:"group,omitempty" schema:"integer|string"`
 }
 
 // FileSink is a file-based sink endpoint, where secrets are written to files
 type FileSink struct {
-	log *log.Logger
+	log *logging.Logger
 	fs  afero.Fs
 }
 
 // NewFileSink creates a new FileSink, based on given Afero file system and a logger
-func NewFileSink(log *log.Logger, fs afero.Fs) *FileSink {
+func NewFileSink(log *logging.Logger, fs afero.Fs) *FileSink {
 	return &FileSink{
 		log: log,
 		fs:  fs,
 	}
 }
 
+// SpecSchema returns the schema of FileSinkSpec
+func (s *FileSink) SpecSchema() *core.Schema {
+	return core.SchemaOf(FileSinkSpec{})
+}
+
 // NewFileSinkSpec creates a FileSinkSpec struct from abstract map
 func NewFileSinkSpec(in map[interface{}]interface{}) (FileSinkSpec, error) {
 	var res FileSinkSpec
-
-	var defaultMode uint32 = 400
-	res.Mode = &defaultMode
-
-	v, ex := in["path"]
-	if !ex {
-		return res, errors.New("must provide a path element for a file sink spec")
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return res, err
 	}
-	res.Path = v.(string)
 
-	v, ex = in["mode"]
-	if ex {
-		vn, err := stringOrIntToI(v)
-		if err != nil {
-			return res, errors.New("mode parameter in file sink spec must be string or integer")
-		}
-		var vn2 uint32 = uint32(vn)
-		res.Mode = &vn2
-	}
-	v, ex = in["user"]
-	if ex {
-		vn, err := stringOrIntToI(v)
-		if err != nil {
-			return res, errors.New("user parameter in file sink spec must be string or integer")
-		}
-		res.UserID = &vn
+	if res.Mode == nil {
+		var defaultMode uint32 = 400
+		res.Mode = &defaultMode
 	}
-	v, ex = in["group"]
-	if ex {
-		vn, err := stringOrIntToI(v)
-		if err != nil {
-			return res, errors.New("group parameter in file sink spec must be string or integer")
-		}
-		res.GroupID = &vn
+	if _, err := res.FileMode(); err != nil {
+		return res, core.SpecError{Field: "mode", Message: "must consist of octal digits, e.g. 640"}
 	}
 
 	return res, nil
 }
 
-func stringOrIntToI(v interface{}) (int, error) {
-	var vn int
-	var err error
+// FileMode returns the file mode given by the octal digits of Mode
+func (s FileSinkSpec) FileMode() (os.FileMode, error) {
+	m, err := strconv.ParseUint(strconv.FormatUint(uint64(*s.Mode), 10), 8, 32)
+	if err != nil || m > 07777 {
+		return 0, fmt.Errorf("invalid file mode %d", *s.Mode)
+	}
+	return os.FileMode(m), nil
+}
 
-	vs, ok := v.(string)
-	if ok {
-		vn, err = strconv.Atoi(vs)
-		if err != nil {
-			return vn, err
-		}
-	} else {
-		vn, ok = v.(int)
-		if !ok {
-			return vn, errors.New("neither string nor int")
-		}
+// ValidateSpec checks if given spec can be decoded into a FileSinkSpec
+func (s *FileSink) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewFileSinkSpec(in)
+	return err
+}
+
+// LintSpec warns about file modes that make the secret readable by everyone
+func (s *FileSink) LintSpec(in map[interface{}]interface{}, vars []string) []core.SpecError {
+	spec, err := NewFileSinkSpec(in)
+	if err != nil {
+		return nil
 	}
 
-	return vn, nil
+	mode, _ := spec.FileMode()
+	if mode&0o004 != 0 {
+		return []core.SpecError{{Field: "mode", Message: fmt.Sprintf("mode %d (%s) makes the file world-readable", *spec.Mode, mode.Perm())}}
+	}
+	return nil
+}
+
+// Target returns the cleaned path of the file the sink writes to
+func (s *FileSink) Target(sink *core.Sink) string {
+	spec, err := NewFileSinkSpec(sink.Spec)
+	if err != nil || spec.Path == "" {
+		return ""
+	}
+	return filepath.Clean(spec.Path)
 }
 
 // Write writes secret to sink, sets owner and mode if given by spec
@@ -105,7 +108,12 @@ func (s *FileSink) Write(ctx context.Context, defaults *core.Defaults, secret *c
 		return err
 	}
 
-	f, err := s.fs.OpenFile(spec.Path, os.O_WRONLY|os.O_CREATE, os.FileMode(*spec.Mode))
+	mode, err := spec.FileMode()
+	if err != nil {
+		return err
+	}
+
+	f, err := s.fs.OpenFile(spec.Path, os.O_WRONLY|os.O_CREATE, mode)
 	if err != nil {
 		return err
 	}
@@ -129,13 +137,32 @@ func (s *FileSink) Write(ctx context.Context, defaults *core.Defaults, secret *c
 	}
 	if err := s.fs.Chown(spec.Path, uid, gid); err != nil {
 		if err2 := s.fs.Remove(spec.Path); err2 != nil {
-			s.log.Printf("Unable to chown %s to %d/%d, AND unable to delete file afterwards.", spec.Path, uid, gid)
+			s.log.Error("Unable to chown file, and unable to delete it afterwards", logging.Sink(FileSinkType),
+				logging.String("path", spec.Path), logging.Int("uid", int64(uid)), logging.Int("gid", int64(gid)), logging.Err(err2))
 		}
 
 		return err
 	}
 
-	s.log.Printf("Written secret \"%s\" to file %s, mode %d, chown-ed %d:%d\n", secret.Name, spec.Path, os.FileMode(*spec.Mode), uid, gid)
+	s.log.Debug("Written secret to file", logging.Sink(FileSinkType), logging.Var(sink.Var), logging.String("path", spec.Path),
+		logging.Int("mode", int64(*spec.Mode)), logging.Int("uid", int64(uid)), logging.Int("gid", int64(gid)))
+
+	return nil
+}
+
+// Remove deletes the file of the sink, if it exists
+func (s *FileSink) Remove(ctx context.Context, defaults *core.Defaults, sink *core.Sink) error {
+
+	spec, err := NewFileSinkSpec(sink.Spec)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.Remove(spec.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.log.Debug("Removed file", logging.Sink(FileSinkType), logging.Var(sink.Var), logging.String("path", spec.Path))
 
 	return nil
 }
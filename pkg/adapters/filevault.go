@@ -0,0 +1,248 @@
+package adapters
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileVaultType is the type name for plain file vaults
+const FileVaultType = "file"
+
+// FileVault is a core.VaultAccessorPort which reads secrets from plain, unencrypted files,
+// e.g. secrets mounted by Kubernetes or Docker, or fixtures for development.
+type FileVault struct {
+	log *logging.Logger
+	fs  afero.Fs
+}
+
+// NewFileVault creates a new file vault
+func NewFileVault(log *logging.Logger, fs afero.Fs) *FileVault {
+	return &FileVault{
+		log: log,
+		fs:  fs,
+	}
+}
+
+// FileVaultSpec describes where a file vault reads secrets from. Exactly one of Dir, Path
+// and Glob is required.
+type FileVaultSpec struct {
+	// Dir is a directory containing one file per secret, named like the secret
+	Dir string `yaml:"dir"`
+
+	// Path is a json, yaml or dotenv file, secrets are key paths within it
+	Path string `yaml:"path"`
+
+	// Format is one of json, yaml or dotenv. It defaults to the extension of Path.
+	Format string `yaml:"format" validate:"omitempty,oneof=json yaml dotenv"`
+
+	// Glob matches files, secrets are the base names of the files
+	Glob string `yaml:"glob"`
+}
+
+// NewFileVaultSpec creates a new vault spec from the generic interface map
+func NewFileVaultSpec(in map[interface{}]interface{}) (FileVaultSpec, error) {
+	var res FileVaultSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return res, err
+	}
+
+	n := 0
+	for _, s := range []string{res.Dir, res.Path, res.Glob} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return res, core.SpecError{Field: "dir", Message: "exactly one of dir, path or glob is required"}
+	}
+	if res.Format != "" && res.Path == "" {
+		return res, core.SpecError{Field: "format", Message: "requires path"}
+	}
+	if _, err := filepath.Match(res.Glob, ""); err != nil {
+		return res, core.SpecError{Field: "glob", Message: err.Error()}
+	}
+	if res.Path != "" && res.Format == "" {
+		switch strings.ToLower(filepath.Ext(res.Path)) {
+		case ".json":
+			res.Format = "json"
+		case ".env":
+			res.Format = "dotenv"
+		default:
+			res.Format = "yaml"
+		}
+	}
+
+	return res, nil
+}
+
+// ValidateSpec checks if given spec can be decoded into a FileVaultSpec
+func (v *FileVault) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewFileVaultSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of FileVaultSpec
+func (v *FileVault) SpecSchema() *core.Schema {
+	return core.SchemaOf(FileVaultSpec{})
+}
+
+// RetrieveSecret reads a secret from the file named like the secret within dir, from
+// the key path given by the name within the file at path, or from the file matching
+// glob with the name as base name.
+func (v *FileVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	spec, err := NewFileVaultSpec(vault.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &core.Secret{
+		Name:      secret.Name,
+		Type:      secret.Type,
+		VaultName: secret.VaultName,
+	}
+
+	var name string
+	switch {
+	case spec.Dir != "":
+		name, err = v.fileInDir(spec.Dir, secret.Name)
+	case spec.Glob != "":
+		name, err = v.fileOfGlob(spec.Glob, secret.Name)
+	default:
+		res.RawContent, res.RawContentType, err = v.readKeyPath(spec, secret.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find secret %s in vault %s: %w", secret.Name, vault.Name, err)
+		}
+		v.log.Debug("Retrieved secret", logging.Type(FileVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+			logging.String("path", spec.Path))
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find secret %s in vault %s: %w", secret.Name, vault.Name, err)
+	}
+
+	res.RawContent, err = afero.ReadFile(v.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	v.log.Debug("Retrieved secret", logging.Type(FileVaultType), logging.Vault(vault.Name), logging.Secret(secret.Name),
+		logging.String("path", name))
+
+	return res, nil
+}
+
+// fileInDir returns the file of a secret within dir. Names may contain subdirectories,
+// but must not leave dir.
+func (v *FileVault) fileInDir(dir, name string) (string, error) {
+	clean := path.Clean("/" + filepath.ToSlash(name))
+	if clean == "/" || clean != "/"+filepath.ToSlash(name) {
+		return "", fmt.Errorf("invalid file name %s", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// fileOfGlob returns the only file matching glob with name as base name
+func (v *FileVault) fileOfGlob(glob, name string) (string, error) {
+	matches, err := afero.Glob(v.fs, glob)
+	if err != nil {
+		return "", err
+	}
+
+	var res []string
+	for _, m := range matches {
+		if filepath.Base(m) != name {
+			continue
+		}
+		if fi, err := v.fs.Stat(m); err != nil || fi.IsDir() {
+			continue
+		}
+		res = append(res, m)
+	}
+
+	switch len(res) {
+	case 0:
+		return "", fmt.Errorf("no file %s matches %s: %w", name, glob, os.ErrNotExist)
+	case 1:
+		return res[0], nil
+	}
+	return "", fmt.Errorf("file name %s is ambiguous, it matches %s", name, strings.Join(res, ", "))
+}
+
+// readKeyPath reads the document at spec.Path and returns the value at key path name
+func (v *FileVault) readKeyPath(spec FileVaultSpec, name string) ([]byte, string, error) {
+	b, err := afero.ReadFile(v.fs, spec.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var doc interface{}
+	if spec.Format == "dotenv" {
+		if doc, err = parseDotenv(b); err != nil {
+			return nil, "", fmt.Errorf("unable to parse %s: %w", spec.Path, err)
+		}
+	} else {
+		var structured bool
+		if doc, structured, err = parseDocument(b); err != nil {
+			return nil, "", fmt.Errorf("unable to parse %s: %w", spec.Path, err)
+		} else if !structured {
+			return nil, "", fmt.Errorf("%s is not a %s object or list", spec.Path, spec.Format)
+		}
+	}
+
+	value, err := lookupKeyPath(doc, name)
+	if err != nil {
+		return nil, "", err
+	}
+	return encodeValue(value)
+}
+
+// parseDotenv parses KEY=VALUE lines, optionally prefixed by export. Double-quoted values
+// may contain escape sequences like \n, single-quoted values are taken literally.
+func parseDotenv(b []byte) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			// strips trailing comments of unquoted values
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		res[key] = value
+	}
+
+	return res, s.Err()
+}
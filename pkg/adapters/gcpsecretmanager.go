@@ -3,10 +3,17 @@ package adapters
 import (
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
 	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
-	"log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // GCPSecretManagerType is the type name as it appears in the configuration
@@ -17,30 +24,115 @@ const GCPSecretManagerSpeccProjectID = "projectID"
 
 // GCPSecretManager is an adapter for GCP Secret Manager
 type GCPSecretManager struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // NewGCPSecretManager returns a new instance of GCPSecretManager.
-func NewGCPSecretManager(log *log.Logger) *GCPSecretManager {
+func NewGCPSecretManager(log *logging.Logger) *GCPSecretManager {
 	return &GCPSecretManager{log: log}
 }
 
-// GCPSecretManagerSpec is the configuration for the GCP Secret Manager adapter
+// GCPSecretManagerSpec is the configuration for the GCP Secret Manager adapter. Without
+// credentials, application default credentials are used.
 type GCPSecretManagerSpec struct {
-	ProjectID string `json:"project_id" validate:"required"`
+	ProjectID string `yaml:"projectID" validate:"required"`
+
+	// CredentialsFile is the path to a service account key or other credentials file
+	CredentialsFile string `yaml:"credentialsFile"`
+
+	// Credentials is the json of a service account key or other credentials, e.g. taken
+	// from another vault
+	Credentials string `yaml:"credentials"`
+
+	// ImpersonateServiceAccount is the email of a service account to impersonate
+	ImpersonateServiceAccount string `yaml:"impersonateServiceAccount"`
+
+	// Delegates is the chain of service accounts to impersonate it through, if any
+	Delegates []string `yaml:"delegates"`
+
+	// Endpoint overrides the gRPC endpoint of the service as host:port, e.g. for emulators
+	Endpoint string `yaml:"endpoint"`
+
+	// WithoutAuthentication disables authentication, e.g. for emulators
+	WithoutAuthentication bool `yaml:"withoutAuthentication"`
+
+	TLSSpec `yaml:",inline"`
 }
 
 // NewGCPSecretManagerSpec creates a new instance of GCPSecretManagerSpec from a generic map
 func NewGCPSecretManagerSpec(in map[interface{}]interface{}) (*GCPSecretManagerSpec, error) {
 	var res GCPSecretManagerSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Delegates) > 0 && res.ImpersonateServiceAccount == "" {
+		return nil, core.SpecError{Field: "delegates", Message: "requires impersonateServiceAccount"}
+	}
+	if res.CredentialsFile != "" && res.Credentials != "" {
+		return nil, core.SpecError{Field: "credentials", Message: "cannot be combined with credentialsFile"}
+	}
+	if res.WithoutAuthentication && (res.CredentialsFile != "" || res.Credentials != "" || res.ImpersonateServiceAccount != "") {
+		return nil, core.SpecError{Field: "withoutAuthentication", Message: "cannot be combined with credentials"}
+	}
+	return &res, nil
+}
 
-	v, ex := in[GCPSecretManagerSpeccProjectID]
-	if !ex {
-		return nil, fmt.Errorf("%s is required", GCPSecretManagerSpeccProjectID)
+// clientOptions returns the options for clients connecting to the endpoint and authenticating
+// with the credentials of the spec
+func (s *GCPSecretManagerSpec) clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	res := make([]option.ClientOption, 0)
+	if s.Endpoint != "" {
+		res = append(res, option.WithEndpoint(s.Endpoint))
+	}
+	if s.isSet() {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))))
 	}
-	res.ProjectID = v.(string)
 
-	return &res, nil
+	switch {
+	case s.WithoutAuthentication:
+		res = append(res, option.WithoutAuthentication())
+	case s.ImpersonateServiceAccount != "":
+		credOpts := s.credentialsOptions()
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: s.ImpersonateServiceAccount,
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+			Delegates:       s.Delegates,
+		}, credOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to impersonate %s: %w", s.ImpersonateServiceAccount, err)
+		}
+		res = append(res, option.WithTokenSource(ts))
+	default:
+		res = append(res, s.credentialsOptions()...)
+	}
+
+	return res, nil
+}
+
+// credentialsOptions returns the options for the credentials given by the spec, if any
+func (s *GCPSecretManagerSpec) credentialsOptions() []option.ClientOption {
+	switch {
+	case s.CredentialsFile != "":
+		return []option.ClientOption{option.WithCredentialsFile(s.CredentialsFile)}
+	case s.Credentials != "":
+		return []option.ClientOption{option.WithCredentialsJSON([]byte(s.Credentials))}
+	}
+	return nil
+}
+
+// ValidateSpec checks if given spec can be decoded into a GCPSecretManagerSpec
+func (v *GCPSecretManager) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewGCPSecretManagerSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of GCPSecretManagerSpec
+func (v *GCPSecretManager) SpecSchema() *core.Schema {
+	return core.SchemaOf(GCPSecretManagerSpec{})
 }
 
 // RetrieveSecret retrieves a secret from GCP Secret Manager.
@@ -52,9 +144,14 @@ func (v *GCPSecretManager) RetrieveSecret(ctx context.Context, defaults *core.De
 		return nil, err
 	}
 
-	client, err := secretmanager.NewClient(ctx)
+	opts, err := spec.clientOptions(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("GCPSecretManager: failed to create secretmanager client: %v", err)
+		return nil, fmt.Errorf("GCPSecretManager: %w", err)
+	}
+
+	client, err := secretmanager.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("GCPSecretManager: failed to create secretmanager client: %w", err)
 	}
 	defer client.Close()
 
@@ -64,10 +161,14 @@ func (v *GCPSecretManager) RetrieveSecret(ctx context.Context, defaults *core.De
 
 	result, err := client.AccessSecretVersion(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("GCPSecretManager: failed to access secret version: %v", err)
+		err = fmt.Errorf("GCPSecretManager: failed to access secret version: %w", err)
+		if gcpStatusCode(err) == codes.NotFound {
+			return nil, core.ErrNotFound{Err: err}
+		}
+		return nil, err
 	}
 
-	v.log.Printf("GCPSecretManager[%s]: Retrieved secret name=%s\n", vault.Name, secret.Name)
+	v.log.Debug("Retrieved secret", logging.Type(GCPSecretManagerType), logging.Vault(vault.Name), logging.Secret(secret.Name))
 
 	return &core.Secret{
 		RawContent:     []byte(result.Payload.Data),
@@ -77,3 +178,22 @@ func (v *GCPSecretManager) RetrieveSecret(ctx context.Context, defaults *core.De
 		VaultName:      secret.VaultName,
 	}, nil
 }
+
+// IsRetryable returns true for the gRPC status codes UNAVAILABLE, RESOURCE_EXHAUSTED,
+// ABORTED and DEADLINE_EXCEEDED.
+func (v *GCPSecretManager) IsRetryable(err error) bool {
+	switch gcpStatusCode(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// gcpStatusCode returns the gRPC status code of err, or OK if it has none
+func gcpStatusCode(err error) codes.Code {
+	var serr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &serr) {
+		return codes.OK
+	}
+	return serr.GRPCStatus().Code()
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"github.com/itchyny/gojq"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"strings"
 )
 
@@ -15,67 +15,56 @@ const JQTransformationType = "jq"
 
 // JQTransformation is a transformation adapter that uses jq to transform the input
 type JQTransformation struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // NewJQTransformation creates a new JQTransformation
-func NewJQTransformation(log *log.Logger) *JQTransformation {
+func NewJQTransformation(log *logging.Logger) *JQTransformation {
 	return &JQTransformation{log: log}
 }
 
 // JQTransformationSpec contains the specification items for the jq transformation
 type JQTransformationSpec struct {
 	// Query is the jq query string
-	Query string `json:"query" validate:"required"`
+	Query string `yaml:"q" validate:"required"`
 
 	// If raw is set, the result is rendered as a string and not as a json. This works
 	// only for simple values, not for arrays or objects.
-	Raw bool `json:"raw" validate:"required"`
+	Raw bool `yaml:"raw"`
 
 	// Content Type of rendered output (default: application/json for raw=false, text/plain for raw=true)
-	ContentType string
+	ContentType string `yaml:"contentType"`
 
 	query *gojq.Query
 }
 
 // NewJQTransformationSpec creates a new JQTransformationSpec
 func NewJQTransformationSpec(in map[interface{}]interface{}) (JQTransformationSpec, error) {
-	var spec JQTransformationSpec
-
-	qs, ok := in["q"]
-	if !ok {
-		return JQTransformationSpec{}, fmt.Errorf("q (query) element is required")
+	spec := JQTransformationSpec{
+		ContentType: "application/json",
 	}
-	q, ok := qs.(string)
-	if !ok {
-		return JQTransformationSpec{}, fmt.Errorf("q (query) element must be a string")
+	if err := core.DecodeSpec(in, &spec); err != nil {
+		return JQTransformationSpec{}, err
 	}
+
 	var err error
-	spec.query, err = gojq.Parse(q)
+	spec.query, err = gojq.Parse(spec.Query)
 	if err != nil {
-		return JQTransformationSpec{}, err
+		return JQTransformationSpec{}, core.SpecError{Field: "q", Message: err.Error()}
 	}
 
-	raw, ok := in["raw"]
-	if ok {
-		rawBool, ok := raw.(bool)
-		if ok {
-			spec.Raw = rawBool
-		}
-	}
+	return spec, nil
+}
 
-	spec.Query = q
-	var contentType = "application/json"
-	cnt, ok := in["contentType"]
-	if ok {
-		cntStr, ok := cnt.(string)
-		if ok {
-			contentType = cntStr
-		}
-	}
-	spec.ContentType = contentType
+// ValidateSpec checks if given spec can be decoded into a JQTransformationSpec
+func (t *JQTransformation) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewJQTransformationSpec(in)
+	return err
+}
 
-	return spec, nil
+// SpecSchema returns the schema of JQTransformationSpec
+func (t *JQTransformation) SpecSchema() *core.Schema {
+	return core.SchemaOf(JQTransformationSpec{})
 }
 
 // ProcessSecret returns a new secret as the result of a json query process
@@ -116,7 +105,8 @@ func (t *JQTransformation) ProcessSecret(ctx context.Context,
 			if ok {
 				bOut.WriteString(bStr)
 			} else {
-				t.log.Printf("Warning: Unable to convert result of jq query to string: %v", v)
+				t.log.Warn("Unable to convert result of jq query to string", logging.Transformation(JQTransformationType),
+					logging.Var(transformation.Output), logging.String("resultType", fmt.Sprintf("%T", v)))
 			}
 		} else {
 			contentType = "application/json"
@@ -0,0 +1,145 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	yamlv3 "gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// lookupKeyPath returns the value at a key path within a decoded document of maps and lists.
+// Paths are either JSON pointers, e.g. /db/password, or dotted, e.g. db.password, where a key
+// containing dots takes precedence over nested keys. Elements of lists are addressed by index.
+func lookupKeyPath(doc interface{}, path string) (interface{}, error) {
+	if strings.HasPrefix(path, "/") {
+		segments := strings.Split(path[1:], "/")
+		for i, s := range segments {
+			segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(s)
+		}
+		return lookupSegments(doc, segments, path)
+	}
+
+	if m, ok := doc.(map[string]interface{}); ok {
+		if v, ex := m[path]; ex {
+			return v, nil
+		}
+	}
+	return lookupSegments(doc, strings.Split(path, "."), path)
+}
+
+func lookupSegments(doc interface{}, segments []string, path string) (interface{}, error) {
+	cur := doc
+	for i, s := range segments {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ex := v[s]
+			if !ex {
+				return nil, core.ErrNotFound{Err: fmt.Errorf("key %s not found", path)}
+			}
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(s)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, core.ErrNotFound{Err: fmt.Errorf("key %s not found, %s is not a valid index", path, s)}
+			}
+			cur = v[idx]
+		default:
+			return nil, core.ErrNotFound{Err: fmt.Errorf("key %s not found, %s is not an object", path,
+				strings.Join(segments[:i], "."))}
+		}
+	}
+	return cur, nil
+}
+
+// encodeValue returns the content and content type of a value within a document. Strings are
+// returned as they are, objects and lists as JSON.
+func encodeValue(v interface{}) ([]byte, string, error) {
+	switch vv := v.(type) {
+	case string:
+		return []byte(vv), "", nil
+	case []byte:
+		return vv, "", nil
+	case nil:
+		return []byte{}, "", nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(vv)
+		if err != nil {
+			return nil, "", err
+		}
+		return b, "application/json", nil
+	}
+	return []byte(fmt.Sprint(v)), "", nil
+}
+
+// parseDocument parses a document as json or yaml. It returns false if the document is not
+// an object or list, e.g. a plain text secret or text that is neither json nor yaml. It
+// returns an error only for a yaml mapping that cannot be decoded, e.g. because of duplicate
+// keys, so that a malformed document is never mistaken for plain text.
+func parseDocument(b []byte) (interface{}, bool, error) {
+	var doc interface{}
+
+	// numbers are kept as they are written
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil || d.More() {
+		doc = nil
+		if err := yamlv3.Unmarshal(b, &doc); err != nil {
+			var node yamlv3.Node
+			if yamlv3.Unmarshal(b, &node) == nil && len(node.Content) > 0 && node.Content[0].Kind == yamlv3.MappingNode {
+				return nil, false, err
+			}
+			return nil, false, nil
+		}
+	}
+
+	switch doc.(type) {
+	case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+		res, err := normalizeKeys(doc)
+		if err != nil {
+			return nil, false, err
+		}
+		return res, true, nil
+	}
+	return nil, false, nil
+}
+
+// normalizeKeys converts maps with non-string keys, which yaml decodes to
+// map[interface{}]interface{}, to maps with string keys
+func normalizeKeys(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			key := fmt.Sprint(k)
+			if _, ex := res[key]; ex {
+				return nil, fmt.Errorf("mapping key %s defined more than once", key)
+			}
+			ne, err := normalizeKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = ne
+		}
+		return res, nil
+	case map[string]interface{}:
+		for k, e := range vv {
+			ne, err := normalizeKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			vv[k] = ne
+		}
+	case []interface{}:
+		for i, e := range vv {
+			ne, err := normalizeKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			vv[i] = ne
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,434 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// PluginProtocolVersion is the version of the protocol between go-secretshelper and plugins
+const PluginProtocolVersion = 1
+
+// pluginMethodNotFound is the JSON-RPC error code of unknown methods
+const pluginMethodNotFound = -32601
+
+// pluginCallTimeout limits calls without a context of their own, i.e. initialize and validateSpec
+const pluginCallTimeout = 30 * time.Second
+
+// PluginFactory is a core.Factory for the vault, transformation and sink types served by a
+// plugin. A plugin is an executable speaking JSON-RPC 2.0 over its stdin and stdout, one
+// message per line. It runs until Close is called. See docs/plugins.md for the protocol.
+type PluginFactory struct {
+	log     *logging.Logger
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *json.Decoder
+
+	// mu serializes calls, as a plugin handles one request at a time
+	mu     sync.Mutex
+	nextID int64
+
+	// broken is set when the plugin was killed for not responding in time
+	broken error
+
+	manifest pluginManifest
+}
+
+// pluginManifest is the result of the initialize method, listing the types a plugin serves
+type pluginManifest struct {
+	ProtocolVersion int          `json:"protocolVersion"`
+	Vaults          []pluginType `json:"vaults"`
+	Transformations []pluginType `json:"transformations"`
+	Sinks           []pluginType `json:"sinks"`
+}
+
+// pluginType is a type served by a plugin, along with the optional schema of its spec
+type pluginType struct {
+	Type   string       `json:"type"`
+	Schema *core.Schema `json:"schema,omitempty"`
+}
+
+type pluginRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type pluginResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *PluginError    `json:"error"`
+}
+
+// PluginError is an error returned by a plugin
+type PluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		// Retryable marks the error as transient, so that retry policies apply
+		Retryable bool `json:"retryable"`
+
+		// Field is the field of an invalid spec
+		Field string `json:"field"`
+
+		// NotFound marks the error as a missing secret, so that optional secrets are absent
+		NotFound bool `json:"notFound"`
+	} `json:"data"`
+}
+
+func (e *PluginError) Error() string {
+	return e.Message
+}
+
+// Is returns true for os.ErrNotExist if the plugin marked the error as a missing secret
+func (e *PluginError) Is(target error) bool {
+	return e.Data.NotFound && target == os.ErrNotExist
+}
+
+// pluginSecret is a secret as exchanged with plugins, its value is encoded as base64
+type pluginSecret struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Value       []byte `json:"value"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// StartPlugin starts the plugin executable and asks it for the types it serves
+func StartPlugin(log *logging.Logger, command string, args ...string) (*PluginFactory, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = log.Writer(logging.LevelInfo, logging.String("plugin", command))
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start plugin %s: %w", command, err)
+	}
+
+	res := &PluginFactory{
+		log:     log,
+		command: command,
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  json.NewDecoder(stdout),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCallTimeout)
+	defer cancel()
+	err = res.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": PluginProtocolVersion,
+	}, &res.manifest)
+	if err == nil && res.manifest.ProtocolVersion != PluginProtocolVersion {
+		err = fmt.Errorf("unsupported protocol version %d", res.manifest.ProtocolVersion)
+	}
+	if err != nil {
+		res.Close()
+		return nil, fmt.Errorf("unable to initialize plugin %s: %w", command, err)
+	}
+
+	log.Info("Started plugin", logging.String("plugin", command),
+		logging.Strings("vaults", pluginTypeNames(res.manifest.Vaults)),
+		logging.Strings("transformations", pluginTypeNames(res.manifest.Transformations)),
+		logging.Strings("sinks", pluginTypeNames(res.manifest.Sinks)))
+
+	return res, nil
+}
+
+// Close closes stdin of the plugin, which has to exit then, and waits for it
+func (p *PluginFactory) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stdin.Close()
+	err := p.cmd.Wait()
+	if p.broken != nil {
+		// killed by call already
+		return nil
+	}
+	return err
+}
+
+// call sends a request to the plugin and decodes the result of its response into result. If
+// ctx is done before the plugin responds, the plugin is killed, as its next response would
+// belong to the abandoned request, and all further calls fail with a core.ErrFatal, which is
+// not retried.
+func (p *PluginFactory) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.broken != nil {
+		return p.broken
+	}
+
+	p.nextID++
+	b, err := json.Marshal(pluginRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	var resp pluginResponse
+	done := make(chan error, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(b, '\n')); err != nil {
+			done <- fmt.Errorf("unable to call plugin %s: %w", p.command, err)
+			return
+		}
+		if err := p.stdout.Decode(&resp); err != nil {
+			done <- fmt.Errorf("invalid response of plugin %s: %w", p.command, err)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		p.broken = core.ErrFatal{Err: fmt.Errorf("plugin %s was killed after not responding to %s: %w",
+			p.command, method, ctx.Err())}
+		_ = p.cmd.Process.Kill()
+		return p.broken
+	}
+
+	if resp.ID != p.nextID {
+		return fmt.Errorf("invalid response of plugin %s: expected id %d, got %d", p.command, p.nextID, resp.ID)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func pluginTypeNames(types []pluginType) []string {
+	res := make([]string, len(types))
+	for i, t := range types {
+		res[i] = t.Type
+	}
+	return res
+}
+
+// port returns the port of a type served by the plugin, or nil
+func (p *PluginFactory) port(kind string, types []pluginType, name string) *pluginPort {
+	for _, t := range types {
+		if t.Type == name {
+			schema := t.Schema
+			if schema == nil {
+				schema = &core.Schema{Type: "object"}
+			}
+			return &pluginPort{plugin: p, kind: kind, typeName: name, schema: schema}
+		}
+	}
+	return nil
+}
+
+// NewRepository creates a new repository
+func (p *PluginFactory) NewRepository() core.Repository {
+	return NewBuiltinRepository()
+}
+
+// SinkTypes returns the sink types served by the plugin
+func (p *PluginFactory) SinkTypes() []string {
+	return pluginTypeNames(p.manifest.Sinks)
+}
+
+// NewSinkWriter creates a sink writer for a type served by the plugin
+func (p *PluginFactory) NewSinkWriter(sinkType string) core.SinkWriterPort {
+	if port := p.port("sink", p.manifest.Sinks, sinkType); port != nil {
+		return &pluginSink{port}
+	}
+	return nil
+}
+
+// TransformationTypes returns the transformation types served by the plugin
+func (p *PluginFactory) TransformationTypes() []string {
+	return pluginTypeNames(p.manifest.Transformations)
+}
+
+// NewTransformation creates a transformation for a type served by the plugin
+func (p *PluginFactory) NewTransformation(transformationType string) core.TransformationPort {
+	if port := p.port("transformation", p.manifest.Transformations, transformationType); port != nil {
+		return &pluginTransformation{port}
+	}
+	return nil
+}
+
+// VaultAccessorTypes returns the vault types served by the plugin
+func (p *PluginFactory) VaultAccessorTypes() []string {
+	return pluginTypeNames(p.manifest.Vaults)
+}
+
+// NewVaultAccessor creates a vault accessor for a type served by the plugin
+func (p *PluginFactory) NewVaultAccessor(vaultType string) core.VaultAccessorPort {
+	if port := p.port("vault", p.manifest.Vaults, vaultType); port != nil {
+		return &pluginVault{port}
+	}
+	return nil
+}
+
+// pluginPort implements the optional interfaces shared by all ports of a plugin
+type pluginPort struct {
+	plugin   *PluginFactory
+	kind     string
+	typeName string
+	schema   *core.Schema
+}
+
+// SpecSchema returns the schema declared by the plugin, or an object schema
+func (p *pluginPort) SpecSchema() *core.Schema {
+	return p.schema
+}
+
+// ValidateSpec asks the plugin to validate a spec. Plugins not implementing validateSpec
+// accept all specs.
+func (p *pluginPort) ValidateSpec(in map[interface{}]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCallTimeout)
+	defer cancel()
+
+	err := p.plugin.call(ctx, "validateSpec", map[string]interface{}{
+		"kind": p.kind,
+		"type": p.typeName,
+		"spec": jsonCompatible(in),
+	}, nil)
+
+	var perr *PluginError
+	switch {
+	case errors.As(err, &perr) && perr.Code == pluginMethodNotFound:
+		return nil
+	case errors.As(err, &perr) && perr.Data.Field != "":
+		return core.SpecError{Field: perr.Data.Field, Message: perr.Message}
+	}
+	return err
+}
+
+// IsRetryable returns true for errors the plugin marked as retryable
+func (p *pluginPort) IsRetryable(err error) bool {
+	var perr *PluginError
+	return errors.As(err, &perr) && perr.Data.Retryable
+}
+
+type pluginVault struct {
+	*pluginPort
+}
+
+// RetrieveSecret calls retrieveSecret of the plugin
+func (p *pluginVault) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	var res pluginSecret
+	err := p.plugin.call(ctx, "retrieveSecret", map[string]interface{}{
+		"vault":  map[string]interface{}{"name": vault.Name, "type": vault.Type, "spec": jsonCompatible(vault.Spec)},
+		"secret": map[string]interface{}{"name": secret.Name, "type": secret.Type},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &core.Secret{
+		Name:           secret.Name,
+		Type:           secret.Type,
+		VaultName:      secret.VaultName,
+		RawContent:     res.Value,
+		RawContentType: res.ContentType,
+	}, nil
+}
+
+type pluginTransformation struct {
+	*pluginPort
+}
+
+// ProcessSecret calls processSecret of the plugin with all input secrets
+func (p *pluginTransformation) ProcessSecret(ctx context.Context, defaults *core.Defaults,
+	secrets *core.Secrets, transformation *core.Transformation) (*core.Secret, error) {
+
+	in := make([]pluginSecret, 0, len(*secrets))
+	for _, s := range *secrets {
+		in = append(in, pluginSecret{Name: s.Name, Type: s.Type, Value: s.RawContent, ContentType: s.RawContentType})
+	}
+
+	var res pluginSecret
+	err := p.plugin.call(ctx, "processSecret", map[string]interface{}{
+		"transformation": map[string]interface{}{
+			"type": transformation.Type,
+			"in":   transformation.Input,
+			"out":  transformation.Output,
+			"spec": jsonCompatible(transformation.Spec),
+		},
+		"secrets": in,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &core.Secret{
+		Name:           transformation.Output,
+		Type:           "transformed-by:" + transformation.Type,
+		RawContent:     res.Value,
+		RawContentType: res.ContentType,
+	}, nil
+}
+
+type pluginSink struct {
+	*pluginPort
+}
+
+// Write calls writeSink of the plugin
+func (p *pluginSink) Write(ctx context.Context, defaults *core.Defaults, secret *core.Secret, sink *core.Sink) error {
+	return p.plugin.call(ctx, "writeSink", map[string]interface{}{
+		"sink": map[string]interface{}{"type": sink.Type, "var": sink.Var, "spec": jsonCompatible(sink.Spec)},
+		"secret": pluginSecret{
+			Name:        secret.Name,
+			Type:        secret.Type,
+			Value:       secret.RawContent,
+			ContentType: secret.RawContentType,
+		},
+	}, nil)
+}
+
+// jsonCompatible converts the maps of yaml specs into maps with string keys, recursively
+func jsonCompatible(in interface{}) interface{} {
+	v := reflect.ValueOf(in)
+	switch v.Kind() {
+	case reflect.Map:
+		res := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			res[fmt.Sprint(k.Interface())] = jsonCompatible(v.MapIndex(k).Interface())
+		}
+		return res
+	case reflect.Slice:
+		if _, isBytes := in.([]byte); isBytes {
+			return in
+		}
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = jsonCompatible(v.Index(i).Interface())
+		}
+		return res
+	}
+	return in
+}
@@ -0,0 +1,626 @@
+package adapters
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+	yamlv3 "gopkg.in/yaml.v3"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SopsFileType is the type name for sops-encrypted files
+const SopsFileType = "sops-file"
+
+// SopsFile is a core.VaultAccessorPort which pulls secrets from a file encrypted by
+// Mozilla SOPS, see https://github.com/mozilla/sops. The data key of the file is
+// decrypted by an age identity or a PGP key.
+type SopsFile struct {
+	log *logging.Logger
+	fs  afero.Fs
+}
+
+// NewSopsFile creates a new sops file vault
+func NewSopsFile(log *logging.Logger, fs afero.Fs) *SopsFile {
+	return &SopsFile{
+		log: log,
+		fs:  fs,
+	}
+}
+
+// SopsFileSpec describes access to a sops file
+type SopsFileSpec struct {
+	// Path points to the sops-encrypted file
+	Path string `yaml:"path" validate:"required"`
+
+	// Format is one of yaml, json, dotenv or ini. It defaults to the extension of Path.
+	Format string `yaml:"format" validate:"omitempty,oneof=yaml json dotenv ini"`
+
+	// IdentityFile points to an unencrypted age identity file
+	IdentityFile string `yaml:"identity"`
+
+	// PGPKeyring points to an armored PGP secret keyring
+	PGPKeyring string `yaml:"pgpKeyring"`
+
+	// PGPPassphrase decrypts the keys of the keyring, if they are encrypted
+	PGPPassphrase string `yaml:"pgpPassphrase"`
+}
+
+// sopsFormatsByExtension maps file extensions to formats
+var sopsFormatsByExtension = map[string]string{
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".json": "json",
+	".env":  "dotenv",
+	".ini":  "ini",
+}
+
+// NewSopsFileSpec creates a new vault spec from the generic interface map
+func NewSopsFileSpec(in map[interface{}]interface{}) (SopsFileSpec, error) {
+	var res SopsFileSpec
+	if err := core.DecodeSpec(in, &res); err != nil {
+		return res, err
+	}
+
+	if res.IdentityFile == "" && res.PGPKeyring == "" {
+		return res, core.SpecError{Field: "identity", Message: "either identity or pgpKeyring is required"}
+	}
+	if res.PGPPassphrase != "" && res.PGPKeyring == "" {
+		return res, core.SpecError{Field: "pgpPassphrase", Message: "requires pgpKeyring"}
+	}
+	if res.Format == "" {
+		res.Format = sopsFormatsByExtension[strings.ToLower(filepath.Ext(res.Path))]
+		if res.Format == "" {
+			return res, core.SpecError{Field: "format", Message: "is required for files without a known extension"}
+		}
+	}
+
+	return res, nil
+}
+
+// ValidateSpec checks if given spec can be decoded into a SopsFileSpec
+func (v *SopsFile) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewSopsFileSpec(in)
+	return err
+}
+
+// SpecSchema returns the schema of SopsFileSpec
+func (v *SopsFile) SpecSchema() *core.Schema {
+	return core.SchemaOf(SopsFileSpec{})
+}
+
+// RetrieveSecret decrypts the sops file according to vault.Spec and returns the value at the
+// key path given by the name of the secret, e.g. db.password. Objects are returned as json.
+func (v *SopsFile) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+
+	spec, err := NewSopsFileSpec(vault.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := afero.ReadFile(v.fs, spec.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := v.decrypt(spec, b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s: %w", spec.Path, err)
+	}
+
+	value, err := lookupKeyPath(doc, secret.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find secret %s in vault %s: %w", secret.Name, vault.Name, err)
+	}
+	content, contentType, err := encodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	v.log.Debug("Retrieved secret", logging.Type(SopsFileType), logging.Vault(vault.Name), logging.Secret(secret.Name))
+
+	return &core.Secret{
+		RawContent:     content,
+		RawContentType: contentType,
+		Name:           secret.Name,
+		Type:           secret.Type,
+		VaultName:      secret.VaultName,
+	}, nil
+}
+
+// sopsBranch is a map of a sops document, keeping the order of its keys, which matters for the MAC
+type sopsBranch []sopsItem
+
+type sopsItem struct {
+	Key   string
+	Value interface{}
+}
+
+// sopsMetadata contains the parts of the sops metadata needed for decryption
+type sopsMetadata struct {
+	Age              []string
+	PGP              []string
+	MAC              string
+	LastModified     string
+	MACOnlyEncrypted bool
+}
+
+// decrypt parses and decrypts a sops file. It returns the document as maps and lists.
+func (v *SopsFile) decrypt(spec SopsFileSpec, b []byte) (interface{}, error) {
+	var tree sopsBranch
+	var meta *sopsMetadata
+	var err error
+	switch spec.Format {
+	case "yaml", "json":
+		tree, meta, err = parseSopsTree(b)
+	case "dotenv":
+		tree, meta, err = parseSopsDotenv(b)
+	case "ini":
+		tree, meta, err = parseSopsINI(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, errors.New("sops metadata not found")
+	}
+
+	key, err := v.dataKey(spec, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &sopsDecryptor{key: key, hash: sha512.New(), macOnlyEncrypted: meta.MACOnlyEncrypted}
+	if meta.MACOnlyEncrypted {
+		d.hash.Write(sopsMACOnlyEncryptedInitialization)
+	}
+	res, err := d.walk(tree, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := d.decryptValue(meta.MAC, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt MAC: %w", err)
+	}
+	if fmt.Sprint(mac) != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		return nil, errors.New("MAC mismatch, the file has been tampered with")
+	}
+
+	return res, nil
+}
+
+// dataKey decrypts the data key of the file with the age identity or PGP keyring of the spec
+func (v *SopsFile) dataKey(spec SopsFileSpec, meta *sopsMetadata) ([]byte, error) {
+	if spec.IdentityFile != "" && len(meta.Age) > 0 {
+		identities, err := parseAgeIdentitiesFile(v.fs, spec.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, enc := range meta.Age {
+			r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+			if err != nil {
+				continue
+			}
+			return ioutil.ReadAll(r)
+		}
+	}
+
+	if spec.PGPKeyring != "" && len(meta.PGP) > 0 {
+		f, err := v.fs.Open(spec.PGPKeyring)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		keyring, err := openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read PGP keyring: %w", err)
+		}
+
+		for _, enc := range meta.PGP {
+			block, err := pgparmor.Decode(strings.NewReader(enc))
+			if err != nil {
+				continue
+			}
+			md, err := openpgp.ReadMessage(block.Body, keyring, sopsPassphrasePrompt(spec.PGPPassphrase), nil)
+			if err != nil {
+				continue
+			}
+			return ioutil.ReadAll(md.UnverifiedBody)
+		}
+	}
+
+	return nil, errors.New("no matching age identity or PGP key for the data key")
+}
+
+// sopsPassphrasePrompt decrypts private keys with the passphrase
+func sopsPassphrasePrompt(passphrase string) openpgp.PromptFunction {
+	tried := false
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if passphrase == "" || tried || symmetric {
+			return nil, errors.New("no passphrase for PGP key")
+		}
+		tried = true
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				if err := k.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	}
+}
+
+// sopsMACOnlyEncryptedInitialization is written to the MAC first if only encrypted values are
+// part of it, so that the MAC differs from the MAC of all values
+var sopsMACOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34,
+	0xf3, 0xd1, 0x47, 0xbe, 0x0b, 0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2,
+	0x7d, 0x69}
+
+var sopsEncryptedPattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsComment is a decrypted comment, which sops stores like a value within lists. Comments are
+// not part of the MAC.
+type sopsComment string
+
+// sopsDecryptor decrypts the values of a sops tree and computes its MAC
+type sopsDecryptor struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+// walk decrypts all values of the tree, path is the list of keys leading to it
+func (d *sopsDecryptor) walk(in interface{}, path []string) (interface{}, error) {
+	switch v := in.(type) {
+	case sopsBranch:
+		res := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			p := append(append([]string{}, path...), item.Key)
+			value, err := d.walk(item.Value, p)
+			if err != nil {
+				return nil, err
+			}
+			res[item.Key] = value
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			// like sops, elements of lists share the path of the list
+			value, err := d.walk(e, path)
+			if err != nil {
+				return nil, err
+			}
+			if _, isComment := value.(sopsComment); !isComment {
+				res = append(res, value)
+			}
+		}
+		return res, nil
+	}
+
+	res := in
+	s, isString := in.(string)
+	encrypted := isString && sopsEncryptedPattern.MatchString(s)
+	if encrypted {
+		var err error
+		res, err = d.decryptValue(s, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt %s: %w", strings.Join(path, "."), err)
+		}
+	}
+	if _, isComment := res.(sopsComment); !isComment && (encrypted || !d.macOnlyEncrypted) {
+		d.hash.Write(sopsBytes(res))
+	}
+	return res, nil
+}
+
+// decryptValue decrypts a value of the form ENC[AES256_GCM,data:...,iv:...,tag:...,type:...]
+func (d *sopsDecryptor) decryptValue(value string, additionalData string) (interface{}, error) {
+	m := sopsEncryptedPattern.FindStringSubmatch(value)
+	if m == nil {
+		return nil, errors.New("invalid encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encrypted value: %w", err)
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+
+	switch m[4] {
+	case "str":
+		return string(plain), nil
+	case "comment":
+		return sopsComment(plain), nil
+	case "bytes":
+		return plain, nil
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	}
+	return nil, fmt.Errorf("unknown type %s", m[4])
+}
+
+// sopsBytes returns the representation of a value sops uses for the MAC
+func sopsBytes(v interface{}) []byte {
+	switch vv := v.(type) {
+	case string:
+		return []byte(vv)
+	case []byte:
+		return vv
+	case int:
+		return []byte(strconv.Itoa(vv))
+	case float64:
+		return []byte(strconv.FormatFloat(vv, 'f', -1, 64))
+	case bool:
+		if vv {
+			return []byte("True")
+		}
+		return []byte("False")
+	}
+	return nil
+}
+
+// parseSopsTree parses a yaml or json sops file, json being a subset of yaml
+func parseSopsTree(b []byte) (sopsBranch, *sopsMetadata, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind != yamlv3.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, nil, errors.New("document is not a map")
+	}
+	v, err := sopsValueOf(doc.Content[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree := make(sopsBranch, 0)
+	var meta *sopsMetadata
+	for _, item := range v.(sopsBranch) {
+		if item.Key != "sops" {
+			tree = append(tree, item)
+			continue
+		}
+		m, ok := item.Value.(sopsBranch)
+		if !ok {
+			return nil, nil, errors.New("invalid sops metadata")
+		}
+		values := make(map[string]interface{})
+		for _, e := range m {
+			values[e.Key] = e.Value
+		}
+		if meta, err = newSopsMetadata(values); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tree, meta, nil
+}
+
+// sopsValueOf converts a yaml node into sops branches, lists and scalars
+func sopsValueOf(n *yamlv3.Node) (interface{}, error) {
+	switch n.Kind {
+	case yamlv3.AliasNode:
+		return sopsValueOf(n.Alias)
+	case yamlv3.MappingNode:
+		res := make(sopsBranch, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := sopsValueOf(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sopsItem{Key: n.Content[i].Value, Value: v})
+		}
+		return res, nil
+	case yamlv3.SequenceNode:
+		res := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := sopsValueOf(c)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	}
+
+	if n.ShortTag() == "!!timestamp" {
+		// keeps lastmodified as it is written, it is part of the MAC
+		return n.Value, nil
+	}
+	var res interface{}
+	if err := n.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+var sopsFlatKeyPattern = regexp.MustCompile(`^(age|pgp)__list_(\d+)__map_(\w+)$`)
+
+// parseSopsDotenv parses a dotenv sops file, metadata is flattened into keys prefixed by sops_
+func parseSopsDotenv(b []byte) (sopsBranch, *sopsMetadata, error) {
+	tree := make(sopsBranch, 0)
+	flat := make(map[string]string)
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("invalid line: %s", line)
+		}
+		key, value := line[:i], strings.ReplaceAll(line[i+1:], `\n`, "\n")
+		if strings.HasPrefix(key, "sops_") {
+			flat[strings.TrimPrefix(key, "sops_")] = value
+			continue
+		}
+		tree = append(tree, sopsItem{Key: key, Value: value})
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	meta, err := newSopsMetadataFromFlat(flat)
+	return tree, meta, err
+}
+
+// parseSopsINI parses an ini sops file, metadata is flattened into the section sops
+func parseSopsINI(b []byte) (sopsBranch, *sopsMetadata, error) {
+	tree := make(sopsBranch, 0)
+	flat := make(map[string]string)
+	var section *sopsItem
+
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			tree = append(tree, sopsItem{Key: line[1 : len(line)-1], Value: sopsBranch{}})
+			section = &tree[len(tree)-1]
+			continue
+		}
+
+		j := strings.IndexAny(line, "=:")
+		if j < 0 || section == nil {
+			return nil, nil, fmt.Errorf("invalid line: %s", line)
+		}
+		key, value := strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		if strings.HasPrefix(value, `"""`) {
+			// multi-line value
+			value = strings.TrimPrefix(value, `"""`)
+			for !strings.HasSuffix(value, `"""`) && i+1 < len(lines) {
+				i++
+				value += "\n" + lines[i]
+			}
+			value = strings.TrimSuffix(value, `"""`)
+		}
+		value = strings.ReplaceAll(value, `\n`, "\n")
+
+		if section.Key == "sops" {
+			flat[key] = value
+			continue
+		}
+		section.Value = append(section.Value.(sopsBranch), sopsItem{Key: key, Value: value})
+	}
+
+	// sections are kept if they contain values
+	res := make(sopsBranch, 0, len(tree))
+	for _, item := range tree {
+		if item.Key != "sops" && len(item.Value.(sopsBranch)) > 0 {
+			res = append(res, item)
+		}
+	}
+
+	meta, err := newSopsMetadataFromFlat(flat)
+	return res, meta, err
+}
+
+// newSopsMetadataFromFlat converts flattened metadata, e.g. age__list_0__map_enc, into sopsMetadata
+func newSopsMetadataFromFlat(flat map[string]string) (*sopsMetadata, error) {
+	if len(flat) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]interface{})
+	keys := make(map[string][]interface{})
+	for k, v := range flat {
+		m := sopsFlatKeyPattern.FindStringSubmatch(k)
+		if m == nil {
+			values[k] = v
+			continue
+		}
+		idx, _ := strconv.Atoi(m[2])
+		for len(keys[m[1]]) <= idx {
+			keys[m[1]] = append(keys[m[1]], sopsBranch{})
+		}
+		keys[m[1]][idx] = append(keys[m[1]][idx].(sopsBranch), sopsItem{Key: m[3], Value: v})
+	}
+	for k, v := range keys {
+		values[k] = v
+	}
+	if v, ok := values["mac_only_encrypted"].(string); ok {
+		values["mac_only_encrypted"] = v == "true"
+	}
+
+	return newSopsMetadata(values)
+}
+
+// newSopsMetadata extracts the metadata from the sops section of a file
+func newSopsMetadata(values map[string]interface{}) (*sopsMetadata, error) {
+	for k := range values {
+		if strings.HasPrefix(k, "key_groups") || strings.HasPrefix(k, "shamir_threshold") {
+			return nil, errors.New("key groups are not supported")
+		}
+	}
+
+	res := &sopsMetadata{}
+	res.MAC, _ = values["mac"].(string)
+	res.LastModified, _ = values["lastmodified"].(string)
+	res.MACOnlyEncrypted, _ = values["mac_only_encrypted"].(bool)
+	if res.MAC == "" || res.LastModified == "" {
+		return nil, errors.New("sops metadata lacks mac or lastmodified")
+	}
+
+	encs := func(name string) []string {
+		res := make([]string, 0)
+		list, _ := values[name].([]interface{})
+		for _, e := range list {
+			entry, _ := e.(sopsBranch)
+			for _, item := range entry {
+				if enc, ok := item.Value.(string); ok && item.Key == "enc" {
+					res = append(res, enc)
+				}
+			}
+		}
+		return res
+	}
+	res.Age = encs("age")
+	res.PGP = encs("pgp")
+
+	return res, nil
+}
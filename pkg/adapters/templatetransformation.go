@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"strings"
 	"text/template"
+	"text/template/parse"
 )
 
 // TemplateTransformationType is the type for a template transformation
@@ -15,55 +16,145 @@ const TemplateTransformationType = "template"
 // TemplateTransformation is an adapter that transforms a secret using a template
 // see TransformationPort interface for more details
 type TemplateTransformation struct {
-	log *log.Logger
+	log *logging.Logger
 }
 
 // TemplateTransformationSpec contains the specification of a template
 type TemplateTransformationSpec struct {
-	// Template text to use for transformation
-	Template *template.Template
+	// Source is the template text to use for transformation
+	Source string `yaml:"template" validate:"required"`
+
+	// Template is the parsed Source
+	Template *template.Template `yaml:"-"`
 
 	// Content Type of rendered output (default: text/plain)
-	ContentType string
+	ContentType string `yaml:"contentType"`
 }
 
 // NewTemplateTransformationSpec creates a new TemplateTransformationSpec from a generic map
 func NewTemplateTransformationSpec(in map[interface{}]interface{}) (TemplateTransformationSpec, error) {
-
-	templateSource, ok := in["template"]
-	if !ok {
-		return TemplateTransformationSpec{}, fmt.Errorf("template element is required")
+	spec := TemplateTransformationSpec{
+		ContentType: "text/plain",
 	}
-	templateSourceStr, ok := templateSource.(string)
-	if !ok {
-		return TemplateTransformationSpec{}, fmt.Errorf("template element must be a string")
-	}
-
-	tmpl, err := template.New(templateSourceStr).Parse(templateSourceStr)
-	if err != nil {
+	if err := core.DecodeSpec(in, &spec); err != nil {
 		return TemplateTransformationSpec{}, err
 	}
 
-	var contentType = "text/plain"
-	cnt, ok := in["contentType"]
-	if ok {
-		cntStr, ok := cnt.(string)
-		if ok {
-			contentType = cntStr
-		}
+	var err error
+	spec.Template, err = template.New(spec.Source).Parse(spec.Source)
+	if err != nil {
+		return TemplateTransformationSpec{}, core.SpecError{Field: "template", Message: err.Error()}
 	}
 
-	return TemplateTransformationSpec{
-		Template:    tmpl,
-		ContentType: contentType,
-	}, nil
+	return spec, nil
 }
 
 // NewTemplateTransformation returns a new instance of TemplateTransformation
-func NewTemplateTransformation(log *log.Logger) *TemplateTransformation {
+func NewTemplateTransformation(log *logging.Logger) *TemplateTransformation {
 	return &TemplateTransformation{log: log}
 }
 
+// SpecSchema returns the schema of TemplateTransformationSpec
+func (t *TemplateTransformation) SpecSchema() *core.Schema {
+	return core.SchemaOf(TemplateTransformationSpec{})
+}
+
+// ValidateSpec checks if given spec can be decoded into a TemplateTransformationSpec
+func (t *TemplateTransformation) ValidateSpec(in map[interface{}]interface{}) error {
+	_, err := NewTemplateTransformationSpec(in)
+	return err
+}
+
+// LintSpec warns about variables referenced by the template which are not part of the input
+func (t *TemplateTransformation) LintSpec(in map[interface{}]interface{}, vars []string) []core.SpecError {
+	spec, err := NewTemplateTransformationSpec(in)
+	if err != nil || spec.Template.Tree == nil {
+		return nil
+	}
+
+	available := make(map[string]struct{}, len(vars))
+	for _, v := range vars {
+		available[v] = struct{}{}
+	}
+
+	res := make([]core.SpecError, 0)
+	for _, name := range templateVars(spec.Template.Tree.Root) {
+		if _, ex := available[name]; !ex {
+			res = append(res, core.SpecError{
+				Field:   "template",
+				Message: fmt.Sprintf("template references variable %s, which is not listed in in", name),
+			})
+		}
+	}
+	return res
+}
+
+// templateVars returns the names of all fields referenced on the top level data of
+// a template, e.g. s1 for {{ .s1 }} or {{ $.s1 }}, in order of appearance.
+func templateVars(root parse.Node) []string {
+	res := make([]string, 0)
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		if _, ex := seen[name]; !ex {
+			seen[name] = struct{}{}
+			res = append(res, name)
+		}
+	}
+
+	var walk func(n parse.Node, topLevel bool)
+	walkPipe := func(p *parse.PipeNode, topLevel bool) {
+		if p == nil {
+			return
+		}
+		for _, cmd := range p.Cmds {
+			for _, arg := range cmd.Args {
+				walk(arg, topLevel)
+			}
+		}
+	}
+	walk = func(n parse.Node, topLevel bool) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c, topLevel)
+			}
+		case *parse.ActionNode:
+			walkPipe(n.Pipe, topLevel)
+		case *parse.PipeNode:
+			walkPipe(n, topLevel)
+		case *parse.FieldNode:
+			if topLevel {
+				add(n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				add(n.Ident[1])
+			}
+		case *parse.IfNode:
+			walkPipe(n.Pipe, topLevel)
+			walk(n.List, topLevel)
+			walk(n.ElseList, topLevel)
+		case *parse.RangeNode:
+			// dot is changed within range and with
+			walkPipe(n.Pipe, topLevel)
+			walk(n.List, false)
+			walk(n.ElseList, topLevel)
+		case *parse.WithNode:
+			walkPipe(n.Pipe, topLevel)
+			walk(n.List, false)
+			walk(n.ElseList, topLevel)
+		case *parse.TemplateNode:
+			walkPipe(n.Pipe, topLevel)
+		}
+	}
+	walk(root, true)
+
+	return res
+}
+
 // ProcessSecret returns a new secret as the result of a template rendering process
 func (t *TemplateTransformation) ProcessSecret(ctx context.Context,
 	defaults *core.Defaults, in *core.Secrets, transformation *core.Transformation) (*core.Secret, error) {
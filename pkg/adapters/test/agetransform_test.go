@@ -6,9 +6,8 @@ import (
 	"filippo.io/age/armor"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"io"
-	"io/ioutil"
-	"log"
 	"reflect"
 	"strings"
 	"testing"
@@ -49,7 +48,7 @@ func TestAgeEncryptTransformation(t *testing.T) {
 		},
 	}
 
-	tt := adapters.NewAgeEncryptTransformation(log.New(ioutil.Discard, "", 0))
+	tt := adapters.NewAgeEncryptTransformation(logging.Discard())
 	s, err := tt.ProcessSecret(context.TODO(), &core.Defaults{}, secrets, transformation)
 	if err != nil {
 		t.Errorf("Error processing template transformation: %s", err)
@@ -1,7 +1,18 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -17,4 +28,163 @@ func TestAWSSecretsManagerSpec(t *testing.T) {
 		t.Error("Expected region to be set")
 	}
 
+	m["region"] = 42
+	if _, err = adapters.NewAWSSecretsManagerSpec(m); err == nil {
+		t.Error("Expected error for non-string region")
+	}
+
+	delete(m, "region")
+	m["regoin"] = "us-east-1"
+	_, err = adapters.NewAWSSecretsManagerSpec(m)
+	if err == nil || err.Error() != `regoin: unknown field, did you mean "region"?` {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+}
+
+func TestAWSSecretsManagerSpecCredentials(t *testing.T) {
+	m := map[interface{}]interface{}{
+		"region":     "eu-central-1",
+		"profile":    "prod",
+		"roleArn":    "arn:aws:iam::123456789012:role/secrets-reader",
+		"externalId": "ext-42",
+		"endpoint":   "https://vpce-1234.secretsmanager.eu-central-1.vpce.amazonaws.com",
+	}
+	spec, err := adapters.NewAWSSecretsManagerSpec(m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if spec.Profile != "prod" || spec.RoleArn != m["roleArn"] || spec.ExternalID != "ext-42" || spec.Endpoint != m["endpoint"] {
+		t.Errorf("Expected credentials to be set, got %#v", spec)
+	}
+
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{"externalId": "ext-42"}, "externalId: requires roleArn"},
+		{map[interface{}]interface{}{"accessKeyId": "AKID"}, "secretAccessKey: is required with accessKeyId"},
+		{map[interface{}]interface{}{"secretAccessKey": "secret"}, "accessKeyId: is required with secretAccessKey"},
+		{map[interface{}]interface{}{"sessionToken": "token"}, "sessionToken: requires accessKeyId and secretAccessKey"},
+		{map[interface{}]interface{}{"accessKeyId": "AKID", "secretAccessKey": "secret", "profile": "prod"},
+			"profile: cannot be combined with accessKeyId"},
+		{map[interface{}]interface{}{"endpoint": "localhost:4566"}, "endpoint: invalid url: must be an absolute url"},
+		{map[interface{}]interface{}{"rolearn": "arn"}, `rolearn: unknown field, did you mean "roleArn"?`},
+	} {
+		if _, err := adapters.NewAWSSecretsManagerSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+}
+
+func TestAWSSecretsManagerIsRetryable(t *testing.T) {
+	v := adapters.NewAWSSecretsManager(logging.Discard())
+
+	if !v.IsRetryable(awserr.New("ThrottlingException", "Rate exceeded", nil)) {
+		t.Error("Expected throttling to be retryable")
+	}
+	if !v.IsRetryable(fmt.Errorf("wrapped: %w", awserr.New("InternalServiceError", "", nil))) {
+		t.Error("Expected wrapped internal service error to be retryable")
+	}
+	if v.IsRetryable(awserr.New("AccessDeniedException", "not allowed", nil)) {
+		t.Error("Expected access denied not to be retryable")
+	}
+	if v.IsRetryable(errors.New("some error")) {
+		t.Error("Expected generic error not to be retryable")
+	}
+}
+
+// setAWSEmulatorEnv sets static credentials for the duration of a test
+func setAWSEmulatorEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEMULATOR")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "emulator")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+}
+
+func TestAWSSecretsManagerEmulator(t *testing.T) {
+	accessKeyID := "AKIDEMULATOR"
+	var requests int32
+	server := newEmulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.Contains(r.Header.Get("Authorization"), "Credential="+accessKeyID+"/") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var req struct{ SecretId string }
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err == nil && req.SecretId == "throttled" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ThrottlingException","message":"Rate exceeded"}`)
+			return
+		}
+		if err != nil || req.SecretId != "test" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprintf(w, `{"ARN":"arn:aws:secretsmanager:eu-central-1:123456789012:secret:%s","Name":"%s",`+
+			`"SecretString":"s3cr3t","VersionId":"v1"}`, req.SecretId, req.SecretId)
+	}))
+	defer server.Close()
+
+	setAWSEmulatorEnv(t)
+
+	v := adapters.NewAWSSecretsManager(logging.Discard())
+	vault := &core.Vault{Name: "emulator", Type: adapters.AWSSecretsManagerType, Spec: core.VaultSpec{
+		"region":   "eu-central-1",
+		"endpoint": server.URL,
+		"caFile":   writeCAFile(t, server),
+	}}
+
+	res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(res.RawContent) != "s3cr3t" {
+		t.Errorf("Expected secret content, got %q", res.RawContent)
+	}
+
+	_, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "nosuchsecret", Type: "secret"})
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != "ResourceNotFoundException" {
+		t.Errorf("Expected ResourceNotFoundException, got %v", err)
+	}
+
+	// throttling is left to the retry policy, the SDK does not retry on its own
+	atomic.StoreInt32(&requests, 0)
+	_, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "throttled", Type: "secret"})
+	if !v.IsRetryable(err) || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected a single throttled request, got %d, %v", atomic.LoadInt32(&requests), err)
+	}
+
+	// the context limits the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	atomic.StoreInt32(&requests, 0)
+	if _, err = v.RetrieveSecret(ctx, &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"}); err == nil ||
+		atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("Expected canceled context to fail without request, got %d, %v", atomic.LoadInt32(&requests), err)
+	}
+
+	// the certificate of the emulator is not trusted by default
+	delete(vault.Spec, "caFile")
+	if _, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"}); err == nil {
+		t.Error("Expected error for untrusted certificate")
+	}
+	vault.Spec["insecureSkipVerify"] = true
+	if _, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// credentials of the spec take precedence over the environment
+	accessKeyID = "AKIDINLINE"
+	vault.Spec["accessKeyId"] = accessKeyID
+	vault.Spec["secretAccessKey"] = "inline"
+	if _, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"}); err != nil {
+		t.Errorf("Unexpected error with credentials of spec: %s", err)
+	}
 }
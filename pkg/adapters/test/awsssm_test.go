@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAWSSSMSpec(t *testing.T) {
+	spec, err := adapters.NewAWSSSMSpec(map[interface{}]interface{}{
+		"region": "eu-central-1",
+		"path":   "/myapp/prod",
+		"label":  "stable",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if spec.Path != "/myapp/prod" || spec.Label != "stable" || spec.Region != "eu-central-1" {
+		t.Errorf("Expected spec to be set, got %#v", spec)
+	}
+
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{"path": "myapp"}, "path: must start with /"},
+		{map[interface{}]interface{}{"version": 3, "label": "stable"}, "label: cannot be combined with version"},
+		{map[interface{}]interface{}{"version": -1}, "version: must be at least 0"},
+		{map[interface{}]interface{}{"recursive": true, "version": 3}, "recursive: cannot be combined with version or label"},
+	} {
+		if _, err := adapters.NewAWSSSMSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+}
+
+func TestAWSSSMEmulator(t *testing.T) {
+	parameters := map[string]string{
+		"/myapp/prod/db-password":     "s3cr3t",
+		"/myapp/prod/db-password:3":   "0ld",
+		"/myapp/prod/config/user":     "app",
+		"/myapp/prod/config/tls/cert": "-----BEGIN CERTIFICATE-----",
+	}
+
+	server := newEmulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		var req struct {
+			Name           string
+			Path           string
+			Recursive      bool
+			WithDecryption bool
+			NextToken      string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.WithDecryption {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ValidationException","message":"invalid request"}`)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			value, ex := parameters[req.Name]
+			if !ex {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"ParameterNotFound"}`)
+				return
+			}
+			b, _ := json.Marshal(map[string]interface{}{
+				"Parameter": map[string]interface{}{"Name": req.Name, "Type": "SecureString", "Value": value, "Version": 4},
+			})
+			w.Write(b)
+		case "AmazonSSM.GetParametersByPath":
+			// serve the parameters below /myapp/prod/config in two pages
+			page := map[string]interface{}{"Parameters": []interface{}{}}
+			switch {
+			case req.Path != "/myapp/prod/config" || !req.Recursive:
+			case req.NextToken == "":
+				page["Parameters"] = []interface{}{map[string]interface{}{
+					"Name": "/myapp/prod/config/tls/cert", "Value": parameters["/myapp/prod/config/tls/cert"]}}
+				page["NextToken"] = "page2"
+			default:
+				page["Parameters"] = []interface{}{map[string]interface{}{
+					"Name": "/myapp/prod/config/user", "Value": parameters["/myapp/prod/config/user"]}}
+			}
+			b, _ := json.Marshal(page)
+			w.Write(b)
+		}
+	}))
+	defer server.Close()
+
+	setAWSEmulatorEnv(t)
+
+	v := adapters.NewAWSSSM(logging.Discard())
+	vault := &core.Vault{Name: "emulator", Type: adapters.AWSSSMType, Spec: core.VaultSpec{
+		"region":   "eu-central-1",
+		"endpoint": server.URL,
+		"caFile":   writeCAFile(t, server),
+		"path":     "/myapp/prod",
+	}}
+	retrieve := func(name string) (*core.Secret, error) {
+		return v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: name, Type: "secret"})
+	}
+
+	res, err := retrieve("db-password")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(res.RawContent) != "s3cr3t" || res.Name != "db-password" {
+		t.Errorf("Expected parameter value, got %v, %q", res, res.RawContent)
+	}
+
+	vault.Spec["version"] = 3
+	if res, err = retrieve("db-password"); err != nil || string(res.RawContent) != "0ld" {
+		t.Errorf("Expected value of version 3, got %v, %v", res, err)
+	}
+	if _, err = retrieve("db-password:3"); err == nil || !strings.Contains(err.Error(), "secret db-password:3 selects a version or label") {
+		t.Errorf("Expected error for selectors of both secret and vault, got %v", err)
+	}
+	delete(vault.Spec, "version")
+
+	if res, err = retrieve("db-password:3"); err != nil || string(res.RawContent) != "0ld" {
+		t.Errorf("Expected value of version 3 selected by the secret, got %v, %v", res, err)
+	}
+
+	// the selector is part of the key, the variable is named without it
+	repository := adapters.NewBuiltinRepository()
+	err = core.NewMainUseCaseImpl(logging.Discard()).RetrieveSecret(context.TODO(),
+		adapters.NewBuiltinFactory(logging.Discard(), afero.NewMemMapFs()), &core.Defaults{}, repository, vault,
+		&core.Secret{Name: "db-password", Key: "db-password:3", Type: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if v, err := repository.Get("db-password"); err != nil || v.(*core.Secret).Name != "db-password" ||
+		string(v.(*core.Secret).RawContent) != "0ld" {
+		t.Errorf("Expected value of version 3 as variable db-password, got %v, %v", v, err)
+	}
+
+	_, err = retrieve("nosuchparameter")
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != "ParameterNotFound" {
+		t.Errorf("Expected ParameterNotFound, got %v", err)
+	}
+
+	vault.Spec["recursive"] = true
+	res, err = retrieve("config")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(res.RawContent) != `{"tls/cert":"-----BEGIN CERTIFICATE-----","user":"app"}` || res.RawContentType != "application/json" {
+		t.Errorf("Expected parameters as json, got %q, %s", res.RawContent, res.RawContentType)
+	}
+
+	_, err = retrieve("nosuchpath")
+	if !errors.As(err, &aerr) || aerr.Code() != "ParameterNotFound" {
+		t.Errorf("Expected ParameterNotFound, got %v", err)
+	}
+}
+
+func TestAWSSSMIsRetryable(t *testing.T) {
+	v := adapters.NewAWSSSM(logging.Discard())
+
+	if !v.IsRetryable(awserr.New("ThrottlingException", "Rate exceeded", nil)) {
+		t.Error("Expected throttling to be retryable")
+	}
+	if !v.IsRetryable(awserr.New("InternalServerError", "", nil)) {
+		t.Error("Expected internal server error to be retryable")
+	}
+	if v.IsRetryable(awserr.New("ParameterNotFound", "", nil)) {
+		t.Error("Expected missing parameter not to be retryable")
+	}
+}
@@ -1,10 +1,58 @@
 package test
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// azureTestCertificate is a base64-encoded PKCS#12 file with a self-signed certificate of a
+// service principal, encrypted with the password "test"
+const azureTestCertificate = `MIIGGQIBAzCCBd8GCSqGSIb3DQEHAaCCBdAEggXMMIIFyDCCAscGCSqGSIb3DQEH
+BqCCArgwggK0AgEAMIICrQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQYwDgQIbMk6
+152wyi8CAggAgIICgMZOD6KJE3weckOmiNx6U0mmjvX+I6cSTHgyIdeS4IKzy1Ec
+hNvrKHfrQAIDI7+/r+OCZCSSv8jUVs0ylsu2aMbuw6IGLiYSMGhxg3ftJkszE0ai
+jbwuqVqM3vSkwvOHSZlQZfqABWWauSEByq30HNpLnHjaOk790bxa3GuMhYVn/9Os
+ZNVZEYLTfXIgYeMFApII14RFRVbu2kM5jTthJqTeKT7WFy4Ax/MwNULEDSkReQMy
+7aVecELxLMY9zB2cAUu+ORG/oM05xY6GtgMKCiEku4caTdZIrxskcRw3rWcMbfxM
+r6owy1wO31MJWefG386YGpN/bCcZHqZm2uYIiSzZ3Jtr5Z0DIS8QeoKQIKA0l7x4
+wjNZ96FOWkYwwpmxWFSqTrt4cdLlqdJFwew/EY0alMV4XfY0VOz7NKN+5FaLi5qc
+DtQRtKejG1fsn3mVtOfn08tTdqKemfVFAUPh4eGxoD102RhHXNBWJpf6Pe5JeWSe
+HzQkXMQXt2QTQh0WTJ7TEtI8lGwfcbIuUjgJzmBxTDxUi+6JlVFSpCh8NgQFSGz4
+Ixpi0HhNpjAist3fcSuhuksMLJrByWZziXlnHYPjaPySUqz/v7pzNXyZaAUDx56I
+xqk147wTJA55rYIAEwA388FxuaiH9ZsMHeuL0S10lD1/tPqfEOs8pY57AecsPxFM
+QsEV6Jcny5AV3WsXGsHLEw0+M6y2PshXbl+L+IYTUyJqV4fxxOyVPI3/eZh+bj8S
+/nDRKFAWXwAcBYRcI7+fuFiYNGKohDfBRKYqoccDFxb76K1skM4i6BPlNJWKSzXL
+QbXQH5TimGQgCRW+OS1zwszjCtTbdYR/5czmBkAwggL5BgkqhkiG9w0BBwGgggLq
+BIIC5jCCAuIwggLeBgsqhkiG9w0BDAoBAqCCAqYwggKiMBwGCiqGSIb3DQEMAQMw
+DgQIsvv0FPPtvx4CAggABIICgBFsg1Fe4e2ONP19AFkjR8e+fEeeG/vDGdUdpk+o
+En2H5pUF/rnZwSIpIpy6cn5LZ0090lPtDNYuZ2pwOu/wTPlCbl/dF+ne5gwzYdXp
+F3sVUxSHkkF6S0yeKZ8UDmfrYNHNCEJ7HVL9Lb6O92ZIpgC335zK540ew5cn0rcc
+AUojhiZk1iVW2TxzN21w84R1+lAON892CcmXBWT3PA0Ok7CDnrpwHMAyNhCHvBOv
+P5C0e8Bl0UdeqI/0RRqfQRsGlXKsonyNpU11aOb+W8w0ZyxVOajamcuBiw03IkRY
+8hPeK8TmWLHD0nNKLVhAcUr9i8NRThLq1ba27QTHicSop0trqUQa7qG+OHHqxtAr
+1CXrzI0MZr4UDDafwOZPxGkoEktdQXKVAI2AgKCm32SWxvmEgVPJ9Y/xjdT9QTUx
+z38waFrIUcSHwd9JIpWCZqOQY6M+u1Yi0oyZPELlKlAdkSRBhI6uIsj0FJKBpCDk
+cKAFHuZlJsGTNEPOy9rjNR6d7mUzbzGW8KsFDGadeY5VxhPiNDKFWpbiFuUPGEoH
+RloI8vd1LPc+/B9MRFQMKrrrL5tKKFL7oZ8ThdOIpPD05aB/kyC23GGa/X7KPK1+
+QxdwJyV1x5BquiPA27uFachN4orBRNwf5/UUNzXhefF6Bk0DYGefsAApoHPeoiX+
+wfArnSvS9xZjIaMi5CcMlqx0QeC3l6+AMQg/T7zkmUJmFUMQVqTKSjn/2MWHQAiS
+f0jBltNO4rlIcS4mL4pVmjEvSJbcb//r2Xja13mTrG9jTvk2Lok4h7Z6pvavWRXK
+wtg9FZqQgG+FDKJn9hQJXI+2aOJV2igEOPDutGqJvcEDYEUxJTAjBgkqhkiG9w0B
+CRUxFgQUcoaqdUww0Nl/KWfwT3Jpo7wkjAIwMTAhMAkGBSsOAwIaBQAEFPz9zvqJ
+D7EZNKGXColrXdKAsIotBAhYMEXADjxFDgICCAA=`
+
 func TestAzureKeyVaultSpec(t *testing.T) {
 	m := make(map[interface{}]interface{})
 
@@ -21,3 +69,138 @@ func TestAzureKeyVaultSpec(t *testing.T) {
 	}
 
 }
+
+func TestAzureKeyVaultSpecCredentials(t *testing.T) {
+	spec, err := adapters.NewAzureKeyVaultSpec(map[interface{}]interface{}{
+		"tenantId":        "tenant",
+		"clientId":        "client",
+		"certificateFile": "./sp.pfx",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if spec.TenantID != "tenant" || spec.ClientID != "client" || spec.CertificateFile != "./sp.pfx" {
+		t.Errorf("Expected credentials to be set, got %#v", spec)
+	}
+
+	if _, err := adapters.NewAzureKeyVaultSpec(map[interface{}]interface{}{
+		"managedIdentityClientId": "identity",
+	}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{"clientId": "client", "certificateFile": "./sp.pfx"},
+			"tenantId: is required with certificateFile or clientSecret"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientSecret": "secret"},
+			"clientId: is required with certificateFile or clientSecret"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientId": "client"},
+			"certificateFile: or clientSecret is required with tenantId and clientId"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientId": "client", "certificateFile": "./sp.pfx",
+			"clientSecret": "secret"}, "clientSecret: cannot be combined with certificateFile"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientId": "client", "clientSecret": "secret",
+			"managedIdentityClientId": "identity"}, "managedIdentityClientId: cannot be combined with clientSecret"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientId": "client", "clientSecret": "secret",
+			"certificatePassword": "test"}, "certificatePassword: requires certificateFile"},
+		{map[interface{}]interface{}{"tenantId": "tenant", "clientId": "client", "certificateFile": "./sp.pfx",
+			"managedIdentityClientId": "identity"}, "managedIdentityClientId: cannot be combined with certificateFile"},
+	} {
+		if _, err := adapters.NewAzureKeyVaultSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+}
+
+func TestAzureKeyVaultIsRetryable(t *testing.T) {
+	v := adapters.NewAzureKeyVault(logging.Discard())
+
+	if !v.IsRetryable(autorest.DetailedError{StatusCode: 429}) {
+		t.Error("Expected 429 to be retryable")
+	}
+	if !v.IsRetryable(autorest.DetailedError{StatusCode: 503}) {
+		t.Error("Expected 503 to be retryable")
+	}
+	if v.IsRetryable(autorest.DetailedError{StatusCode: 403}) {
+		t.Error("Expected 403 not to be retryable")
+	}
+	if v.IsRetryable(errors.New("some error")) {
+		t.Error("Expected generic error not to be retryable")
+	}
+}
+
+func TestAzureKeyVaultEmulator(t *testing.T) {
+	server := newEmulator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/tenant/oauth2/token":
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") != "client" ||
+				(r.PostForm.Get("client_assertion") == "" && r.PostForm.Get("client_secret") != "secret") {
+				http.Error(w, "unexpected token request", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"emulator-token","token_type":"Bearer","expires_in":"3600",`+
+				`"expires_on":"%d","resource":"%s"}`, time.Now().Add(time.Hour).Unix(), r.PostForm.Get("resource"))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/secrets/test"):
+			if r.Header.Get("Authorization") != "Bearer emulator-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"value":"s3cr3t","id":"https://%s/secrets/test/v1","contentType":"text/plain"}`, r.Host)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"not found"}}`)
+		}
+	}))
+	defer server.Close()
+
+	cert, err := base64.StdEncoding.DecodeString(azureTestCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "sp.pfx")
+	if err := ioutil.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := adapters.NewAzureKeyVault(logging.Discard())
+	vault := &core.Vault{Name: "emulator", Type: adapters.AzureKeyVaultType, Spec: core.VaultSpec{
+		"url":                     server.URL,
+		"tenantId":                "tenant",
+		"clientId":                "client",
+		"certificateFile":         certFile,
+		"certificatePassword":     "test",
+		"activeDirectoryEndpoint": server.URL,
+		"caFile":                  writeCAFile(t, server),
+	}}
+
+	res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(res.RawContent) != "s3cr3t" || res.RawContentType != "text/plain" {
+		t.Errorf("Expected secret content, got %q, %q", res.RawContent, res.RawContentType)
+	}
+
+	_, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "nosuchsecret", Type: "secret"})
+	var derr autorest.DetailedError
+	if !errors.As(err, &derr) || derr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %v", err)
+	}
+
+	// a service principal with a client secret, e.g. taken from another vault
+	vault.Spec = core.VaultSpec{
+		"url":                     server.URL,
+		"tenantId":                "tenant",
+		"clientId":                "client",
+		"clientSecret":            "secret",
+		"activeDirectoryEndpoint": server.URL,
+		"caFile":                  writeCAFile(t, server),
+	}
+	res, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"})
+	if err != nil || string(res.RawContent) != "s3cr3t" {
+		t.Errorf("Expected secret content with client secret, got %v, %v", res, err)
+	}
+}
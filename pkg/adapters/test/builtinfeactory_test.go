@@ -3,14 +3,15 @@ package test
 import (
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
-	"io/ioutil"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
 	"testing"
 )
 
 func TestBuiltinFactory(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	bif := adapters.NewBuiltinFactory(log.New(ioutil.Discard, "", 0), fs)
+	bif := adapters.NewBuiltinFactory(logging.Discard(), fs)
 
 	m := make(map[string]struct{})
 	for _, st := range bif.SinkTypes() {
@@ -26,3 +27,138 @@ func TestBuiltinFactory(t *testing.T) {
 		t.Error("Unexcepted: nil")
 	}
 }
+
+func TestBuiltinFactorySpecValidation(t *testing.T) {
+	bif := adapters.NewBuiltinFactory(logging.Discard(), afero.NewMemMapFs())
+
+	s := core.NewConfigSchema(bif)
+	if len(s.Properties["vaults"].Items.AllOf) != len(bif.VaultAccessorTypes()) {
+		t.Errorf("Expected a spec schema for each vault type, got %d", len(s.Properties["vaults"].Items.AllOf))
+	}
+
+	for _, tc := range []struct {
+		spec  string
+		valid bool
+		msg   string
+	}{
+		{"path: ./test.dat\n      mode: 400", true, ""},
+		{"path: ./test.dat\n      mode: \"400\"", true, ""},
+		{"path: ./test.dat\n      mode: 0400", true, ""},
+		{"path: ./test.dat\n      mode: 0640", true, ""},
+		{"path: ./test.dat\n      mode: 0600", true, ""},
+		{"path: ./test.dat\n      mode: true", false, "19:7: invalid spec in sink for test: mode: must be of type integer or string"},
+		{"mode: 400", false, "17:5: invalid spec in sink for test: path: is required"},
+		{"path: ./test.dat\n      mod: 400", false, `19:7: invalid spec in sink for test: mod: unknown property, did you mean "mode"?`},
+		{"path: ./test.dat\n      mode: \"rw\"", false, "19:7: invalid spec in sink for test: mode: must be a non-negative integer"},
+		{"path: ./test.dat\n      mode: 680", false, "19:7: invalid spec in sink for test: mode: must consist of octal digits, e.g. 640"},
+	} {
+		cfg, err := core.NewConfig(strings.NewReader(`
+vaults:
+  - name: kv
+    type: age-file
+    spec:
+      path: ./fixtures/test-agefile
+      identity: ./fixtures/test-identity
+
+secrets:
+  - type: secret
+    vault: kv
+    name: test
+
+sinks:
+  - type: file
+    var: test
+    spec:
+      ` + tc.spec + `
+`))
+		if err != nil {
+			t.Errorf("Unexpected: %s", err)
+			continue
+		}
+
+		err = cfg.Validate(bif)
+		if tc.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got err=%s", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("Expected %q to be invalid", tc.spec)
+		}
+		if err != nil && tc.msg != "" && err.Error() != tc.msg {
+			t.Errorf("Expected error %q, got %q", tc.msg, err)
+		}
+	}
+}
+
+func TestBuiltinFactoryLint(t *testing.T) {
+	bif := adapters.NewBuiltinFactory(logging.Discard(), afero.NewMemMapFs())
+
+	cfg, err := core.NewConfig(strings.NewReader(`vaults:
+  - name: kv
+    type: age-file
+    spec:
+      path: ./fixtures/test-agefile
+      identity: ./fixtures/test-identity
+
+secrets:
+  - type: secret
+    vault: kv
+    name: test
+
+transformations:
+  - type: template
+    in:
+      - test
+    out: ini
+    spec:
+      template: "{{ .test }}{{ .other }}{{ range .test }}{{ .x }}{{ end }}"
+
+sinks:
+  - type: file
+    var: test
+    spec:
+      path: ./test.dat
+  - type: file
+    var: ini
+    spec:
+      path: test.dat
+      mode: 644
+`))
+	if err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	exp := []string{
+		"19:7: warning: template references variable other, which is not listed in in",
+		"28:5: warning: sink for ini writes to test.dat like the sink for test",
+		"30:7: warning: mode 644 (-rw-r--r--) makes the file world-readable",
+	}
+	findings := cfg.Lint(bif)
+	if len(findings) != len(exp) {
+		t.Fatalf("Expected %d findings, got %v", len(exp), findings)
+	}
+	for i, finding := range findings {
+		if finding.String() != exp[i] {
+			t.Errorf("Expected %q, got %q", exp[i], finding)
+		}
+	}
+}
+
+func TestBuiltinFactoryRegister(t *testing.T) {
+	bif := adapters.NewBuiltinFactory(logging.Discard(), afero.NewMemMapFs())
+
+	err := bif.RegisterSink(adapters.FileSinkType, func() core.SinkWriterPort { return nil })
+	if err == nil || err.Error() != "sink type file is already registered" {
+		t.Errorf("Expected collision with builtin type, got %v", err)
+	}
+
+	custom := adapters.NewEnvVault(logging.Discard(), afero.NewMemMapFs())
+	if err := bif.RegisterVault("custom", func() core.VaultAccessorPort { return custom }); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if bif.NewVaultAccessor("custom") != custom || bif.NewVaultAccessor(adapters.AgeVaultType) == nil {
+		t.Error("Expected custom vault next to builtin ones")
+	}
+	if types := core.NewConfigSchema(bif).Properties["vaults"].Items.Properties["type"].Enum; len(types) != len(bif.VaultAccessorTypes()) {
+		t.Errorf("Expected all vault types in schema, got %v", types)
+	}
+}
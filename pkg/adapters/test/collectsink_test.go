@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+)
+
+func TestCollectSink(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/run/secrets/user", []byte("app"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	f := adapters.NewBuiltinFactory(logging.Discard(), fs)
+
+	cfg, err := core.NewConfig(strings.NewReader(`
+vaults:
+  - name: files
+    type: file
+    spec:
+      dir: /run/secrets
+secrets:
+  - name: user
+    type: secret
+    vault: files
+transformations:
+  - type: template
+    in: [user]
+    out: greeting
+    spec:
+      template: "hello {{ .user }}"
+sinks:
+  - type: collect
+    var: greeting
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cfg.Validate(f); err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+
+	c := adapters.NewCollector()
+	repo := adapters.NewBuiltinRepository()
+	uc := core.NewMainUseCaseImpl(logging.Discard(), core.WithRepository(repo))
+	err = uc.Process(adapters.WithCollector(context.TODO(), c), f,
+		&cfg.Defaults, &cfg.Vaults, &cfg.Secrets, &cfg.Transformations, &cfg.Sinks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if s := c.Get("greeting"); s == nil || string(s.RawContent) != "hello app" {
+		t.Errorf("Expected collected secret, got %v", c.Secrets())
+	}
+	if v, err := repo.Get("user"); err != nil || string(v.(*core.Secret).RawContent) != "app" {
+		t.Errorf("Expected variable in repository, got %v, %v", v, err)
+	}
+
+	sw := f.NewSinkWriter(adapters.CollectSinkType)
+	if err := sw.(core.SinkRemoverPort).Remove(adapters.WithCollector(context.TODO(), c), &core.Defaults{}, cfg.Sinks[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(c.Secrets()) != 0 {
+		t.Errorf("Expected removed secret, got %v", c.Secrets())
+	}
+
+	err = sw.Write(context.TODO(), &core.Defaults{}, &core.Secret{Name: "greeting"}, cfg.Sinks[0])
+	if err == nil || err.Error() != "no collector in context, see adapters.WithCollector" {
+		t.Errorf("Expected error without collector, got %v", err)
+	}
+
+	cfg.Sinks[0].Spec = core.SinkSpec{"path": "/tmp/out"}
+	if err := cfg.Validate(f); err == nil || !strings.Contains(err.Error(), "path: unknown property") {
+		t.Errorf("Expected spec error, got %v", err)
+	}
+}
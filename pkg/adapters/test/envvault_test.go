@@ -0,0 +1,62 @@
+package test
+
+import (
+	"context"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"testing"
+)
+
+func TestEnvVault(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/run/secrets/api-key", []byte("k3y"), 0400)
+
+	t.Setenv("CI_DB_PASSWORD", "s3cr3t")
+	t.Setenv("CI_api-token", "t0k3n")
+	t.Setenv("CI_API_KEY_FILE", "/run/secrets/api-key")
+	t.Setenv("CI_CERT_FILE", "/run/secrets/nosuchfile")
+	t.Setenv("CI_BOTH", "a")
+	t.Setenv("CI_BOTH_FILE", "/run/secrets/api-key")
+
+	v := adapters.NewEnvVault(logging.Discard(), fs)
+	retrieve := func(spec core.VaultSpec, name string) (*core.Secret, error) {
+		vault := &core.Vault{Name: "ci", Type: adapters.EnvVaultType, Spec: spec}
+		return v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: name, Type: "secret"})
+	}
+
+	for _, tc := range []struct {
+		spec    core.VaultSpec
+		name    string
+		content string
+	}{
+		{core.VaultSpec{"prefix": "CI_"}, "DB_PASSWORD", "s3cr3t"},
+		{core.VaultSpec{"prefix": "CI_"}, "api-token", "t0k3n"},
+		{core.VaultSpec{"prefix": "CI_", "normalize": true}, "db-password", "s3cr3t"},
+		{core.VaultSpec{"prefix": "CI_", "normalize": true}, "api.key", "k3y"},
+		{core.VaultSpec{}, "CI_API_KEY", "k3y"},
+	} {
+		res, err := retrieve(tc.spec, tc.name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tc.name, err)
+			continue
+		}
+		if string(res.RawContent) != tc.content || res.Name != tc.name {
+			t.Errorf("Expected %q for %s, got %q", tc.content, tc.name, res.RawContent)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		exp  string
+	}{
+		{"NOSUCHVAR", "environment variable CI_NOSUCHVAR is not set"},
+		{"BOTH", "both CI_BOTH and CI_BOTH_FILE are set"},
+		{"CERT", "unable to read CI_CERT_FILE: open /run/secrets/nosuchfile: file does not exist"},
+	} {
+		if _, err := retrieve(core.VaultSpec{"prefix": "CI_"}, tc.name); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %s, got %v", tc.exp, tc.name, err)
+		}
+	}
+}
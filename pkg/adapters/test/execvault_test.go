@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const execVaultFixture = "./testdata/exec-vault.sh"
+
+func TestExecVault(t *testing.T) {
+	v := adapters.NewExecVault(logging.Discard())
+
+	for _, tc := range []struct {
+		spec        core.VaultSpec
+		name        string
+		content     string
+		contentType string
+	}{
+		{core.VaultSpec{"command": []interface{}{execVaultFixture}}, "db-password", "s3cr3t\n", ""},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture}, "trimNewline": true}, "db-password", "s3cr3t", ""},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "db-password", "s3cr3t", ""},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "binary", "\x00\x01\x02\xff", ""},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "config", `{"user":"app"}`, "application/json"},
+	} {
+		vault := &core.Vault{Name: "exec", Type: adapters.ExecVaultType, Spec: tc.spec}
+		res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: tc.name, Type: "secret"})
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tc.name, err)
+			continue
+		}
+		if string(res.RawContent) != tc.content || res.RawContentType != tc.contentType {
+			t.Errorf("Expected %q (%s) for %s, got %q (%s)", tc.content, tc.contentType, tc.name, res.RawContent, res.RawContentType)
+		}
+	}
+}
+
+func TestExecVaultErrors(t *testing.T) {
+	v := adapters.NewExecVault(logging.Discard())
+
+	for _, tc := range []struct {
+		spec      core.VaultSpec
+		name      string
+		exp       string
+		retryable bool
+		notFound  bool
+	}{
+		{core.VaultSpec{"command": []interface{}{execVaultFixture}}, "nosuchsecret",
+			"./testdata/exec-vault.sh failed: exit status 2: secret nosuchsecret not found", false, false},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture}, "timeout": "100ms"}, "slow",
+			"./testdata/exec-vault.sh timed out after 100ms", true, false},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture}, "timeout": "100ms"}, "slow-child",
+			"./testdata/exec-vault.sh timed out after 100ms", true, false},
+		{core.VaultSpec{"command": []interface{}{"./testdata/nosuchcommand"}}, "db-password",
+			"./testdata/nosuchcommand failed: fork/exec ./testdata/nosuchcommand: no such file or directory", false, false},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "nosuchsecret",
+			"./testdata/exec-vault.sh failed: secret nosuchsecret not found", false, true},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "sealed",
+			"./testdata/exec-vault.sh failed: vault is sealed", true, false},
+		{core.VaultSpec{"command": []interface{}{execVaultFixture, "--json"}, "protocol": "json"}, "garbage",
+			"invalid response of ./testdata/exec-vault.sh: invalid character", false, false},
+	} {
+		vault := &core.Vault{Name: "exec", Type: adapters.ExecVaultType, Spec: tc.spec}
+		start := time.Now()
+		_, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: tc.name, Type: "secret"})
+		if err == nil || !strings.HasPrefix(err.Error(), tc.exp) {
+			t.Errorf("Expected %q for %s, got %v", tc.exp, tc.name, err)
+			continue
+		}
+		// processes started by the command must not delay the timeout
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("Expected %s to fail within 2s, took %s", tc.name, d)
+		}
+		if v.IsRetryable(err) != tc.retryable {
+			t.Errorf("Expected retryable to be %v for %s", tc.retryable, tc.name)
+		}
+		if errors.Is(err, os.ErrNotExist) != tc.notFound {
+			t.Errorf("Expected not found to be %v for %s", tc.notFound, tc.name)
+		}
+	}
+}
+
+func TestExecVaultSpec(t *testing.T) {
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{}, "command: is required"},
+		{map[interface{}]interface{}{"command": []interface{}{}}, "command: must be at least 1"},
+		{map[interface{}]interface{}{"command": []interface{}{""}}, "command: executable must not be empty"},
+		{map[interface{}]interface{}{"command": []interface{}{"pass"}, "protocol": "grpc"}, "protocol: must be one of args json"},
+		{map[interface{}]interface{}{"command": []interface{}{"pass"}, "protocol": "json", "trimNewline": true}, "trimNewline: requires protocol args"},
+	} {
+		if _, err := adapters.NewExecVaultSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+
+	spec, err := adapters.NewExecVaultSpec(map[interface{}]interface{}{"command": []interface{}{"pass", "show"}})
+	if err != nil || spec.Protocol != "args" || spec.Timeout != core.Duration(adapters.DefaultExecTimeout) {
+		t.Errorf("Expected defaults, got %#v, %v", spec, err)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"github.com/spf13/afero"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -98,7 +100,7 @@ func TestFileSink(t *testing.T) {
 
 	fs := afero.NewMemMapFs()
 
-	sink := adapters.NewFileSink(log.Default(), fs)
+	sink := adapters.NewFileSink(logging.Discard(), fs)
 	err := sink.Write(context.TODO(), &core.Defaults{}, (*secrets)[0], (*sinks)[0])
 	if err != nil {
 		t.Errorf("Unexpected: %s", err)
@@ -113,8 +115,8 @@ func TestFileSink(t *testing.T) {
 		t.Errorf("Invalid size")
 	}
 
-	if fi.Mode().Perm() != 440 {
-		t.Errorf("Expected mode 440, got: %#v", fi.Mode().Perm())
+	if fi.Mode().Perm() != 0o440 {
+		t.Errorf("Expected mode 440, got: %s", fi.Mode().Perm())
 	}
 
 	raw, err := afero.ReadFile(fs, p)
@@ -127,3 +129,85 @@ func TestFileSink(t *testing.T) {
 		t.Errorf("Invalid content")
 	}
 }
+
+func TestFileSinkModeFromConfig(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		exp  os.FileMode
+	}{
+		{"400", 0o400},
+		{"0400", 0o400},
+		{"0640", 0o640},
+		{"0600", 0o600},
+		{`"0600"`, 0o600},
+		{"{path: test.dat, mode: 0440}", 0o440},
+	} {
+		spec := "path: test.dat\n      mode: " + tc.mode
+		if strings.HasPrefix(tc.mode, "{") {
+			spec = tc.mode
+		}
+		cfg, err := core.NewConfig(strings.NewReader(`
+sinks:
+  - type: file
+    var: test
+    spec:
+      ` + spec + `
+`))
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+
+		fs := afero.NewMemMapFs()
+		sink := adapters.NewFileSink(logging.Discard(), fs)
+		if err := sink.Write(context.TODO(), &core.Defaults{}, &core.Secret{Name: "test", RawContent: []byte("s3cr3t")}, cfg.Sinks[0]); err != nil {
+			t.Errorf("Unexpected error for mode %s: %s", tc.mode, err)
+			continue
+		}
+		fi, err := fs.Stat("test.dat")
+		if err != nil {
+			t.Fatalf("Unexpected: %s", err)
+		}
+		if fi.Mode().Perm() != tc.exp {
+			t.Errorf("Expected mode %s for %s, got %s", tc.exp, tc.mode, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestFileSinkRemove(t *testing.T) {
+	p := "test.dat"
+	sink := &core.Sink{
+		Type: "file",
+		Var:  "test",
+		Spec: core.SinkSpec{
+			"path": p,
+		},
+	}
+
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, p, []byte("stale"), 0400); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	fileSink := adapters.NewFileSink(logging.Discard(), fs)
+	if err := fileSink.Remove(context.TODO(), &core.Defaults{}, sink); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+	if ex, _ := afero.Exists(fs, p); ex {
+		t.Errorf("Expected %s to be removed", p)
+	}
+
+	// removing a non-existing file is fine
+	if err := fileSink.Remove(context.TODO(), &core.Defaults{}, sink); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+}
+
+func TestFileSinkLintMode(t *testing.T) {
+	sink := adapters.NewFileSink(logging.Discard(), afero.NewMemMapFs())
+	for mode, worldReadable := range map[int]bool{400: false, 640: false, 644: true, 666: true, 777: true, 2750: false} {
+		findings := sink.LintSpec(map[interface{}]interface{}{"path": "test.dat", "mode": mode}, nil)
+		if (len(findings) > 0) != worldReadable {
+			t.Errorf("Expected world-readable to be %v for mode %d, got %v", worldReadable, mode, findings)
+		}
+	}
+}
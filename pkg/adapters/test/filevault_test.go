@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setupFileVaultFiles(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	for name, content := range map[string]string{
+		"/run/secrets/db-password":      "s3cr3t\n",
+		"/run/secrets/tls/cert.pem":     "-----BEGIN CERTIFICATE-----\n",
+		"/etc/app/secrets.json":         `{"db": {"password": "s3cr3t", "port": 5432}}`,
+		"/etc/app/secrets.yaml":         "db:\n  password: s3cr3t\n  port: 5432\n",
+		"/etc/app/.env":                 "# comment\nexport DB_PASSWORD=s3cr3t # trailing\nAPI_KEY=\"line1\\nline2\"\nRAW='a\\nb'\n",
+		"/etc/app/plain.txt":            "s3cr3t",
+		"/srv/a/keys/signing.key":       "a",
+		"/srv/b/keys/encryption.key":    "b",
+		"/srv/b/keys/signing.key":       "b",
+		"/srv/b/keys/nested.key/readme": "c",
+	} {
+		if err := afero.WriteFile(fs, name, []byte(content), 0400); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestFileVault(t *testing.T) {
+	v := adapters.NewFileVault(logging.Discard(), setupFileVaultFiles(t))
+
+	for _, tc := range []struct {
+		spec        core.VaultSpec
+		name        string
+		content     string
+		contentType string
+	}{
+		{core.VaultSpec{"dir": "/run/secrets"}, "db-password", "s3cr3t\n", ""},
+		{core.VaultSpec{"dir": "/run/secrets"}, "tls/cert.pem", "-----BEGIN CERTIFICATE-----\n", ""},
+		{core.VaultSpec{"path": "/etc/app/secrets.json"}, "db.password", "s3cr3t", ""},
+		{core.VaultSpec{"path": "/etc/app/secrets.json"}, "db", `{"password":"s3cr3t","port":5432}`, "application/json"},
+		{core.VaultSpec{"path": "/etc/app/secrets.yaml"}, "/db/port", "5432", ""},
+		{core.VaultSpec{"path": "/etc/app/.env"}, "DB_PASSWORD", "s3cr3t", ""},
+		{core.VaultSpec{"path": "/etc/app/.env"}, "API_KEY", "line1\nline2", ""},
+		{core.VaultSpec{"path": "/etc/app/.env"}, "RAW", `a\nb`, ""},
+		{core.VaultSpec{"glob": "/srv/*/keys/*.key"}, "encryption.key", "b", ""},
+	} {
+		vault := &core.Vault{Name: "files", Type: adapters.FileVaultType, Spec: tc.spec}
+		res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: tc.name, Type: "secret"})
+		if err != nil {
+			t.Errorf("Unexpected error for %s in %v: %s", tc.name, tc.spec, err)
+			continue
+		}
+		if string(res.RawContent) != tc.content || res.RawContentType != tc.contentType {
+			t.Errorf("Expected %q (%s) for %s, got %q (%s)", tc.content, tc.contentType, tc.name, res.RawContent, res.RawContentType)
+		}
+	}
+}
+
+func TestFileVaultErrors(t *testing.T) {
+	v := adapters.NewFileVault(logging.Discard(), setupFileVaultFiles(t))
+
+	for _, tc := range []struct {
+		spec     core.VaultSpec
+		name     string
+		exp      string
+		notExist bool
+	}{
+		{core.VaultSpec{"dir": "/run/secrets"}, "nosuchsecret", "open /run/secrets/nosuchsecret: file does not exist", true},
+		{core.VaultSpec{"dir": "/run/secrets"}, "../../etc/app/plain.txt", "invalid file name ../../etc/app/plain.txt", false},
+		{core.VaultSpec{"path": "/etc/app/secrets.yaml"}, "db.user", "key db.user not found", true},
+		{core.VaultSpec{"path": "/etc/app/plain.txt"}, "db", "/etc/app/plain.txt is not a yaml object or list", false},
+		{core.VaultSpec{"glob": "/srv/*/keys/*.key"}, "signing.key", "file name signing.key is ambiguous, it matches /srv/a/keys/signing.key, /srv/b/keys/signing.key", false},
+		{core.VaultSpec{"glob": "/srv/*/keys/*.key"}, "nested.key", "no file nested.key matches /srv/*/keys/*.key", true},
+	} {
+		vault := &core.Vault{Name: "files", Type: adapters.FileVaultType, Spec: tc.spec}
+		res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: tc.name, Type: "secret"})
+		if err == nil {
+			t.Errorf("Expected error for %s in %v, got %q", tc.name, tc.spec, res.RawContent)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.exp) {
+			t.Errorf("Expected %q for %s, got %s", tc.exp, tc.name, err)
+		}
+		if errors.Is(err, os.ErrNotExist) != tc.notExist {
+			t.Errorf("Expected os.ErrNotExist to be %v for %s, got %s", tc.notExist, tc.name, err)
+		}
+	}
+}
+
+func TestFileVaultSpec(t *testing.T) {
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{}, "dir: exactly one of dir, path or glob is required"},
+		{map[interface{}]interface{}{"dir": "/run/secrets", "path": "secrets.json"}, "dir: exactly one of dir, path or glob is required"},
+		{map[interface{}]interface{}{"dir": "/run/secrets", "format": "json"}, "format: requires path"},
+		{map[interface{}]interface{}{"path": "secrets", "format": "toml"}, "format: must be one of json yaml dotenv"},
+		{map[interface{}]interface{}{"glob": "/run/[secrets"}, "glob: syntax error in pattern"},
+	} {
+		if _, err := adapters.NewFileVaultSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+
+	for path, format := range map[string]string{"a.json": "json", "a.yml": "yaml", "a": "yaml", ".env": "dotenv", "prod.env": "dotenv"} {
+		spec, err := adapters.NewFileVaultSpec(map[interface{}]interface{}{"path": path})
+		if err != nil || spec.Format != format {
+			t.Errorf("Expected format %s for %s, got %v, %v", format, path, spec.Format, err)
+		}
+	}
+}
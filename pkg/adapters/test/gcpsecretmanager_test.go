@@ -1,10 +1,40 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"os"
 	"testing"
 )
 
+// secretManagerEmulator serves the secret "test" of project "emulator"
+type secretManagerEmulator struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+}
+
+func (e *secretManagerEmulator) AccessSecretVersion(ctx context.Context,
+	req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+
+	if req.Name != "projects/emulator/secrets/test/versions/latest" {
+		return nil, status.Errorf(codes.NotFound, "secret %s not found", req.Name)
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    "projects/emulator/secrets/test/versions/1",
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte("s3cr3t")},
+	}, nil
+}
+
 func TestGCPSecretManagerSpec(t *testing.T) {
 	m := make(map[interface{}]interface{})
 
@@ -17,4 +47,83 @@ func TestGCPSecretManagerSpec(t *testing.T) {
 		t.Error("Expected projectID to be set")
 	}
 
+	m["credentialsFile"] = "./sa.json"
+	m["impersonateServiceAccount"] = "reader@project.iam.gserviceaccount.com"
+	m["delegates"] = []interface{}{"delegate@project.iam.gserviceaccount.com"}
+	spec, err = adapters.NewGCPSecretManagerSpec(m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if spec.CredentialsFile != "./sa.json" || spec.ImpersonateServiceAccount != m["impersonateServiceAccount"] ||
+		len(spec.Delegates) != 1 {
+		t.Errorf("Expected credentials to be set, got %#v", spec)
+	}
+
+	delete(m, "impersonateServiceAccount")
+	if _, err = adapters.NewGCPSecretManagerSpec(m); err == nil || err.Error() != "delegates: requires impersonateServiceAccount" {
+		t.Errorf("Expected error for delegates without impersonation, got %v", err)
+	}
+
+	delete(m, "delegates")
+	m["credentials"] = `{"type": "service_account"}`
+	if _, err = adapters.NewGCPSecretManagerSpec(m); err == nil || err.Error() != "credentials: cannot be combined with credentialsFile" {
+		t.Errorf("Expected error for credentials and credentials file, got %v", err)
+	}
+	delete(m, "credentialsFile")
+
+	m["withoutAuthentication"] = true
+	if _, err = adapters.NewGCPSecretManagerSpec(m); err == nil || err.Error() != "withoutAuthentication: cannot be combined with credentials" {
+		t.Errorf("Expected error for credentials without authentication, got %v", err)
+	}
+}
+
+func TestGCPSecretManagerIsRetryable(t *testing.T) {
+	v := adapters.NewGCPSecretManager(logging.Discard())
+
+	if !v.IsRetryable(fmt.Errorf("wrapped: %w", status.Error(codes.Unavailable, "unavailable"))) {
+		t.Error("Expected UNAVAILABLE to be retryable")
+	}
+	if v.IsRetryable(status.Error(codes.PermissionDenied, "denied")) {
+		t.Error("Expected PERMISSION_DENIED not to be retryable")
+	}
+	if v.IsRetryable(errors.New("some error")) {
+		t.Error("Expected generic error not to be retryable")
+	}
+}
+
+func TestGCPSecretManagerEmulator(t *testing.T) {
+	// borrow the self-signed certificate of a test server
+	certServer := newEmulator(http.NotFoundHandler())
+	defer certServer.Close()
+	caFile := writeCAFile(t, certServer)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&certServer.TLS.Certificates[0])))
+	secretmanagerpb.RegisterSecretManagerServiceServer(server, &secretManagerEmulator{})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	v := adapters.NewGCPSecretManager(logging.Discard())
+	vault := &core.Vault{Name: "emulator", Type: adapters.GCPSecretManagerType, Spec: core.VaultSpec{
+		"projectID":             "emulator",
+		"endpoint":              lis.Addr().String(),
+		"withoutAuthentication": true,
+		"caFile":                caFile,
+	}}
+
+	res, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(res.RawContent) != "s3cr3t" {
+		t.Errorf("Expected secret content, got %q", res.RawContent)
+	}
+
+	_, err = v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "nosuchsecret", Type: "secret"})
+	if status.Code(errors.Unwrap(errors.Unwrap(err))) != codes.NotFound || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected NOT_FOUND, got %v", err)
+	}
 }
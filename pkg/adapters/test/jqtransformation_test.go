@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"io/ioutil"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"reflect"
 	"testing"
 )
@@ -58,7 +57,7 @@ func TestJQTransformation(t *testing.T) {
 		},
 	}
 
-	tt := adapters.NewJQTransformation(log.New(ioutil.Discard, "***", 0))
+	tt := adapters.NewJQTransformation(logging.Discard())
 	s, err := tt.ProcessSecret(context.TODO(), &core.Defaults{}, secrets, transformation)
 	if err != nil {
 		t.Errorf("Error processing jq transformation: %s", err)
@@ -79,7 +78,7 @@ func TestJQTransformation(t *testing.T) {
 		core.WithSpec("raw", false),
 	)
 
-	tt = adapters.NewJQTransformation(log.New(ioutil.Discard, "***", 0))
+	tt = adapters.NewJQTransformation(logging.Discard())
 	s, err = tt.ProcessSecret(context.TODO(), &core.Defaults{}, secrets, transformation)
 	if err != nil {
 		t.Errorf("Error processing jq transformation: %s", err)
@@ -119,7 +118,7 @@ func TestJQTransformation(t *testing.T) {
 		},
 	}
 
-	tt = adapters.NewJQTransformation(log.New(ioutil.Discard, "***", 0))
+	tt = adapters.NewJQTransformation(logging.Discard())
 	s, err = tt.ProcessSecret(context.TODO(), &core.Defaults{}, secrets, transformation)
 	if err != nil {
 		t.Errorf("Error processing jq transformation: %s", err)
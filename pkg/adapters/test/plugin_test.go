@@ -0,0 +1,241 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginHelperProcess is not a test, but the plugin started by the other tests, which
+// serves the vault type memory, the transformation type upper and the sink type textfile.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_PLUGIN_HELPER") != "1" {
+		return
+	}
+
+	type secret struct {
+		Name        string `json:"name"`
+		Value       []byte `json:"value"`
+		ContentType string `json:"contentType,omitempty"`
+	}
+	var req struct {
+		ID     int64  `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			Kind           string                 `json:"kind"`
+			Type           string                 `json:"type"`
+			Spec           map[string]interface{} `json:"spec"`
+			Vault          struct{ Spec map[string]interface{} }
+			Secret         secret   `json:"secret"`
+			Secrets        []secret `json:"secrets"`
+			Transformation struct{ Out string }
+			Sink           struct{ Spec map[string]interface{} }
+		} `json:"params"`
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for in.Scan() {
+		req.Params.Spec = nil
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			os.Exit(1)
+		}
+		var result interface{} = map[string]interface{}{}
+		var rpcErr map[string]interface{}
+
+		p := req.Params
+		switch req.Method {
+		case "initialize":
+			result = map[string]interface{}{
+				"protocolVersion": adapters.PluginProtocolVersion,
+				"vaults": []interface{}{map[string]interface{}{"type": "memory", "schema": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"prefix": map[string]interface{}{"type": "string"}},
+					"required":   []string{"prefix"},
+				}}},
+				"transformations": []interface{}{map[string]interface{}{"type": "upper"}},
+				"sinks":           []interface{}{map[string]interface{}{"type": "textfile"}},
+			}
+		case "validateSpec":
+			if p.Kind != "vault" {
+				rpcErr = map[string]interface{}{"code": -32601, "message": "method not found"}
+			} else if strings.Contains(fmt.Sprint(p.Spec["prefix"]), "/") {
+				rpcErr = map[string]interface{}{"code": 1, "message": "must not contain /", "data": map[string]interface{}{"field": "prefix"}}
+			}
+		case "retrieveSecret":
+			if p.Secret.Name == "sealed" {
+				rpcErr = map[string]interface{}{"code": 2, "message": "vault is sealed", "data": map[string]interface{}{"retryable": true}}
+				break
+			}
+			if p.Secret.Name == "hang" {
+				continue
+			}
+			if p.Secret.Name == "missing" {
+				rpcErr = map[string]interface{}{"code": 4, "message": "no such secret", "data": map[string]interface{}{"notFound": true}}
+				break
+			}
+			result = secret{Name: p.Secret.Name, Value: []byte(fmt.Sprint(p.Vault.Spec["prefix"]) + p.Secret.Name)}
+		case "processSecret":
+			values := make([]string, 0)
+			for _, s := range p.Secrets {
+				values = append(values, strings.ToUpper(string(s.Value)))
+			}
+			result = secret{Name: p.Transformation.Out, Value: []byte(strings.Join(values, ",")), ContentType: "text/plain"}
+		case "writeSink":
+			if err := ioutil.WriteFile(fmt.Sprint(p.Sink.Spec["path"]), p.Secret.Value, 0600); err != nil {
+				rpcErr = map[string]interface{}{"code": 3, "message": err.Error()}
+			}
+		default:
+			rpcErr = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if rpcErr != nil {
+			resp["error"] = rpcErr
+		} else {
+			resp["result"] = result
+		}
+		out.Encode(resp)
+	}
+	os.Exit(0)
+}
+
+func startTestPlugin(t *testing.T) *adapters.PluginFactory {
+	t.Setenv("GO_WANT_PLUGIN_HELPER", "1")
+	p, err := adapters.StartPlugin(logging.Discard(), os.Args[0], "-test.run=TestPluginHelperProcess")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := p.Close(); err != nil {
+			t.Errorf("Unexpected error stopping plugin: %s", err)
+		}
+	})
+	return p
+}
+
+func TestPlugin(t *testing.T) {
+	p := startTestPlugin(t)
+	f := adapters.NewBuiltinFactory(logging.Discard(), afero.NewMemMapFs())
+	if err := f.RegisterFactory(p); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if types := f.VaultAccessorTypes(); types[len(types)-1] != "memory" {
+		t.Errorf("Expected plugin vault type, got %v", types)
+	}
+	if f.NewTransformation("upper") == nil || f.NewSinkWriter("textfile") == nil || f.NewVaultAccessor("nosuchtype") != nil {
+		t.Error("Expected ports of plugin types only")
+	}
+
+	target := filepath.Join(t.TempDir(), "out.txt")
+	cfg, err := core.NewConfig(strings.NewReader(fmt.Sprintf(`
+vaults:
+  - name: mem
+    type: memory
+    spec:
+      prefix: "p-"
+secrets:
+  - name: a
+    type: secret
+    vault: mem
+  - name: b
+    type: secret
+    vault: mem
+transformations:
+  - type: upper
+    in: [a, b]
+    out: ab
+sinks:
+  - type: textfile
+    var: ab
+    spec:
+      path: %s
+`, target)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cfg.Validate(f); err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+
+	uc := core.NewMainUseCaseImpl(logging.Discard())
+	if err := uc.Process(context.TODO(), f, &cfg.Defaults, &cfg.Vaults, &cfg.Secrets, &cfg.Transformations, &cfg.Sinks); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if b, err := ioutil.ReadFile(target); err != nil || string(b) != "P-A,P-B" {
+		t.Errorf("Expected output of plugin transformation, got %q, %v", b, err)
+	}
+
+	cfg.Vaults[0].Spec = core.VaultSpec{"prefix": "a/b"}
+	if err := cfg.Validate(f); err == nil || !strings.Contains(err.Error(), "prefix: must not contain /") {
+		t.Errorf("Expected validation error of plugin, got %v", err)
+	}
+
+	s := core.NewConfigSchema(f)
+	if len(s.Properties["vaults"].Items.AllOf) != len(f.VaultAccessorTypes()) {
+		t.Errorf("Expected a spec schema for each vault type, got %d", len(s.Properties["vaults"].Items.AllOf))
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	p := startTestPlugin(t)
+	va := p.NewVaultAccessor("memory")
+
+	_, err := va.RetrieveSecret(context.TODO(), &core.Defaults{},
+		&core.Vault{Name: "mem", Type: "memory", Spec: core.VaultSpec{"prefix": ""}}, &core.Secret{Name: "sealed", Type: "secret"})
+	if err == nil || err.Error() != "vault is sealed" {
+		t.Errorf("Expected error of plugin, got %v", err)
+	}
+	if !va.(core.RetryClassifier).IsRetryable(err) || errors.Is(err, os.ErrNotExist) {
+		t.Error("Expected error to be retryable")
+	}
+
+	_, err = va.RetrieveSecret(context.TODO(), &core.Defaults{},
+		&core.Vault{Name: "mem", Type: "memory", Spec: core.VaultSpec{"prefix": ""}}, &core.Secret{Name: "missing", Type: "secret"})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected error of missing secret, got %v", err)
+	}
+
+	// a plugin not responding in time is killed, and the timed out attempt is not retried
+	retrying := core.NewRetryingVaultAccessor(logging.Discard(), va, core.RetryPolicy{
+		MaxAttempts: 3, Timeout: core.Duration(100 * time.Millisecond)})
+	start := time.Now()
+	_, err = retrying.RetrieveSecret(context.TODO(), &core.Defaults{},
+		&core.Vault{Name: "mem", Type: "memory", Spec: core.VaultSpec{"prefix": ""}}, &core.Secret{Name: "hang", Type: "secret"})
+	var errFatal core.ErrFatal
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &errFatal) || strings.Contains(err.Error(), "giving up") {
+		t.Errorf("Expected fatal timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected no retries of killed plugin, took %s", d)
+	}
+	_, err = va.RetrieveSecret(context.TODO(), &core.Defaults{},
+		&core.Vault{Name: "mem", Type: "memory", Spec: core.VaultSpec{"prefix": ""}}, &core.Secret{Name: "a", Type: "secret"})
+	if err == nil || !strings.Contains(err.Error(), "was killed after not responding to retrieveSecret") {
+		t.Errorf("Expected error of killed plugin, got %v", err)
+	}
+
+	_, err = adapters.StartPlugin(logging.Discard(), "./testdata/nosuchplugin")
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to start plugin ./testdata/nosuchplugin") {
+		t.Errorf("Expected start error, got %v", err)
+	}
+
+	// a plugin not speaking the protocol
+	_, err = adapters.StartPlugin(logging.Discard(), execVaultFixture, "db-password")
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to initialize plugin ./testdata/exec-vault.sh: ") {
+		t.Errorf("Expected initialize error, got %v", err)
+	}
+}
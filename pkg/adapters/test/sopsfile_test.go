@@ -0,0 +1,331 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"hash"
+	"strings"
+	"testing"
+)
+
+const sopsLastModified = "2021-11-30T10:00:00Z"
+
+// sopsFixture encrypts values the way sops does, to create test files
+type sopsFixture struct {
+	t    *testing.T
+	key  []byte
+	hash hash.Hash
+}
+
+func newSopsFixture(t *testing.T) *sopsFixture {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return &sopsFixture{t: t, key: key, hash: sha512.New()}
+}
+
+// enc encrypts a value at path and adds it to the MAC
+func (f *sopsFixture) enc(value, valueType string, path ...string) string {
+	f.hash.Write([]byte(value))
+	return f.encrypt(value, valueType, strings.Join(path, ":")+":")
+}
+
+func (f *sopsFixture) encrypt(value, valueType, additionalData string) string {
+	block, err := aes.NewCipher(f.key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		f.t.Fatal(err)
+	}
+	out := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), valueType)
+}
+
+// mac returns the encrypted MAC of all values encrypted so far
+func (f *sopsFixture) mac() string {
+	return f.encrypt(fmt.Sprintf("%X", f.hash.Sum(nil)), "str", sopsLastModified)
+}
+
+// ageKey returns the data key encrypted to a new age identity, along with the identity
+func (f *sopsFixture) ageKey() (string, string) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	a := armor.NewWriter(buf)
+	w, err := age.Encrypt(a, identity.Recipient())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	w.Write(f.key)
+	w.Close()
+	a.Close()
+	return buf.String(), identity.String()
+}
+
+// pgpKey returns the data key encrypted to a new PGP key, along with the armored secret keyring
+func (f *sopsFixture) pgpKey() (string, string) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	msg := &bytes.Buffer{}
+	a, _ := pgparmor.Encode(msg, "PGP MESSAGE", nil)
+	w, err := openpgp.Encrypt(a, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	w.Write(f.key)
+	w.Close()
+	a.Close()
+
+	keyring := &bytes.Buffer{}
+	a, _ = pgparmor.Encode(keyring, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(a, nil); err != nil {
+		f.t.Fatal(err)
+	}
+	a.Close()
+
+	return msg.String(), keyring.String()
+}
+
+// indent indents all lines of s but the first
+func indent(s string, n int) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n"+strings.Repeat(" ", n))
+}
+
+func retrieveSopsSecret(fs afero.Fs, spec core.VaultSpec, name string) (*core.Secret, error) {
+	v := adapters.NewSopsFile(logging.Discard(), fs)
+	vault := &core.Vault{Name: "sops", Type: adapters.SopsFileType, Spec: spec}
+	return v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: name, Type: "secret"})
+}
+
+func TestSopsFileYAML(t *testing.T) {
+	f := newSopsFixture(t)
+	ageEnc, identity := f.ageKey()
+	pgpEnc, keyring := f.pgpKey()
+
+	doc := fmt.Sprintf(`db:
+    password: %s
+    port: %s
+    tls: %s
+hosts:
+    - %s
+    - %s
+sops:
+    age:
+        - recipient: age1test
+          enc: |
+            %s
+    pgp:
+        - fp: 0000
+          enc: |
+            %s
+    lastmodified: "%s"
+    mac: %s
+`,
+		f.enc("s3cr3t", "str", "db", "password"),
+		f.enc("5432", "int", "db", "port"),
+		f.enc("True", "bool", "db", "tls"),
+		f.enc("a.example.com", "str", "hosts"),
+		f.enc("b.example.com", "str", "hosts"),
+		indent(ageEnc, 12), indent(pgpEnc, 12), sopsLastModified, f.mac())
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "secrets.enc.yaml", []byte(doc), 0400)
+	afero.WriteFile(fs, "identity.txt", []byte(identity), 0400)
+	afero.WriteFile(fs, "keyring.asc", []byte(keyring), 0400)
+
+	for _, spec := range []core.VaultSpec{
+		{"path": "secrets.enc.yaml", "identity": "identity.txt"},
+		{"path": "secrets.enc.yaml", "pgpKeyring": "keyring.asc"},
+	} {
+		for _, tc := range []struct {
+			name        string
+			content     string
+			contentType string
+		}{
+			{"db.password", "s3cr3t", ""},
+			{"/db/port", "5432", ""},
+			{"db.tls", "true", ""},
+			{"hosts.1", "b.example.com", ""},
+			{"db", `{"password":"s3cr3t","port":5432,"tls":true}`, "application/json"},
+		} {
+			res, err := retrieveSopsSecret(fs, spec, tc.name)
+			if err != nil {
+				t.Errorf("Unexpected error for %s: %s", tc.name, err)
+				continue
+			}
+			if string(res.RawContent) != tc.content || res.RawContentType != tc.contentType {
+				t.Errorf("Expected %q (%s) for %s, got %q (%s)", tc.content, tc.contentType, tc.name, res.RawContent, res.RawContentType)
+			}
+		}
+
+		_, err := retrieveSopsSecret(fs, spec, "db.user")
+		if err == nil || !strings.Contains(err.Error(), "key db.user not found") {
+			t.Errorf("Expected missing key error, got %v", err)
+		}
+	}
+
+	// swapping encrypted values changes their additional data
+	tampered := strings.Replace(doc, "password:", "user:", 1)
+	afero.WriteFile(fs, "tampered.enc.yaml", []byte(tampered), 0400)
+	_, err := retrieveSopsSecret(fs, core.VaultSpec{"path": "tampered.enc.yaml", "identity": "identity.txt"}, "db.user")
+	if err == nil || !strings.Contains(err.Error(), "unable to decrypt db.user") {
+		t.Errorf("Expected decryption error, got %v", err)
+	}
+
+	// removing a value breaks the MAC
+	lines := strings.Split(doc, "\n")
+	tampered = strings.Join(append(lines[:1], lines[2:]...), "\n")
+	afero.WriteFile(fs, "tampered.enc.yaml", []byte(tampered), 0400)
+	_, err = retrieveSopsSecret(fs, core.VaultSpec{"path": "tampered.enc.yaml", "identity": "identity.txt"}, "db.port")
+	if err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Errorf("Expected MAC mismatch, got %v", err)
+	}
+
+	_, otherIdentity := newSopsFixture(t).ageKey()
+	afero.WriteFile(fs, "other.txt", []byte(otherIdentity), 0400)
+	_, err = retrieveSopsSecret(fs, core.VaultSpec{"path": "secrets.enc.yaml", "identity": "other.txt"}, "db.password")
+	if err == nil || !strings.Contains(err.Error(), "no matching age identity or PGP key") {
+		t.Errorf("Expected no matching key error, got %v", err)
+	}
+}
+
+func TestSopsFileFormats(t *testing.T) {
+	f := newSopsFixture(t)
+	ageEnc, identity := f.ageKey()
+	flatEnc := strings.ReplaceAll(strings.TrimSpace(ageEnc), "\n", `\n`)
+
+	json := fmt.Sprintf(`{
+	"api": {"token": %q},
+	"sops": {"age": [{"recipient": "age1test", "enc": %q}], "lastmodified": %q, "mac": %q}
+}`, f.enc("t0k3n", "str", "api", "token"), ageEnc, sopsLastModified, f.mac())
+
+	f.hash.Reset()
+	dotenv := fmt.Sprintf("API_TOKEN=%s\nsops_age__list_0__map_recipient=age1test\nsops_age__list_0__map_enc=%s\nsops_lastmodified=%s\nsops_mac=%s\n",
+		f.enc("t0k3n", "str", "API_TOKEN"), flatEnc, sopsLastModified, f.mac())
+
+	f.hash.Reset()
+	ini := fmt.Sprintf("[api]\ntoken = %s\n\n[sops]\nage__list_0__map_recipient = age1test\nage__list_0__map_enc = %s\nlastmodified = %s\nmac = %s\n",
+		f.enc("t0k3n", "str", "api", "token"), flatEnc, sopsLastModified, f.mac())
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "identity.txt", []byte(identity), 0400)
+	afero.WriteFile(fs, "secrets.json", []byte(json), 0400)
+	afero.WriteFile(fs, "secrets.env", []byte(dotenv), 0400)
+	afero.WriteFile(fs, "secrets.ini", []byte(ini), 0400)
+	afero.WriteFile(fs, "secrets", []byte(ini), 0400)
+
+	for _, tc := range []struct {
+		spec core.VaultSpec
+		name string
+	}{
+		{core.VaultSpec{"path": "secrets.json"}, "api.token"},
+		{core.VaultSpec{"path": "secrets.env"}, "API_TOKEN"},
+		{core.VaultSpec{"path": "secrets.ini"}, "api.token"},
+		{core.VaultSpec{"path": "secrets", "format": "ini"}, "api.token"},
+	} {
+		tc.spec["identity"] = "identity.txt"
+		res, err := retrieveSopsSecret(fs, tc.spec, tc.name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", tc.spec["path"], err)
+			continue
+		}
+		if string(res.RawContent) != "t0k3n" {
+			t.Errorf("Expected t0k3n for %s, got %q", tc.spec["path"], res.RawContent)
+		}
+	}
+}
+
+// TestSopsFileEncryptedBySops decrypts files encrypted by sops 3.9.4 for the age identity in
+// testdata/sops/identity.txt, partial.enc.yaml with encrypted_regex and mac_only_encrypted
+func TestSopsFileEncryptedBySops(t *testing.T) {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), "testdata/sops")
+
+	for _, tc := range []struct {
+		path, name, exp, contentType string
+	}{
+		{"secrets.enc.yaml", "db.password", "s3cr3t", ""},
+		{"secrets.enc.yaml", "db.port", "5432", ""},
+		{"secrets.enc.yaml", "db.ssl", "true", ""},
+		{"secrets.enc.yaml", "db.ratio", "0.5", ""},
+		{"secrets.enc.yaml", "hosts.1", "b.example.com", ""},
+		{"secrets.enc.yaml", "cert", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", ""},
+		{"secrets.enc.yaml", "db", `{"password":"s3cr3t","port":5432,"ratio":0.5,"ssl":true,"user":"app"}`, "application/json"},
+		{"secrets.enc.json", "db.user", "app", ""},
+		{"secrets.enc.json", "hosts", `["a.example.com","b.example.com"]`, "application/json"},
+		{"secrets.enc.env", "DB_PASSWORD", "s3cr3t", ""},
+		{"secrets.enc.ini", "db.password", "s3cr3t", ""},
+		{"partial.enc.yaml", "db.password", "s3cr3t", ""},
+		{"partial.enc.yaml", "db.user", "app", ""},
+		{"commented.enc.yaml", "db.password", "s3cr3t", ""},
+		{"commented.enc.yaml", "hosts", `["a.example.com","b.example.com"]`, "application/json"},
+		{"commented.enc.env", "DB_PASSWORD", "s3cr3t", ""},
+		{"commented.enc.ini", "db.user", "app", ""},
+		{"suffix.enc.yaml", "db.password", "s3cr3t", ""},
+		{"suffix.enc.yaml", "db.user_unencrypted", "app", ""},
+		{"suffix.enc.yaml", "db.port_unencrypted", "5432", ""},
+		{"suffix.enc.yaml", "hosts_unencrypted.1", "b.example.com", ""},
+	} {
+		res, err := retrieveSopsSecret(fs, core.VaultSpec{"path": tc.path, "identity": "identity.txt"}, tc.name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s in %s: %s", tc.name, tc.path, err)
+			continue
+		}
+		if string(res.RawContent) != tc.exp || res.RawContentType != tc.contentType {
+			t.Errorf("Expected %q (%s) for %s in %s, got %q (%s)", tc.exp, tc.contentType, tc.name, tc.path,
+				res.RawContent, res.RawContentType)
+		}
+	}
+}
+
+func TestSopsFileSpec(t *testing.T) {
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{"identity": "id.txt"}, "path: is required"},
+		{map[interface{}]interface{}{"path": "secrets.yaml"}, "identity: either identity or pgpKeyring is required"},
+		{map[interface{}]interface{}{"path": "secrets.yaml", "identity": "id.txt", "pgpPassphrase": "x"}, "pgpPassphrase: requires pgpKeyring"},
+		{map[interface{}]interface{}{"path": "secrets", "identity": "id.txt"}, "format: is required for files without a known extension"},
+		{map[interface{}]interface{}{"path": "secrets", "identity": "id.txt", "format": "toml"}, "format: must be one of yaml json dotenv ini"},
+	} {
+		if _, err := adapters.NewSopsFileSpec(tc.in); err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected %q for %v, got %v", tc.exp, tc.in, err)
+		}
+	}
+
+	spec, err := adapters.NewSopsFileSpec(map[interface{}]interface{}{"path": "secrets.enc.yml", "identity": "id.txt"})
+	if err != nil || spec.Format != "yaml" {
+		t.Errorf("Expected format yaml from extension, got %v, %v", spec, err)
+	}
+}
@@ -2,10 +2,10 @@ package test
 
 import (
 	"context"
+	"errors"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
 	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
-	"io/ioutil"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
 	"reflect"
 	"testing"
 )
@@ -29,6 +29,13 @@ func TestTemplateTransformationSpec(t *testing.T) {
 		t.Errorf("Expected error creating spec")
 	}
 
+	_, err = adapters.NewTemplateTransformationSpec(core.TransformationSpec{
+		"template": "{{ .s1 ",
+	})
+	var specErr core.SpecError
+	if !errors.As(err, &specErr) || specErr.Field != "template" {
+		t.Errorf("Expected spec error for template, got %v", err)
+	}
 }
 
 func TestTemplateTransformation(t *testing.T) {
@@ -54,7 +61,7 @@ func TestTemplateTransformation(t *testing.T) {
 		},
 	}
 
-	tt := adapters.NewTemplateTransformation(log.New(ioutil.Discard, "", 0))
+	tt := adapters.NewTemplateTransformation(logging.Discard())
 	s, err := tt.ProcessSecret(context.TODO(), &core.Defaults{}, secrets, transformation)
 	if err != nil {
 		t.Errorf("Error processing template transformation: %s", err)
@@ -0,0 +1,28 @@
+#!/bin/sh
+# Fixture for the exec vault. With --json, it reads a request from stdin and writes a response
+# to stdout, otherwise it writes the secret named by the last argument to stdout.
+
+if [ "$1" = "--json" ]; then
+	request=$(cat)
+	case "$request" in
+		*'"version":1'*) ;;
+		*) echo '{"error":"unsupported protocol version"}'; exit 0 ;;
+	esac
+	name=$(echo "$request" | sed -n 's/.*"name":"\([^"]*\)".*/\1/p')
+	case "$name" in
+		db-password) echo '{"value":"s3cr3t"}' ;;
+		binary) echo '{"value":"AAEC/w==","encoding":"base64"}' ;;
+		config) echo '{"value":"{\"user\":\"app\"}","contentType":"application/json"}' ;;
+		sealed) echo '{"error":"vault is sealed","retryable":true}' ;;
+		garbage) echo 'not json' ;;
+		*) echo "{\"error\":\"secret $name not found\",\"notFound\":true}" ;;
+	esac
+	exit 0
+fi
+
+case "$1" in
+	db-password) echo "s3cr3t" ;;
+	slow) exec sleep 5 ;;
+	slow-child) sleep 5; echo "done" ;;
+	*) echo "secret $1 not found" >&2; exit 2 ;;
+esac
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newEmulator starts a TLS server with a self-signed certificate, standing in for a cloud service
+func newEmulator(handler http.Handler) *httptest.Server {
+	res := httptest.NewUnstartedServer(handler)
+	// rejected handshakes are expected
+	res.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	res.StartTLS()
+	return res
+}
+
+// writeCAFile writes the certificate of a test server to a PEM file and returns its path
+func writeCAFile(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	res := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(res, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTLSSpecErrors(t *testing.T) {
+	noCerts := filepath.Join(t.TempDir(), "empty.pem")
+	if err := ioutil.WriteFile(noCerts, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := adapters.NewAWSSecretsManager(logging.Discard())
+	for caFile, exp := range map[string]string{
+		filepath.Join(t.TempDir(), "nosuchfile.pem"): "unable to read CA file",
+		noCerts: "no certificates found in CA file",
+	} {
+		vault := &core.Vault{Name: "emulator", Type: adapters.AWSSecretsManagerType, Spec: core.VaultSpec{
+			"region": "eu-central-1",
+			"caFile": caFile,
+		}}
+		_, err := v.RetrieveSecret(context.TODO(), &core.Defaults{}, vault, &core.Secret{Name: "test", Type: "secret"})
+		if err == nil || !strings.HasPrefix(err.Error(), exp) {
+			t.Errorf("Expected %q, got %v", exp, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package adapters
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSSpec specifies how cloud vaults verify the certificates of their endpoints, e.g. to
+// connect to emulators with self-signed certificates.
+type TLSSpec struct {
+	// CAFile is the path to PEM-encoded certificates to trust in addition to the system pool
+	CAFile string `yaml:"caFile"`
+
+	// InsecureSkipVerify disables the verification of certificates, for tests only
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// isSet returns true if any TLS option deviates from the defaults
+func (s *TLSSpec) isSet() bool {
+	return s.CAFile != "" || s.InsecureSkipVerify
+}
+
+// tlsConfig returns the TLS configuration given by the spec
+func (s *TLSSpec) tlsConfig() (*tls.Config, error) {
+	res := &tls.Config{
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+	if s.CAFile == "" {
+		return res, nil
+	}
+
+	pem, err := ioutil.ReadFile(s.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", s.CAFile)
+	}
+	res.RootCAs = pool
+
+	return res, nil
+}
+
+// httpClient returns a client using the TLS configuration given by the spec, or nil
+// if the defaults apply
+func (s *TLSSpec) httpClient() (*http.Client, error) {
+	if !s.isSet() {
+		return nil, nil
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -1,17 +1,24 @@
 package core
 
 import (
+	"errors"
 	"fmt"
-	"github.com/drone/envsubst"
 	"github.com/go-playground/validator/v10"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 	"io"
+	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 )
 
 // Config is the main configuration struct.
 type Config struct {
+	// Include lists further configuration files, relative to this one, which are merged
+	// before this file. Only evaluated when reading configurations from files.
+	Include []string `yaml:"include" validate:""`
+
 	// Defaults contains settings valid for some or all other sections
 	Defaults Defaults `yaml:"defaults" validate:""`
 
@@ -26,6 +33,11 @@ type Config struct {
 
 	// Sinks define the output sinks for the (transformed) secrets
 	Sinks Sinks `yaml:"sinks" validate:"required,dive"`
+
+	// Profiles override parts of the configuration, selected by name
+	Profiles map[string]*Profile `yaml:"profiles" validate:""`
+
+	positions positions
 }
 
 // NewDefaultConfig returns a configuration struct with valid default settings
@@ -35,48 +47,115 @@ func NewDefaultConfig() *Config {
 
 // NewConfig is the default way of reading configuration from yaml stream
 func NewConfig(in io.Reader) (*Config, error) {
-	yamlDec := yaml.NewDecoder(in)
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConfig("", b)
+}
 
+// NewConfigWithEnvSubst works like NewConfig with environment variable substitution
+// in scalar values. Undefined variables are substituted by empty strings.
+func NewConfigWithEnvSubst(in io.Reader) (*Config, error) {
+	return newConfigWithEnvSubst("", in, LenientEnvSubst)
+}
+
+func newConfig(fileName string, in []byte) (*Config, error) {
 	res := NewDefaultConfig()
-	if err := yamlDec.Decode(res); err != nil {
+	res.positions = newPositions(fileName, in)
+	if err := yaml.Unmarshal(quoteSpecOctals(in), res); err != nil {
 		return res, err
 	}
 
 	return res, nil
 }
 
-// NewConfigWithEnvSubst works like NewConfig with environment variable substitution
-func NewConfigWithEnvSubst(in io.Reader) (*Config, error) {
-	buf := new(strings.Builder)
-	_, err := io.Copy(buf, in)
-	if err != nil {
-		return nil, err
-	}
+var yamlOctal = regexp.MustCompile(`^0[0-7]+$`)
 
-	inSubst, err := envsubst.EvalEnv(buf.String())
-	if err != nil {
-		return nil, err
+// quoteSpecOctals quotes integers with leading zeros within specs, e.g. mode: 0640, which
+// yaml would read as octal numbers otherwise. Specs get their digits as written then, so
+// that 0640 and 640 are the same file mode. Values are quoted in place, lines do not move.
+func quoteSpecOctals(in []byte) []byte {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(in, &doc); err != nil {
+		return in
+	}
+	scalars := make([]*yamlv3.Node, 0)
+	findSpecOctals(&doc, false, &scalars)
+	if len(scalars) == 0 {
+		return in
 	}
 
-	res := NewDefaultConfig()
-	if err = yaml.Unmarshal([]byte(inSubst), res); err != nil {
-		return res, err
+	lines := strings.Split(string(in), "\n")
+	// from the end, so that quoting a value does not move the columns of the others
+	for i := len(scalars) - 1; i >= 0; i-- {
+		n := scalars[i]
+		line := []rune(lines[n.Line-1])
+		start, end := n.Column-1, n.Column-1+len(n.Value)
+		if end > len(line) || string(line[start:end]) != n.Value {
+			continue
+		}
+		lines[n.Line-1] = string(line[:start]) + `"` + n.Value + `"` + string(line[end:])
 	}
+	return []byte(strings.Join(lines, "\n"))
+}
 
-	return res, nil
+// findSpecOctals collects the plain integer scalars with leading zeros below n, within specs only
+func findSpecOctals(n *yamlv3.Node, inSpec bool, res *[]*yamlv3.Node) {
+	switch n.Kind {
+	case yamlv3.DocumentNode, yamlv3.SequenceNode:
+		for _, c := range n.Content {
+			findSpecOctals(c, inSpec, res)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			findSpecOctals(n.Content[i+1], inSpec || n.Content[i].Value == "spec", res)
+		}
+	case yamlv3.ScalarNode:
+		if inSpec && n.Style == 0 && n.Tag == "!!int" && yamlOctal.MatchString(n.Value) {
+			*res = append(*res, n)
+		}
+	}
 }
 
-// NewConfigFromFile creates a configuration from yaml file
+// NewConfigFromFile creates a configuration from yaml file, including the files it includes
 func NewConfigFromFile(fileName string, withEnvSubst bool) (*Config, error) {
+	mode := NoEnvSubst
+	if withEnvSubst {
+		mode = LenientEnvSubst
+	}
+	return NewConfigFromFiles([]string{fileName}, mode)
+}
+
+func newConfigFromFile(fileName string, mode EnvSubstMode) (*Config, error) {
 	f, err := os.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	if withEnvSubst {
-		return NewConfigWithEnvSubst(f)
+	return newConfigWithEnvSubst(fileName, f, mode)
+}
+
+// PositionOf returns the position of an element within the configuration file, given
+// by its path, e.g. "sinks[0].spec.mode". If the element is not found, the position of
+// its closest parent is returned. The position is invalid if the configuration was not
+// read from yaml.
+func (c *Config) PositionOf(path string) Position {
+	if c.positions == nil {
+		return Position{}
+	}
+	return c.positions.lookup(path)
+}
+
+// errorAt wraps err into a ConfigError at the position of given path
+func (c *Config) errorAt(path string, err error) error {
+	pos := c.PositionOf(path)
+	if !pos.IsValid() {
+		return err
 	}
-	return NewConfig(f)
+	return ConfigError{Pos: pos, Err: err}
 }
 
 // IsVarDefined checks if given variable name is defined, either in
@@ -97,6 +176,34 @@ func (c *Config) IsVarDefined(varName string) bool {
 	return false
 }
 
+// OptionalSecretsOf returns all optional secrets the given variable depends on,
+// either directly or through transformations.
+func (c *Config) OptionalSecretsOf(varName string) []*Secret {
+	res := make([]*Secret, 0)
+	c.collectOptionalSecrets(varName, make(map[string]struct{}), &res)
+	return res
+}
+
+func (c *Config) collectOptionalSecrets(varName string, visited map[string]struct{}, res *[]*Secret) {
+	if _, ex := visited[varName]; ex {
+		return
+	}
+	visited[varName] = struct{}{}
+
+	for _, secret := range c.Secrets {
+		if secret.Name == varName && secret.Optional {
+			*res = append(*res, secret)
+		}
+	}
+	for _, transformation := range c.Transformations {
+		if transformation.Output == varName {
+			for _, inputVar := range transformation.Input {
+				c.collectOptionalSecrets(inputVar, visited, res)
+			}
+		}
+	}
+}
+
 func validateSecretType(fl validator.FieldLevel) bool {
 	for _, secretType := range ValidSecretTypes() {
 		if secretType == fl.Field().String() {
@@ -107,67 +214,123 @@ func validateSecretType(fl validator.FieldLevel) bool {
 }
 
 // Validate validates a configuration using the validator and
-// additional cross checks.
+// additional cross checks. It returns the first error found.
 func (c *Config) Validate(f Factory) error {
+	if errs := c.validate(f); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validate runs all checks and returns all errors found, in the order of the sections
+func (c *Config) validate(f Factory) []error {
 	v := validator.New()
-	v.RegisterValidation("valid-secret-type", validateSecretType)
 
-	st := make(map[string]struct{})
-	for _, e := range f.SinkTypes() {
-		st[e] = struct{}{}
-	}
 	vat := make(map[string]struct{})
 	for _, e := range f.VaultAccessorTypes() {
 		vat[e] = struct{}{}
 	}
-	tt := make(map[string]struct{})
-	for _, e := range f.TransformationTypes() {
-		tt[e] = struct{}{}
+
+	res := make([]error, 0)
+
+	if err := v.Struct(c.Defaults); err != nil {
+		res = append(res, c.errorAt("defaults", err))
 	}
 
-	for _, vault := range c.Vaults {
+	for i, vault := range c.Vaults {
 		if err := v.Struct(vault); err != nil {
-			return err
+			res = append(res, c.errorAt(fmt.Sprintf("vaults[%d]", i), err))
+			continue
 		}
 
 		if _, ex := vat[vault.Type]; !ex {
-			return fmt.Errorf("unknown vault type: %s in vault: %s", vault.Type, vault.Name)
+			res = append(res, c.errorAt(fmt.Sprintf("vaults[%d].type", i),
+				fmt.Errorf("unknown vault type: %s in vault: %s", vault.Type, vault.Name)))
+			continue
 		}
-	}
-
-	if err := c.validateSecrets(f); err != nil {
-		return err
-	}
 
-	if err := c.validateTransformations(f); err != nil {
-		return err
+		if field, err := validateSpec(f.NewVaultAccessor(vault.Type), vault.Spec); err != nil {
+			res = append(res, c.errorAt(fmt.Sprintf("vaults[%d].spec.%s", i, field),
+				fmt.Errorf("invalid spec in vault %s: %w", vault.Name, err)))
+		}
 	}
 
-	if err := c.validateSinks(f); err != nil {
-		return err
-	}
+	res = append(res, c.validateSecrets(f)...)
+	res = append(res, c.validateVaultRefs()...)
+	res = append(res, c.validateTransformations(f)...)
+	res = append(res, c.validateSinks(f)...)
+	res = append(res, c.validateProfiles(f, res)...)
 
-	return nil
+	return res
 }
 
-func (c *Config) validateSecrets(f Factory) error {
+func (c *Config) validateSecrets(f Factory) []error {
 	v := validator.New()
 	v.RegisterValidation("valid-secret-type", validateSecretType)
 
-	for _, secret := range c.Secrets {
+	res := make([]error, 0)
+	defined := make(map[string]struct{})
+	for i, secret := range c.Secrets {
+		path := fmt.Sprintf("secrets[%d]", i)
 		if err := v.Struct(secret); err != nil {
-			return err
+			res = append(res, c.errorAt(path, err))
+			continue
 		}
 
 		if v := c.Vaults.GetVaultByName(secret.VaultName); v == nil {
-			return fmt.Errorf("invalid vault %s referenced in secret %s", secret.VaultName, secret.Name)
+			res = append(res, c.errorAt(path+".vault",
+				fmt.Errorf("invalid vault %s referenced in secret %s", secret.VaultName, secret.Name)))
 		}
+		for j, vaultName := range secret.FallbackVaults {
+			if v := c.Vaults.GetVaultByName(vaultName); v == nil {
+				res = append(res, c.errorAt(fmt.Sprintf("%s.fallbackVaults[%d]", path, j),
+					fmt.Errorf("invalid fallback vault %s referenced in secret %s", vaultName, secret.Name)))
+			}
+		}
+
+		if secret.HasDefault() && !secret.Optional {
+			res = append(res, c.errorAt(path+".optional",
+				fmt.Errorf("secret %s has a default, but is not optional", secret.Name)))
+		}
+		if secret.DefaultFrom != "" {
+			if _, ex := defined[secret.DefaultFrom]; !ex {
+				res = append(res, c.errorAt(path+".defaultFrom",
+					fmt.Errorf("default of secret %s must reference a secret defined before: %s",
+						secret.Name, secret.DefaultFrom)))
+			}
+		}
+		defined[secret.Name] = struct{}{}
 	}
 
-	return nil
+	return res
+}
+
+// validateVaultRefs checks that secrets referenced in vault specs are defined and
+// that vaults do not depend on each other in a cycle
+func (c *Config) validateVaultRefs() []error {
+	defined := make(map[string]struct{}, len(c.Secrets))
+	for _, secret := range c.Secrets {
+		defined[secret.Name] = struct{}{}
+	}
+
+	res := make([]error, 0)
+	for i, vault := range c.Vaults {
+		for _, ref := range vault.SecretRefs() {
+			if _, ex := defined[ref]; !ex {
+				res = append(res, c.errorAt(fmt.Sprintf("vaults[%d].spec", i),
+					fmt.Errorf("invalid secret %s referenced in spec of vault %s", ref, vault.Name)))
+			}
+		}
+	}
+
+	if _, err := OrderSecrets(&c.Vaults, &c.Secrets); err != nil {
+		res = append(res, c.errorAt("vaults", err))
+	}
+
+	return res
 }
 
-func (c *Config) validateTransformations(f Factory) error {
+func (c *Config) validateTransformations(f Factory) []error {
 	v := validator.New()
 
 	tt := make(map[string]struct{})
@@ -175,27 +338,38 @@ func (c *Config) validateTransformations(f Factory) error {
 		tt[e] = struct{}{}
 	}
 
-	for _, transformation := range c.Transformations {
+	res := make([]error, 0)
+	for i, transformation := range c.Transformations {
+		path := fmt.Sprintf("transformations[%d]", i)
 		if err := v.Struct(transformation); err != nil {
-			return err
+			res = append(res, c.errorAt(path, err))
+			continue
 		}
 
 		if _, ex := tt[transformation.Type]; !ex {
-			return fmt.Errorf("unknown transformation type: %s", transformation.Type)
+			res = append(res, c.errorAt(path+".type",
+				fmt.Errorf("unknown transformation type: %s", transformation.Type)))
+			continue
+		}
+
+		if field, err := validateSpec(f.NewTransformation(transformation.Type), transformation.Spec); err != nil {
+			res = append(res, c.errorAt(fmt.Sprintf("%s.spec.%s", path, field),
+				fmt.Errorf("invalid spec in transformation for %s: %w", transformation.Output, err)))
 		}
 
 		// all input variables have to be defined
-		for _, inputVar := range transformation.Input {
+		for j, inputVar := range transformation.Input {
 			if !c.IsVarDefined(inputVar) {
-				return fmt.Errorf("unknown input variable: %s", inputVar)
+				res = append(res, c.errorAt(fmt.Sprintf("%s.in[%d]", path, j),
+					fmt.Errorf("unknown input variable: %s", inputVar)))
 			}
 		}
 	}
 
-	return nil
+	return res
 }
 
-func (c *Config) validateSinks(f Factory) error {
+func (c *Config) validateSinks(f Factory) []error {
 	v := validator.New()
 
 	st := make(map[string]struct{})
@@ -203,19 +377,94 @@ func (c *Config) validateSinks(f Factory) error {
 		st[e] = struct{}{}
 	}
 
-	for _, sink := range c.Sinks {
+	res := make([]error, 0)
+	for i, sink := range c.Sinks {
+		path := fmt.Sprintf("sinks[%d]", i)
 		if err := v.Struct(sink); err != nil {
-			return err
+			res = append(res, c.errorAt(path, err))
+			continue
 		}
 
 		if _, ex := st[sink.Type]; !ex {
-			return fmt.Errorf("unknown sink type: %s", sink.Type)
+			res = append(res, c.errorAt(path+".type", fmt.Errorf("unknown sink type: %s", sink.Type)))
+			continue
+		}
+
+		if field, err := validateSpec(f.NewSinkWriter(sink.Type), sink.Spec); err != nil {
+			res = append(res, c.errorAt(fmt.Sprintf("%s.spec.%s", path, field),
+				fmt.Errorf("invalid spec in sink for %s: %w", sink.Var, err)))
 		}
 
 		if !c.IsVarDefined(sink.Var) {
-			return fmt.Errorf("invalid variable %s referenced in a sink", sink.Var)
+			res = append(res, c.errorAt(path+".var",
+				fmt.Errorf("invalid variable %s referenced in a sink", sink.Var)))
+			continue
+		}
+
+		if err := c.validateSinkIfAbsent(f, sink); err != nil {
+			res = append(res, c.errorAt(path+".ifAbsent", err))
+		}
+	}
+
+	return res
+}
+
+// validateSinkIfAbsent makes sure that a sink depending on optional secrets declares
+// what to do if they are absent, and that it is able to do so.
+func (c *Config) validateSinkIfAbsent(f Factory, sink *Sink) error {
+	optionalSecrets := c.OptionalSecretsOf(sink.Var)
+	if len(optionalSecrets) == 0 {
+		return nil
+	}
+
+	switch sink.IfAbsent {
+	case "":
+		return fmt.Errorf("sink for %s depends on optional secret %s and must declare ifAbsent",
+			sink.Var, optionalSecrets[0].Name)
+	case SinkIfAbsentDefault:
+		for _, secret := range optionalSecrets {
+			if !secret.HasDefault() {
+				return fmt.Errorf("sink for %s writes defaults, but optional secret %s has no default",
+					sink.Var, secret.Name)
+			}
+		}
+	case SinkIfAbsentRemove:
+		if _, ok := f.NewSinkWriter(sink.Type).(SinkRemoverPort); !ok {
+			return fmt.Errorf("sink for %s is of type %s, which does not support removal", sink.Var, sink.Type)
 		}
 	}
 
 	return nil
 }
+
+// validateSpec checks a spec against the schema of the port, if the port declares one,
+// and lets the port validate the spec, if it is able to. Specs referencing secrets can
+// only be validated by the port once they are resolved while processing. It returns the
+// offending field along with the error.
+func validateSpec(port interface{}, spec map[interface{}]interface{}) (string, error) {
+	if spec == nil {
+		spec = map[interface{}]interface{}{}
+	}
+
+	if p, ok := port.(SpecSchemaProvider); ok {
+		if errs := p.SpecSchema().Validate(spec); len(errs) > 0 {
+			return errs[0].Path, errs[0]
+		}
+	}
+
+	if p, ok := port.(SpecValidator); ok && !hasSecretRefs(spec) {
+		if err := p.ValidateSpec(spec); err != nil {
+			var specErrs SpecErrors
+			var specErr SpecError
+			switch {
+			case errors.As(err, &specErrs) && len(specErrs) > 0:
+				return specErrs[0].Field, specErrs[0]
+			case errors.As(err, &specErr):
+				return specErr.Field, specErr
+			}
+			return "", err
+		}
+	}
+
+	return "", nil
+}
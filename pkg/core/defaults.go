@@ -1,4 +1,7 @@
 package core
 
 // Defaults holds default values for parameters.
-type Defaults struct{}
+type Defaults struct {
+	// Retry is the retry policy for all vaults, unless overridden by a vault
+	Retry *RetryPolicy `yaml:"retry" validate:"omitempty"`
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// EnvSubstMode selects how environment variables in configuration files are substituted
+type EnvSubstMode int
+
+const (
+	// NoEnvSubst leaves configuration files as they are
+	NoEnvSubst EnvSubstMode = iota
+
+	// LenientEnvSubst substitutes environment variables, undefined variables become empty strings
+	LenientEnvSubst
+
+	// StrictEnvSubst substitutes environment variables and fails on undefined variables
+	StrictEnvSubst
+)
+
+// EnvSubstError describes a variable which cannot be substituted
+type EnvSubstError struct {
+	// Pos is the position of the scalar containing the variable
+	Pos Position
+
+	// Var is the name of the variable
+	Var string
+
+	// Message describes the problem
+	Message string
+}
+
+func (e EnvSubstError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// EnvSubstErrors lists all variables of a configuration which cannot be substituted
+type EnvSubstErrors []EnvSubstError
+
+func (e EnvSubstErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// NewConfigWithStrictEnvSubst works like NewConfigWithEnvSubst, but fails if variables are
+// undefined, listing all of them.
+func NewConfigWithStrictEnvSubst(in io.Reader) (*Config, error) {
+	return newConfigWithEnvSubst("", in, StrictEnvSubst)
+}
+
+// newConfigWithEnvSubst substitutes environment variables within all scalar values of the yaml
+// source before decoding it, so that values containing e.g. colons or newlines do not alter
+// its structure.
+func newConfigWithEnvSubst(fileName string, in io.Reader, mode EnvSubstMode) (*Config, error) {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if mode == NoEnvSubst {
+		return newConfig(fileName, b)
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		// empty document
+		return newConfig(fileName, b)
+	}
+
+	errs := make(EnvSubstErrors, 0)
+	substituteEnvInNode(fileName, &doc, os.LookupEnv, mode == StrictEnvSubst, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	subst, err := yamlv3.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := newConfig(fileName, subst)
+	if err != nil {
+		return res, err
+	}
+	// positions refer to the original source
+	res.positions = newPositions(fileName, b)
+
+	return res, nil
+}
+
+// substituteEnvInNode substitutes variables in all scalar values below n, keys are left untouched
+func substituteEnvInNode(fileName string, n *yamlv3.Node, lookup func(string) (string, bool), strict bool, errs *EnvSubstErrors) {
+	switch n.Kind {
+	case yamlv3.DocumentNode, yamlv3.SequenceNode:
+		for _, c := range n.Content {
+			substituteEnvInNode(fileName, c, lookup, strict, errs)
+		}
+	case yamlv3.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			substituteEnvInNode(fileName, n.Content[i], lookup, strict, errs)
+		}
+	case yamlv3.ScalarNode:
+		if !strings.Contains(n.Value, "$") {
+			return
+		}
+		value, problems := SubstituteEnv(n.Value, lookup, strict)
+		for _, p := range problems {
+			p.Pos = Position{File: fileName, Line: n.Line, Column: n.Column}
+			*errs = append(*errs, p)
+		}
+		n.Value = value
+		if n.Style == 0 || n.Style == yamlv3.TaggedStyle {
+			// let plain scalars resolve to numbers or booleans like before substitution
+			n.Tag = ""
+		}
+	}
+}
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envOperators are the operators of SubstituteEnv, two-character ones first
+var envOperators = []string{":-", ":=", ":?", "-", "=", "?"}
+
+// SubstituteEnv expands references to variables in s:
+//
+//	${VAR}             value of VAR, an error in strict mode if VAR is undefined
+//	${VAR:-default}    default if VAR is undefined or empty, ${VAR:=default} likewise
+//	${VAR-default}     default if VAR is undefined, ${VAR=default} likewise
+//	${VAR:?message}    fails with message if VAR is undefined or empty
+//	${VAR?message}     fails with message if VAR is undefined
+//	$${                a literal ${
+//	${{ ... }}         kept as it is, e.g. references to secrets in vault specs
+//
+// All other dollar signs are kept as they are. Problems are returned for all variables
+// that cannot be substituted, and for references with any other operator, e.g. ${VAR:abc}.
+func SubstituteEnv(s string, lookup func(string) (string, bool), strict bool) (string, []EnvSubstError) {
+	var b strings.Builder
+	problems := make([]EnvSubstError, 0)
+
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], "$${") {
+			b.WriteString("${")
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(s[i:], "${{") {
+			if end := strings.Index(s[i:], "}}"); end >= 0 {
+				b.WriteString(s[i : i+end+2])
+				i += end + 1
+				continue
+			}
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		expr := s[i+2 : i+end]
+		i += end
+
+		value, problem := expandEnvExpr(expr, lookup, strict)
+		if problem != nil {
+			problems = append(problems, *problem)
+		}
+		b.WriteString(value)
+	}
+
+	return b.String(), problems
+}
+
+// expandEnvExpr expands the expression within ${...}
+func expandEnvExpr(expr string, lookup func(string) (string, bool), strict bool) (string, *EnvSubstError) {
+	name, op, arg := expr, "", ""
+	if i := strings.IndexAny(expr, ":-=?"); i >= 0 {
+		name = expr[:i]
+		for _, o := range envOperators {
+			if strings.HasPrefix(expr[i:], o) {
+				op, arg = o, expr[i+len(o):]
+				break
+			}
+		}
+		if op == "" {
+			return "", &EnvSubstError{Var: name, Message: fmt.Sprintf("invalid variable reference ${%s}, "+
+				"expected one of the operators %s", expr, strings.Join(envOperators, " "))}
+		}
+	}
+	if !envVarName.MatchString(name) {
+		return "", &EnvSubstError{Var: name, Message: fmt.Sprintf("invalid variable reference ${%s}", expr)}
+	}
+
+	value, defined := lookup(name)
+	missing := !defined || (strings.HasPrefix(op, ":") && value == "")
+
+	switch op {
+	case "":
+		if !defined && strict {
+			return "", &EnvSubstError{Var: name, Message: fmt.Sprintf("environment variable %s is not defined", name)}
+		}
+	case ":-", ":=", "-", "=":
+		if missing {
+			return arg, nil
+		}
+	case ":?", "?":
+		if missing {
+			if arg == "" {
+				arg = "is not defined"
+				if defined {
+					arg = "is empty"
+				}
+			}
+			return "", &EnvSubstError{Var: name, Message: fmt.Sprintf("environment variable %s: %s", name, arg)}
+		}
+	}
+
+	return value, nil
+}
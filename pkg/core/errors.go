@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"os"
+)
+
+// ErrVaultAccess is returned when a secret cannot be retrieved from a vault. It wraps
+// the error of the vault accessor.
+type ErrVaultAccess struct {
+	// Vault is the name of the vault
+	Vault string
+
+	// Secret is the name of the secret
+	Secret string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e ErrVaultAccess) Error() string {
+	return fmt.Sprintf("unable to retrieve secret %s from vault %s: %s", e.Secret, e.Vault, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ErrVaultAccess) Unwrap() error { return e.Err }
+
+// ErrNotFound is returned by vault accessors for secrets that do not exist in a vault, as
+// opposed to secrets that cannot be accessed. It matches os.ErrNotExist, so that optional
+// secrets are treated as absent.
+type ErrNotFound struct {
+	// Err is the underlying error
+	Err error
+}
+
+func (e ErrNotFound) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e ErrNotFound) Unwrap() error { return e.Err }
+
+// Is returns true for os.ErrNotExist.
+func (e ErrNotFound) Is(target error) bool { return target == os.ErrNotExist }
+
+// ErrFatal is returned by vault accessors for errors that must not be retried, even if the
+// attempt timed out, e.g. because the accessor cannot be used anymore.
+type ErrFatal struct {
+	// Err is the underlying error
+	Err error
+}
+
+func (e ErrFatal) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e ErrFatal) Unwrap() error { return e.Err }
+
+// ErrTransformation is returned when a transformation step fails. It wraps
+// the error of the transformation.
+type ErrTransformation struct {
+	// Output is the name of the output variable of the transformation
+	Output string
+
+	// Type is the type of transformation
+	Type string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e ErrTransformation) Error() string {
+	return fmt.Sprintf("unable to apply %s transformation for %s: %s", e.Type, e.Output, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ErrTransformation) Unwrap() error { return e.Err }
+
+// ErrSink is returned when a variable cannot be written to a sink. It wraps
+// the error of the sink writer.
+type ErrSink struct {
+	// Type is the type of sink
+	Type string
+
+	// Var is the name of the variable written to the sink
+	Var string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e ErrSink) Error() string {
+	return fmt.Sprintf("unable to write %s to %s sink: %s", e.Var, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ErrSink) Unwrap() error { return e.Err }
+
+// ErrTypeCollision is returned when a type is registered with a RegistryFactory that
+// already provides it, or when a factory registered with it provides a type twice.
+type ErrTypeCollision struct {
+	// Kind is vault, transformation or sink
+	Kind string
+
+	// Type is the type that is registered twice
+	Type string
+}
+
+func (e ErrTypeCollision) Error() string {
+	return fmt.Sprintf("%s type %s is already registered", e.Kind, e.Type)
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Severity of a lint finding
+type Severity string
+
+const (
+	// SeverityError marks findings that make the configuration invalid
+	SeverityError Severity = "error"
+
+	// SeverityWarning marks findings about questionable, but valid settings
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single problem reported by Lint
+type Finding struct {
+	// Severity of the finding
+	Severity Severity
+
+	// Pos is the position of the offending element, if known
+	Pos Position
+
+	// Message describes the problem
+	Message string
+}
+
+func (f Finding) String() string {
+	if !f.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Pos, f.Severity, f.Message)
+}
+
+// SpecLinter is optionally implemented by vault accessors, transformations and sink
+// writers to warn about questionable, but valid specs.
+type SpecLinter interface {
+	// LintSpec returns warnings about a valid spec. vars are the variables available to
+	// the spec, i.e. the input of a transformation or the variable of a sink.
+	LintSpec(spec map[interface{}]interface{}, vars []string) []SpecError
+}
+
+// SinkTargetPort is optionally implemented by sink writers to identify where a sink writes to,
+// so that sinks overwriting each other can be detected.
+type SinkTargetPort interface {
+	// Target returns an identifier of the destination of the sink, e.g. a file path,
+	// or an empty string if it cannot be determined.
+	Target(*Sink) string
+}
+
+// Lint validates the configuration like Validate, but collects all errors instead of
+// stopping at the first one, and adds warnings about questionable settings. Findings
+// are sorted by their position.
+func (c *Config) Lint(f Factory) []Finding {
+	res := make([]Finding, 0)
+
+	for _, err := range c.validate(f) {
+		finding := Finding{Severity: SeverityError, Message: err.Error()}
+		var errConfig ConfigError
+		if errors.As(err, &errConfig) {
+			finding.Pos, finding.Message = errConfig.Pos, errConfig.Err.Error()
+		}
+		res = append(res, finding)
+	}
+
+	warn := func(path, format string, args ...interface{}) {
+		res = append(res, Finding{Severity: SeverityWarning, Pos: c.PositionOf(path), Message: fmt.Sprintf(format, args...)})
+	}
+	c.lintNames(warn)
+	c.lintUnusedVars(warn)
+	c.lintSpecs(f, warn)
+	c.lintSinkTargets(f, warn)
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i].Pos, res[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return res
+}
+
+type warnFunc func(path, format string, args ...interface{})
+
+// lintNames warns about vaults and variables defined more than once
+func (c *Config) lintNames(warn warnFunc) {
+	vaults := make(map[string]struct{})
+	for i, vault := range c.Vaults {
+		if _, ex := vaults[vault.Name]; ex {
+			warn(fmt.Sprintf("vaults[%d].name", i), "duplicate vault name %s", vault.Name)
+		}
+		vaults[vault.Name] = struct{}{}
+	}
+
+	vars := make(map[string]struct{})
+	for i, secret := range c.Secrets {
+		if _, ex := vars[secret.Name]; ex {
+			warn(fmt.Sprintf("secrets[%d].name", i), "duplicate variable name %s", secret.Name)
+		}
+		vars[secret.Name] = struct{}{}
+	}
+	for i, transformation := range c.Transformations {
+		if _, ex := vars[transformation.Output]; ex {
+			warn(fmt.Sprintf("transformations[%d].out", i), "duplicate variable name %s", transformation.Output)
+		}
+		vars[transformation.Output] = struct{}{}
+	}
+}
+
+// lintUnusedVars warns about secrets and transformation outputs that are neither
+// transformed, written to a sink nor referenced by a vault
+func (c *Config) lintUnusedVars(warn warnFunc) {
+	used := make(map[string]struct{})
+	for _, secret := range c.Secrets {
+		used[secret.DefaultFrom] = struct{}{}
+	}
+	for _, vault := range c.Vaults {
+		for _, ref := range vault.SecretRefs() {
+			used[ref] = struct{}{}
+		}
+	}
+	for _, transformation := range c.Transformations {
+		for _, inputVar := range transformation.Input {
+			used[inputVar] = struct{}{}
+		}
+	}
+	for _, sink := range c.Sinks {
+		used[sink.Var] = struct{}{}
+	}
+
+	for i, secret := range c.Secrets {
+		if _, ex := used[secret.Name]; !ex {
+			warn(fmt.Sprintf("secrets[%d].name", i), "secret %s is not used by any transformation or sink", secret.Name)
+		}
+	}
+	for i, transformation := range c.Transformations {
+		if _, ex := used[transformation.Output]; !ex {
+			warn(fmt.Sprintf("transformations[%d].out", i), "output %s is not used by any transformation or sink",
+				transformation.Output)
+		}
+	}
+}
+
+// lintSpecs collects the warnings of all ports implementing SpecLinter
+func (c *Config) lintSpecs(f Factory, warn warnFunc) {
+	lint := func(path string, port interface{}, spec map[interface{}]interface{}, vars []string) {
+		l, ok := port.(SpecLinter)
+		if !ok || spec == nil {
+			return
+		}
+		if _, err := validateSpec(port, spec); err != nil {
+			// already reported as error
+			return
+		}
+		for _, w := range l.LintSpec(spec, vars) {
+			warn(joinPath(path+".spec", w.Field), "%s", w.Message)
+		}
+	}
+
+	for i, vault := range c.Vaults {
+		lint(fmt.Sprintf("vaults[%d]", i), f.NewVaultAccessor(vault.Type), vault.Spec, nil)
+	}
+	for i, transformation := range c.Transformations {
+		lint(fmt.Sprintf("transformations[%d]", i), f.NewTransformation(transformation.Type),
+			transformation.Spec, transformation.Input)
+	}
+	for i, sink := range c.Sinks {
+		lint(fmt.Sprintf("sinks[%d]", i), f.NewSinkWriter(sink.Type), sink.Spec, []string{sink.Var})
+	}
+}
+
+// lintSinkTargets warns about sinks writing to the same destination
+func (c *Config) lintSinkTargets(f Factory, warn warnFunc) {
+	targets := make(map[string]string)
+	for i, sink := range c.Sinks {
+		p, ok := f.NewSinkWriter(sink.Type).(SinkTargetPort)
+		if !ok {
+			continue
+		}
+		if _, err := validateSpec(p, sink.Spec); err != nil {
+			continue
+		}
+		target := p.Target(sink)
+		if target == "" {
+			continue
+		}
+		key := sink.Type + ":" + target
+		if other, ex := targets[key]; ex {
+			warn(fmt.Sprintf("sinks[%d].spec", i), "sink for %s writes to %s like the sink for %s", sink.Var, target, other)
+			continue
+		}
+		targets[key] = sink.Var
+	}
+}
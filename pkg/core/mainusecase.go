@@ -4,19 +4,97 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"os"
+	"time"
 )
 
 // MainUseCaseImpl implements the UseCase interface.
 type MainUseCaseImpl struct {
-	log *log.Logger
+	log        *logging.Logger
+	keepGoing  bool
+	hooks      Hooks
+	repository Repository
+}
+
+// Hooks are called at the boundaries of processing steps, e.g. to instrument them.
+// All funcs are optional.
+type Hooks struct {
+	// BeforeStep is called before a step is run, with its kind, name and type as far as known.
+	// It is not called for skipped steps.
+	BeforeStep func(context.Context, StepResult)
+
+	// AfterStep is called with the outcome of every step, including skipped ones, and the
+	// time it took to run the step.
+	AfterStep func(context.Context, StepResult, time.Duration)
+
+	// Produced is called after AfterStep for steps that put a variable into the repository,
+	// i.e. retrieved secrets, defaults of absent secrets and outputs of transformations.
+	Produced func(context.Context, StepResult, *Secret)
+}
+
+// JoinHooks returns hooks calling all funcs of the given hooks in order.
+func JoinHooks(hooks ...Hooks) Hooks {
+	return Hooks{
+		BeforeStep: func(ctx context.Context, step StepResult) {
+			for _, h := range hooks {
+				if h.BeforeStep != nil {
+					h.BeforeStep(ctx, step)
+				}
+			}
+		},
+		AfterStep: func(ctx context.Context, step StepResult, d time.Duration) {
+			for _, h := range hooks {
+				if h.AfterStep != nil {
+					h.AfterStep(ctx, step, d)
+				}
+			}
+		},
+		Produced: func(ctx context.Context, step StepResult, secret *Secret) {
+			for _, h := range hooks {
+				if h.Produced != nil {
+					h.Produced(ctx, step, secret)
+				}
+			}
+		},
+	}
+}
+
+// MainUseCaseOpts is the fluent-style configuration option func
+type MainUseCaseOpts func(*MainUseCaseImpl)
+
+// WithKeepGoing lets processing continue after a failed step. Only steps depending
+// on the output of a failed step are skipped.
+func WithKeepGoing(keepGoing bool) MainUseCaseOpts {
+	return func(m *MainUseCaseImpl) {
+		m.keepGoing = keepGoing
+	}
+}
+
+// WithHooks sets hooks that are called at the boundaries of processing steps.
+func WithHooks(hooks Hooks) MainUseCaseOpts {
+	return func(m *MainUseCaseImpl) {
+		m.hooks = hooks
+	}
+}
+
+// WithRepository lets processing put all variables into the given repository instead of
+// one created by the factory, so that they can be read after processing.
+func WithRepository(repository Repository) MainUseCaseOpts {
+	return func(m *MainUseCaseImpl) {
+		m.repository = repository
+	}
 }
 
 // NewMainUseCaseImpl creates a new main use case.
-func NewMainUseCaseImpl(l *log.Logger) UseCase {
-	return &MainUseCaseImpl{
+func NewMainUseCaseImpl(l *logging.Logger, opts ...MainUseCaseOpts) UseCase {
+	res := &MainUseCaseImpl{
 		log: l,
 	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
 }
 
 // RetrieveSecret pulls a single secret from a vault and puts it into a Repository.
@@ -24,12 +102,27 @@ func (m *MainUseCaseImpl) RetrieveSecret(ctx context.Context, factory Factory, d
 
 	va := factory.NewVaultAccessor(vault.Type)
 	if va == nil {
-		return errors.New("internal error: unable to handle vault of given type")
+		return ErrVaultAccess{Vault: vault.Name, Secret: secret.Name,
+			Err: errors.New("internal error: unable to handle vault of given type")}
 	}
+	va = NewRetryingVaultAccessor(m.log, va, EffectiveRetryPolicy(defaults, vault))
 
-	updatedSecret, err := va.RetrieveSecret(ctx, defaults, vault, secret)
+	// vaults look secrets up by name, which is the key within the vault
+	remote := secret
+	if secret.Key != "" {
+		s := *secret
+		s.Name, s.Key = secret.Key, ""
+		remote = &s
+	}
+
+	updatedSecret, err := va.RetrieveSecret(ctx, defaults, vault, remote)
 	if err != nil {
-		return err
+		return ErrVaultAccess{Vault: vault.Name, Secret: secret.Name, Err: err}
+	}
+	if remote != secret {
+		s := *updatedSecret
+		s.Name = secret.Name
+		updatedSecret = &s
 	}
 
 	repository.Put(secret.Name, updatedSecret)
@@ -41,31 +134,29 @@ func (m *MainUseCaseImpl) RetrieveSecret(ctx context.Context, factory Factory, d
 func (m *MainUseCaseImpl) Transform(ctx context.Context, factory Factory,
 	defaults *Defaults, repository Repository, secrets *Secrets, transformation *Transformation) error {
 
-	secretByName := make(map[string]*Secret)
-	for _, secret := range *secrets {
-		s, err := repository.Get(secret.Name)
-		if err != nil {
-			return err
-		}
-		secretByName[secret.Name] = s.(*Secret)
-	}
-
 	tr := factory.NewTransformation(transformation.Type)
+	if tr == nil {
+		return ErrTransformation{Output: transformation.Output, Type: transformation.Type,
+			Err: errors.New("internal error: unable to handle transformation of given type")}
+	}
 
-	// collect all secrets that are required by the transformation.
+	// collect all secrets that are required by the transformation. These are either
+	// secrets from vaults or outputs of previous transformations.
 	in := make(Secrets, 0)
 	for _, inputVarName := range transformation.Input {
-		s, ex := secretByName[inputVarName]
-		if !ex {
-			return fmt.Errorf("transformation: input variable %s not found", inputVarName)
+		s, err := repository.Get(inputVarName)
+		if err != nil {
+			return ErrTransformation{Output: transformation.Output, Type: transformation.Type,
+				Err: fmt.Errorf("input variable %s not found", inputVarName)}
 		}
-		in = append(in, s)
+		in = append(in, s.(*Secret))
 	}
 
-	m.log.Printf("Calling ProcessSecret %#v, %#v, %#v, %#v", ctx, defaults, in, transformation)
+	m.log.Debug("Applying transformation", logging.Transformation(transformation.Type),
+		logging.Strings("in", transformation.Input), logging.Var(transformation.Output))
 	updatedSecret, err := tr.ProcessSecret(ctx, defaults, &in, transformation)
 	if err != nil {
-		return err
+		return ErrTransformation{Output: transformation.Output, Type: transformation.Type, Err: err}
 	}
 
 	repository.Put(updatedSecret.Name, updatedSecret)
@@ -79,7 +170,7 @@ func (m *MainUseCaseImpl) WriteToSink(ctx context.Context, factory Factory, defa
 	// get secret to be written from repository.
 	repositoryContent, err := repository.Get(sink.Var)
 	if err != nil {
-		return err
+		return ErrSink{Type: sink.Type, Var: sink.Var, Err: err}
 	}
 
 	var secret *Secret = repositoryContent.(*Secret)
@@ -87,13 +178,14 @@ func (m *MainUseCaseImpl) WriteToSink(ctx context.Context, factory Factory, defa
 	// get sik writer for type.
 	sw := factory.NewSinkWriter(sink.Type)
 	if sw == nil {
-		return errors.New("internal error: unable to handle sink of given type")
+		return ErrSink{Type: sink.Type, Var: sink.Var,
+			Err: errors.New("internal error: unable to handle sink of given type")}
 	}
 
 	// write to sink and be done.
 	err = sw.Write(ctx, defaults, secret, sink)
 	if err != nil {
-		return err
+		return ErrSink{Type: sink.Type, Var: sink.Var, Err: err}
 	}
 
 	return nil
@@ -109,42 +201,308 @@ func (m *MainUseCaseImpl) dataMissing(vaults *Vaults, secrets *Secrets, sinks *S
 func (m *MainUseCaseImpl) Process(ctx context.Context, factory Factory, defaults *Defaults,
 	vaults *Vaults, secrets *Secrets, transformations *Transformations, sinks *Sinks) error {
 
-	if m.dataMissing(vaults, secrets, sinks) {
+	_, err := m.ProcessWithReport(ctx, factory, defaults, vaults, secrets, transformations, sinks)
+	return err
+}
+
+// retrieveWithFallbacks pulls a secret from its vault and, if that fails, from its fallback
+// vaults in order. References to secrets in vault specs are resolved from the repository.
+// It returns the name and type of the vault the secret was retrieved from, or of its own
+// vault if it could not be retrieved. The error matches os.ErrNotExist only if no vault
+// failed for another reason.
+func (m *MainUseCaseImpl) retrieveWithFallbacks(ctx context.Context, factory Factory, defaults *Defaults,
+	repository Repository, vaults *Vaults, secret *Secret) (string, string, error) {
+
+	vaultType := ""
+	var err, errAccess error
+	for idx, vaultName := range append([]string{secret.VaultName}, secret.FallbackVaults...) {
+		// a secret missing in a fallback vault must not hide a vault that failed otherwise
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errAccess = err
+		}
+		if idx > 0 {
+			m.log.Warn("Unable to retrieve secret, trying fallback vault", logging.Secret(secret.Name),
+				logging.Vault(vaultName), logging.Err(err))
+		}
+
+		vault := vaults.GetVaultByName(vaultName)
+		if vault == nil {
+			err = ErrVaultAccess{Vault: vaultName, Secret: secret.Name, Err: errors.New("no such vault")}
+			continue
+		}
+		if idx == 0 {
+			vaultType = vault.Type
+		}
+
+		resolved, errRefs := vault.ResolveSecretRefs(repository)
+		if errRefs != nil {
+			err = ErrVaultAccess{Vault: vaultName, Secret: secret.Name, Err: errRefs}
+			continue
+		}
+		vault = resolved
+
+		if err = m.RetrieveSecret(ctx, factory, defaults, repository, vault, secret); err == nil {
+			return vault.Name, vault.Type, nil
+		}
+	}
+
+	if errAccess != nil && errors.Is(err, os.ErrNotExist) {
+		err = errAccess
+	}
+	return secret.VaultName, vaultType, err
+}
+
+// defaultFor returns the default value of an optional secret, either given literally or
+// taken from another variable. It returns nil if there is no default.
+func (m *MainUseCaseImpl) defaultFor(repository Repository, secret *Secret) *Secret {
+	res := &Secret{
+		Name:      secret.Name,
+		Type:      secret.Type,
+		VaultName: secret.VaultName,
+	}
+
+	switch {
+	case secret.Default != nil:
+		res.RawContent = []byte(*secret.Default)
+	case secret.DefaultFrom != "":
+		v, err := repository.Get(secret.DefaultFrom)
+		if err != nil {
+			m.log.Warn("Default variable of secret is unavailable", logging.Secret(secret.Name),
+				logging.String("defaultFrom", secret.DefaultFrom), logging.Err(err))
+			return nil
+		}
+		from := v.(*Secret)
+		res.RawContent = from.RawContent
+		res.RawContentType = from.RawContentType
+	default:
 		return nil
 	}
 
-	repo := factory.NewRepository()
+	return res
+}
 
-	m.log.Printf("Pulling secrets from vaults")
-	for _, secret := range *secrets {
-		vault := vaults.GetVaultByName(secret.VaultName)
-		if vault == nil {
-			return fmt.Errorf("no such vault: %s", secret.VaultName)
+// removeFromSink removes what has been written to a sink before, if the sink writer supports it.
+func (m *MainUseCaseImpl) removeFromSink(ctx context.Context, factory Factory, defaults *Defaults, sink *Sink) error {
+	sw := factory.NewSinkWriter(sink.Type)
+	sr, ok := sw.(SinkRemoverPort)
+	if !ok {
+		return ErrSink{Type: sink.Type, Var: sink.Var, Err: errors.New("sink does not support removal")}
+	}
+
+	if err := sr.Remove(ctx, defaults, sink); err != nil {
+		return ErrSink{Type: sink.Type, Var: sink.Var, Err: err}
+	}
+	return nil
+}
+
+// beforeStep calls the BeforeStep hook and returns the start time of the step.
+func (m *MainUseCaseImpl) beforeStep(ctx context.Context, step StepResult) time.Time {
+	if m.hooks.BeforeStep != nil {
+		m.hooks.BeforeStep(ctx, step)
+	}
+	return time.Now()
+}
+
+// logStep logs the outcome of a step, with fields depending on its kind.
+func (m *MainUseCaseImpl) logStep(step StepResult, d time.Duration) {
+	fields := []logging.Field{logging.String("kind", string(step.Kind))}
+	switch step.Kind {
+	case StepKindSecret:
+		fields = append(fields, logging.Vault(step.Vault), logging.Secret(step.Name), logging.Type(step.Type))
+	case StepKindTransformation:
+		fields = append(fields, logging.Transformation(step.Type), logging.Var(step.Name))
+	case StepKindSink:
+		fields = append(fields, logging.Sink(step.Type), logging.Var(step.Name))
+	}
+	fields = append(fields, logging.String("status", string(step.Status)), logging.Duration(d))
+
+	switch {
+	case step.Status == StepFailed:
+		m.log.Error("Step failed", append(fields, logging.Err(step.Err))...)
+	case step.Reason != "":
+		m.log.Info("Step "+string(step.Status), append(fields, logging.String("reason", step.Reason))...)
+	default:
+		m.log.Info("Step "+string(step.Status), fields...)
+	}
+}
+
+// ProcessWithReport runs the main use case and reports the outcome of each step. Unless
+// keep-going is enabled, processing stops at the first failed step and all remaining steps
+// are reported as skipped. The returned error is the error of the first failed step.
+func (m *MainUseCaseImpl) ProcessWithReport(ctx context.Context, factory Factory, defaults *Defaults,
+	vaults *Vaults, secrets *Secrets, transformations *Transformations, sinks *Sinks) (*Report, error) {
+
+	report := NewReport()
+	if m.dataMissing(vaults, secrets, sinks) {
+		return report, nil
+	}
+
+	// secrets referenced by vaults have to be retrieved before these vaults are accessed
+	ordered, err := OrderSecrets(vaults, secrets)
+	if err != nil {
+		return report, err
+	}
+
+	repo := m.repository
+	if repo == nil {
+		repo = factory.NewRepository()
+	}
+
+	var firstErr error
+
+	// unavailable maps names of variables that could not be produced to the reason why.
+	unavailable := make(map[string]string)
+
+	// absent contains all variables depending on absent optional secrets. It maps to
+	// true if the variable has been produced from default values.
+	absent := make(map[string]bool)
+
+	// absentInput returns the first input depending on an absent optional secret and
+	// whether all such inputs have been produced from default values.
+	absentInput := func(inputs ...string) (string, bool) {
+		name, defaulted := "", true
+		for _, input := range inputs {
+			if d, ex := absent[input]; ex {
+				if name == "" || !d {
+					name = input
+				}
+				defaulted = defaulted && d
+			}
+		}
+		return name, defaulted
+	}
+
+	// skipReason returns why a step has to be skipped, or an empty string if it can run.
+	skipReason := func(inputs ...string) string {
+		if firstErr != nil && !m.keepGoing {
+			return "aborted after earlier failure"
+		}
+		for _, input := range inputs {
+			if _, ex := unavailable[input]; ex {
+				return fmt.Sprintf("input %s is unavailable", input)
+			}
+		}
+		return ""
+	}
+
+	// record adds the outcome of a step to the report and tracks the availability of its output.
+	// Steps that have not been run have a zero start time.
+	record := func(step StepResult, output string, start time.Time, err error) {
+		switch {
+		case err != nil:
+			step.Status = StepFailed
+			step.Reason = err.Error()
+			step.Err = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		case step.Status != "":
+		case step.Reason != "":
+			step.Status = StepSkipped
+		default:
+			step.Status = StepSucceeded
 		}
-		if err := m.RetrieveSecret(ctx, factory, defaults, repo, vault, secret); err != nil {
-			return err
+		if (step.Status == StepFailed || step.Status == StepSkipped) && output != "" {
+			unavailable[output] = step.Reason
+		}
+		report.Add(step)
+
+		var d time.Duration
+		if !start.IsZero() {
+			d = time.Since(start)
+		}
+		m.logStep(step, d)
+		if m.hooks.AfterStep != nil {
+			m.hooks.AfterStep(ctx, step, d)
+		}
+		produced := step.Kind != StepKindSink && output != "" && step.Status != StepFailed && step.Status != StepSkipped
+		if m.hooks.Produced != nil && produced {
+			if v, err := repo.Get(output); err == nil {
+				m.hooks.Produced(ctx, step, v.(*Secret))
+			}
 		}
 	}
 
+	m.log.Debug("Pulling secrets from vaults")
+	for _, secret := range ordered {
+		// without fallbacks, a secret cannot be retrieved if its vault depends on unavailable secrets
+		var inputs []string
+		if vault := vaults.GetVaultByName(secret.VaultName); vault != nil && len(secret.FallbackVaults) == 0 {
+			inputs = vault.SecretRefs()
+		}
+		step := StepResult{Kind: StepKindSecret, Name: secret.Name, Vault: secret.VaultName, Reason: skipReason(inputs...)}
+		var err error
+		var start time.Time
+		if step.Reason == "" {
+			start = m.beforeStep(ctx, step)
+			step.Vault, step.Type, err = m.retrieveWithFallbacks(ctx, factory, defaults, repo, vaults, secret)
+			if err != nil && secret.Optional && errors.Is(err, os.ErrNotExist) {
+				step.Status = StepAbsent
+				d := m.defaultFor(repo, secret)
+				if d != nil {
+					repo.Put(secret.Name, d)
+					step.Reason = fmt.Sprintf("optional secret is absent, using default: %s", err)
+				} else {
+					step.Reason = fmt.Sprintf("optional secret is absent: %s", err)
+				}
+				absent[secret.Name] = d != nil
+				err = nil
+			}
+		}
+		record(step, secret.Name, start, err)
+	}
+
 	// Applying transformations.
 	if transformations != nil && len(*transformations) > 0 {
-		m.log.Printf("Applying transformations")
+		m.log.Debug("Applying transformations")
 		for _, transformation := range *transformations {
-			if err := m.Transform(ctx, factory, defaults, repo, secrets, transformation); err != nil {
-				return err
+			step := StepResult{Kind: StepKindTransformation, Name: transformation.Output, Type: transformation.Type,
+				Reason: skipReason(transformation.Input...)}
+			var err error
+			output := transformation.Output
+			if step.Reason == "" {
+				if name, defaulted := absentInput(transformation.Input...); name != "" {
+					absent[output] = defaulted
+					if !defaulted {
+						step.Reason = fmt.Sprintf("optional input %s is absent", name)
+						output = ""
+					}
+				}
 			}
+			var start time.Time
+			if step.Reason == "" {
+				start = m.beforeStep(ctx, step)
+				err = m.Transform(ctx, factory, defaults, repo, secrets, transformation)
+			}
+			record(step, output, start, err)
 		}
 	}
 
 	// writing to all sinks.
-	m.log.Printf("Writing secrets to sinks")
+	m.log.Debug("Writing secrets to sinks")
 	if sinks != nil {
 		for _, sink := range *sinks {
-			if err := m.WriteToSink(ctx, factory, defaults, repo, sink); err != nil {
-				return err
+			step := StepResult{Kind: StepKindSink, Name: sink.Var, Type: sink.Type, Reason: skipReason(sink.Var)}
+			var err error
+			var start time.Time
+			if defaulted, ex := absent[sink.Var]; ex && step.Reason == "" {
+				switch {
+				case sink.IfAbsent == SinkIfAbsentRemove:
+					start = m.beforeStep(ctx, step)
+					step.Status = StepSucceeded
+					step.Reason = fmt.Sprintf("removed, optional input %s is absent", sink.Var)
+					err = m.removeFromSink(ctx, factory, defaults, sink)
+				case sink.IfAbsent != SinkIfAbsentDefault || !defaulted:
+					step.Reason = fmt.Sprintf("optional input %s is absent", sink.Var)
+				}
+			}
+			if step.Reason == "" {
+				start = m.beforeStep(ctx, step)
+				err = m.WriteToSink(ctx, factory, defaults, repo, sink)
 			}
+			record(step, "", start, err)
 		}
 	}
 
-	return nil
+	return report, firstErr
 }
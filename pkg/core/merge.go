@@ -0,0 +1,174 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NewConfigFromFiles reads configurations from yaml files, substituting environment variables
+// according to mode, and merges them in order, so that elements of later files replace
+// elements of earlier files, see Merge. Files listed in include are merged before the
+// including file. The positions of all elements refer to the files they were read from.
+func NewConfigFromFiles(fileNames []string, mode EnvSubstMode) (*Config, error) {
+	if len(fileNames) == 0 {
+		return nil, errors.New("no configuration file given")
+	}
+
+	res := NewDefaultConfig()
+	res.positions = make(positions)
+
+	for _, fileName := range fileNames {
+		c, err := newConfigFromFileWithIncludes(fileName, mode, nil)
+		if err != nil {
+			return nil, err
+		}
+		res.Merge(c)
+	}
+
+	return res, nil
+}
+
+// newConfigFromFileWithIncludes reads a configuration file and merges its includes.
+// includedBy contains the absolute paths of all files including this one, to detect cycles.
+func newConfigFromFileWithIncludes(fileName string, mode EnvSubstMode, includedBy []string) (*Config, error) {
+	absFileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range includedBy {
+		if e == absFileName {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(includedBy[i:], absFileName), " -> "))
+		}
+	}
+
+	c, err := newConfigFromFile(fileName, mode)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Include) == 0 {
+		return c, nil
+	}
+
+	res := NewDefaultConfig()
+	res.positions = make(positions)
+	for i, include := range c.Include {
+		includeFileName := include
+		if !filepath.IsAbs(includeFileName) {
+			includeFileName = filepath.Join(filepath.Dir(fileName), include)
+		}
+
+		ic, err := newConfigFromFileWithIncludes(includeFileName, mode, append(includedBy, absFileName))
+		if err != nil {
+			return nil, c.errorAt(fmt.Sprintf("include[%d]", i), fmt.Errorf("unable to include %s: %w", include, err))
+		}
+		res.Merge(ic)
+	}
+	res.Merge(c)
+
+	return res, nil
+}
+
+// Merge overlays another configuration onto c. Vaults and secrets with the same name,
+// transformations with the same output and sinks with the same type and variable replace
+// the existing elements in place, all other elements are appended. Retry defaults of other
+// take precedence over those of c, and profiles of other replace those of c with the same name.
+func (c *Config) Merge(other *Config) {
+	if c.positions == nil {
+		c.positions = make(positions)
+	}
+	for _, section := range []string{"", "defaults", "vaults", "secrets", "transformations", "sinks", "profiles"} {
+		if _, ex := c.positions[section]; !ex {
+			if pos, ex := other.positions[section]; ex {
+				c.positions[section] = pos
+			}
+		}
+	}
+
+	if other.Defaults.Retry != nil {
+		if c.Defaults.Retry == nil {
+			c.Defaults.Retry = &RetryPolicy{}
+		}
+		merged := c.Defaults.Retry.Merge(other.Defaults.Retry)
+		c.Defaults.Retry = &merged
+		for k, pos := range other.positions {
+			if isWithin(k, "defaults.retry") {
+				c.positions[k] = pos
+			}
+		}
+	}
+
+	for j, vault := range other.Vaults {
+		i := len(c.Vaults)
+		for k, e := range c.Vaults {
+			if e.Name == vault.Name {
+				i = k
+			}
+		}
+		if i == len(c.Vaults) {
+			c.Vaults = append(c.Vaults, vault)
+		} else {
+			c.Vaults[i] = vault
+		}
+		c.mergePositions(other, "vaults", i, j)
+	}
+
+	for j, secret := range other.Secrets {
+		i := len(c.Secrets)
+		for k, e := range c.Secrets {
+			if e.Name == secret.Name {
+				i = k
+			}
+		}
+		if i == len(c.Secrets) {
+			c.Secrets = append(c.Secrets, secret)
+		} else {
+			c.Secrets[i] = secret
+		}
+		c.mergePositions(other, "secrets", i, j)
+	}
+
+	for j, transformation := range other.Transformations {
+		i := len(c.Transformations)
+		for k, e := range c.Transformations {
+			if e.Output == transformation.Output {
+				i = k
+			}
+		}
+		if i == len(c.Transformations) {
+			c.Transformations = append(c.Transformations, transformation)
+		} else {
+			c.Transformations[i] = transformation
+		}
+		c.mergePositions(other, "transformations", i, j)
+	}
+
+	for j, sink := range other.Sinks {
+		i := len(c.Sinks)
+		for k, e := range c.Sinks {
+			if e.Type == sink.Type && e.Var == sink.Var {
+				i = k
+			}
+		}
+		if i == len(c.Sinks) {
+			c.Sinks = append(c.Sinks, sink)
+		} else {
+			c.Sinks[i] = sink
+		}
+		c.mergePositions(other, "sinks", i, j)
+	}
+
+	for name, profile := range other.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]*Profile)
+		}
+		c.Profiles[name] = profile
+		c.positions.replaceTree("profiles."+name, other.positions, "profiles."+name)
+	}
+}
+
+// mergePositions takes the positions of element j of a section in other for element i in c
+func (c *Config) mergePositions(other *Config, section string, i, j int) {
+	c.positions.replaceTree(fmt.Sprintf("%s[%d]", section, i), other.positions, fmt.Sprintf("%s[%d]", section, j))
+}
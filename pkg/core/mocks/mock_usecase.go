@@ -49,6 +49,21 @@ func (mr *MockUseCaseMockRecorder) Process(arg0, arg1, arg2, arg3, arg4, arg5, a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Process", reflect.TypeOf((*MockUseCase)(nil).Process), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
 }
 
+// ProcessWithReport mocks base method.
+func (m *MockUseCase) ProcessWithReport(arg0 context.Context, arg1 core.Factory, arg2 *core.Defaults, arg3 *core.Vaults, arg4 *core.Secrets, arg5 *core.Transformations, arg6 *core.Sinks) (*core.Report, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ProcessWithReport", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(*core.Report)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ProcessWithReport indicates an expected call of ProcessWithReport.
+func (mr *MockUseCaseMockRecorder) ProcessWithReport(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessWithReport", reflect.TypeOf((*MockUseCase)(nil).ProcessWithReport), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
 // RetrieveSecret mocks base method.
 func (m *MockUseCase) RetrieveSecret(arg0 context.Context, arg1 core.Factory, arg2 *core.Defaults, arg3 core.Repository, arg4 *core.Vault, arg5 *core.Secret) error {
 	m.ctrl.T.Helper()
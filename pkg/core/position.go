@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Position is a location within a configuration file
+type Position struct {
+	// File is the name of the configuration file, if known
+	File string
+
+	// Line starts at 1, a zero line means the position is unknown
+	Line int
+
+	// Column starts at 1
+	Column int
+}
+
+// IsValid returns true if the position is known
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// ConfigError is a configuration error at a position within a configuration file
+type ConfigError struct {
+	// Pos is the position of the offending element
+	Pos Position
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e ConfigError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ConfigError) Unwrap() error { return e.Err }
+
+// positions maps paths of configuration elements, e.g. "sinks[0].spec.mode", to their
+// position within the yaml source
+type positions map[string]Position
+
+// newPositions parses yaml source and records the positions of all keys and list elements.
+// Sources that cannot be parsed yield an empty index.
+func newPositions(fileName string, in []byte) positions {
+	res := make(positions)
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(in, &doc); err != nil {
+		return res
+	}
+	res.add(fileName, "", &doc)
+
+	return res
+}
+
+func (p positions) add(fileName, path string, n *yamlv3.Node) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			p[path] = Position{File: fileName, Line: c.Line, Column: c.Column}
+			p.add(fileName, path, c)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := joinPath(path, n.Content[i].Value)
+			p[key] = Position{File: fileName, Line: n.Content[i].Line, Column: n.Content[i].Column}
+			p.add(fileName, key, n.Content[i+1])
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			elem := fmt.Sprintf("%s[%d]", path, i)
+			p[elem] = Position{File: fileName, Line: c.Line, Column: c.Column}
+			p.add(fileName, elem, c)
+		}
+	}
+}
+
+// lookup returns the position of given path, or of its closest known parent
+func (p positions) lookup(path string) Position {
+	for {
+		if pos, ex := p[path]; ex {
+			return pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return p[""]
+		}
+		path = path[:i]
+	}
+}
+
+// isWithin returns true if path denotes the element at prefix or one of its children
+func isWithin(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	rest := path[len(prefix):]
+	return rest == "" || rest[0] == '.' || rest[0] == '['
+}
+
+// replaceTree replaces the positions of the element at path and its children by the
+// positions of the element at srcPath in src
+func (p positions) replaceTree(path string, src positions, srcPath string) {
+	for k := range p {
+		if isWithin(k, path) {
+			delete(p, k)
+		}
+	}
+	for k, pos := range src {
+		if isWithin(k, srcPath) {
+			p[path+k[len(srcPath):]] = pos
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Profile overrides parts of a configuration, e.g. for an environment. Elements are
+// matched by the same keys as in Merge. Non-empty fields of a matching element replace
+// those of the configuration, specs are merged key by key. Elements without a match
+// are appended.
+type Profile struct {
+	// Defaults contains settings merged into the defaults of the configuration
+	Defaults Defaults `yaml:"defaults"`
+
+	// Vaults are matched by name
+	Vaults Vaults `yaml:"vaults"`
+
+	// Secrets are matched by name, which is the variable. Key changes the name within the vault.
+	Secrets Secrets `yaml:"secrets"`
+
+	// Transformations are matched by output
+	Transformations Transformations `yaml:"transformations"`
+
+	// Sinks are matched by variable, and by type if given
+	Sinks Sinks `yaml:"sinks"`
+}
+
+// ProfileNames returns the names of all profiles, sorted
+func (c *Config) ProfileNames() []string {
+	res := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// WithProfile returns a copy of the configuration with the named profile applied. The copy
+// does not contain any profiles.
+func (c *Config) WithProfile(name string) (*Config, error) {
+	p, ex := c.Profiles[name]
+	if !ex {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+
+	res := &Config{
+		Include:   c.Include,
+		Defaults:  c.Defaults,
+		positions: make(positions),
+	}
+	for k, pos := range c.positions {
+		if !isWithin(k, "profiles") {
+			res.positions[k] = pos
+		}
+	}
+	for _, vault := range c.Vaults {
+		v := *vault
+		res.Vaults = append(res.Vaults, &v)
+	}
+	for _, secret := range c.Secrets {
+		s := *secret
+		res.Secrets = append(res.Secrets, &s)
+	}
+	for _, transformation := range c.Transformations {
+		t := *transformation
+		res.Transformations = append(res.Transformations, &t)
+	}
+	for _, sink := range c.Sinks {
+		s := *sink
+		res.Sinks = append(res.Sinks, &s)
+	}
+	if p == nil {
+		return res, nil
+	}
+
+	path := "profiles." + name
+	if p.Defaults.Retry != nil {
+		merged := RetryPolicy{}
+		if res.Defaults.Retry != nil {
+			merged = *res.Defaults.Retry
+		}
+		merged = merged.Merge(p.Defaults.Retry)
+		res.Defaults.Retry = &merged
+	}
+
+	for j, vault := range p.Vaults {
+		i := len(res.Vaults)
+		for k, e := range res.Vaults {
+			if e.Name == vault.Name {
+				i = k
+			}
+		}
+		if i == len(res.Vaults) {
+			e := *vault
+			res.Vaults = append(res.Vaults, &e)
+		} else {
+			patchVault(res.Vaults[i], vault)
+		}
+		res.overlayPositions(fmt.Sprintf("vaults[%d]", i), c.positions, fmt.Sprintf("%s.vaults[%d]", path, j))
+	}
+
+	for j, secret := range p.Secrets {
+		i := len(res.Secrets)
+		for k, e := range res.Secrets {
+			if e.Name == secret.Name {
+				i = k
+			}
+		}
+		if i == len(res.Secrets) {
+			e := *secret
+			res.Secrets = append(res.Secrets, &e)
+		} else {
+			patchSecret(res.Secrets[i], secret)
+		}
+		res.overlayPositions(fmt.Sprintf("secrets[%d]", i), c.positions, fmt.Sprintf("%s.secrets[%d]", path, j))
+	}
+
+	for j, transformation := range p.Transformations {
+		i := len(res.Transformations)
+		for k, e := range res.Transformations {
+			if e.Output == transformation.Output {
+				i = k
+			}
+		}
+		if i == len(res.Transformations) {
+			e := *transformation
+			res.Transformations = append(res.Transformations, &e)
+		} else {
+			patchTransformation(res.Transformations[i], transformation)
+		}
+		res.overlayPositions(fmt.Sprintf("transformations[%d]", i), c.positions,
+			fmt.Sprintf("%s.transformations[%d]", path, j))
+	}
+
+	for j, sink := range p.Sinks {
+		i := len(res.Sinks)
+		for k, e := range res.Sinks {
+			if e.Var == sink.Var && (sink.Type == "" || e.Type == sink.Type) {
+				i = k
+			}
+		}
+		if i == len(res.Sinks) {
+			e := *sink
+			res.Sinks = append(res.Sinks, &e)
+		} else {
+			patchSink(res.Sinks[i], sink)
+		}
+		res.overlayPositions(fmt.Sprintf("sinks[%d]", i), c.positions, fmt.Sprintf("%s.sinks[%d]", path, j))
+	}
+
+	return res, nil
+}
+
+// overlayPositions sets the positions of the element at path and its children to those of
+// the profile element at srcPath, keeping the positions of children not in the profile
+func (c *Config) overlayPositions(path string, src positions, srcPath string) {
+	for k, pos := range src {
+		if isWithin(k, srcPath) {
+			c.positions[path+k[len(srcPath):]] = pos
+		}
+	}
+}
+
+func patchVault(v *Vault, p *Vault) {
+	if p.Type != "" {
+		v.Type = p.Type
+	}
+	v.Spec = VaultSpec(patchSpec(v.Spec, p.Spec))
+	if p.Retry != nil {
+		merged := RetryPolicy{}
+		if v.Retry != nil {
+			merged = *v.Retry
+		}
+		merged = merged.Merge(p.Retry)
+		v.Retry = &merged
+	}
+}
+
+func patchSecret(s *Secret, p *Secret) {
+	if p.Key != "" {
+		s.Key = p.Key
+	}
+	if p.VaultName != "" {
+		s.VaultName = p.VaultName
+	}
+	if p.Type != "" {
+		s.Type = p.Type
+	}
+	if p.Optional {
+		s.Optional = true
+	}
+	if p.Default != nil {
+		s.Default, s.DefaultFrom = p.Default, ""
+	}
+	if p.DefaultFrom != "" {
+		s.Default, s.DefaultFrom = nil, p.DefaultFrom
+	}
+	if len(p.FallbackVaults) > 0 {
+		s.FallbackVaults = p.FallbackVaults
+	}
+}
+
+func patchTransformation(t *Transformation, p *Transformation) {
+	if len(p.Input) > 0 {
+		t.Input = p.Input
+	}
+	if p.Type != "" {
+		t.Type = p.Type
+	}
+	t.Spec = TransformationSpec(patchSpec(t.Spec, p.Spec))
+}
+
+func patchSink(s *Sink, p *Sink) {
+	if p.Type != "" {
+		s.Type = p.Type
+	}
+	if p.IfAbsent != "" {
+		s.IfAbsent = p.IfAbsent
+	}
+	s.Spec = SinkSpec(patchSpec(s.Spec, p.Spec))
+}
+
+// patchSpec returns a copy of spec, where all keys of p take precedence
+func patchSpec(spec map[interface{}]interface{}, p map[interface{}]interface{}) map[interface{}]interface{} {
+	if len(p) == 0 {
+		return spec
+	}
+	res := make(map[interface{}]interface{}, len(spec)+len(p))
+	for k, v := range spec {
+		res[k] = v
+	}
+	for k, v := range p {
+		res[k] = v
+	}
+	return res
+}
+
+// validateProfiles validates the configuration with each profile applied. Errors found
+// without profiles are not repeated.
+func (c *Config) validateProfiles(f Factory, errs []error) []error {
+	known := make(map[string]struct{}, len(errs))
+	for _, err := range errs {
+		known[err.Error()] = struct{}{}
+	}
+
+	res := make([]error, 0)
+	for _, name := range c.ProfileNames() {
+		pc, err := c.WithProfile(name)
+		if err != nil {
+			res = append(res, err)
+			continue
+		}
+		for _, err := range pc.validate(f) {
+			if _, ex := known[err.Error()]; ex {
+				continue
+			}
+			var errConfig ConfigError
+			if errors.As(err, &errConfig) {
+				res = append(res, ConfigError{Pos: errConfig.Pos, Err: fmt.Errorf("profile %s: %w", name, errConfig.Err)})
+			} else {
+				res = append(res, fmt.Errorf("profile %s: %w", name, err))
+			}
+		}
+	}
+	return res
+}
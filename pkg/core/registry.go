@@ -0,0 +1,184 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// VaultAccessorConstructor creates a new VaultAccessorPort of a registered type.
+type VaultAccessorConstructor func() VaultAccessorPort
+
+// TransformationConstructor creates a new TransformationPort of a registered type.
+type TransformationConstructor func() TransformationPort
+
+// SinkWriterConstructor creates a new SinkWriterPort of a registered type.
+type SinkWriterConstructor func() SinkWriterPort
+
+// RegistryFactory is a Factory whose types are registered at runtime, e.g. to provide
+// custom vault accessors next to the builtin ones when embedding this module. Types are
+// returned in the order of registration. Registering a type twice fails with ErrTypeCollision.
+type RegistryFactory struct {
+	mu              sync.RWMutex
+	newRepository   func() Repository
+	vaults          typeRegistry
+	transformations typeRegistry
+	sinks           typeRegistry
+}
+
+// typeRegistry maps the types of one kind to their constructors
+type typeRegistry struct {
+	kind         string
+	types        []string
+	constructors map[string]interface{}
+}
+
+// NewRegistryFactory creates a factory without any types, using newRepository to create repositories.
+func NewRegistryFactory(newRepository func() Repository) *RegistryFactory {
+	return &RegistryFactory{
+		newRepository:   newRepository,
+		vaults:          typeRegistry{kind: "vault", constructors: make(map[string]interface{})},
+		transformations: typeRegistry{kind: "transformation", constructors: make(map[string]interface{})},
+		sinks:           typeRegistry{kind: "sink", constructors: make(map[string]interface{})},
+	}
+}
+
+// RegisterVault registers a vault type.
+func (f *RegistryFactory) RegisterVault(vaultType string, constructor VaultAccessorConstructor) error {
+	if constructor == nil {
+		return fmt.Errorf("constructor of vault type %s is nil", vaultType)
+	}
+	return f.register(&f.vaults, vaultType, constructor)
+}
+
+// RegisterTransformation registers a transformation type.
+func (f *RegistryFactory) RegisterTransformation(transformationType string, constructor TransformationConstructor) error {
+	if constructor == nil {
+		return fmt.Errorf("constructor of transformation type %s is nil", transformationType)
+	}
+	return f.register(&f.transformations, transformationType, constructor)
+}
+
+// RegisterSink registers a sink type.
+func (f *RegistryFactory) RegisterSink(sinkType string, constructor SinkWriterConstructor) error {
+	if constructor == nil {
+		return fmt.Errorf("constructor of sink type %s is nil", sinkType)
+	}
+	return f.register(&f.sinks, sinkType, constructor)
+}
+
+// RegisterFactory layers another factory onto this one by registering all of its types,
+// which are then created by it. If any of them is registered already, or provided twice by
+// the other factory, no type is registered and ErrTypeCollision is returned.
+func (f *RegistryFactory) RegisterFactory(other Factory) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range []struct {
+		r     *typeRegistry
+		types []string
+	}{
+		{&f.vaults, other.VaultAccessorTypes()},
+		{&f.transformations, other.TransformationTypes()},
+		{&f.sinks, other.SinkTypes()},
+	} {
+		seen := make(map[string]bool, len(c.types))
+		for _, t := range c.types {
+			if _, ex := c.r.constructors[t]; ex || seen[t] {
+				return ErrTypeCollision{Kind: c.r.kind, Type: t}
+			}
+			seen[t] = true
+		}
+	}
+
+	for _, t := range other.VaultAccessorTypes() {
+		t := t
+		f.vaults.add(t, VaultAccessorConstructor(func() VaultAccessorPort { return other.NewVaultAccessor(t) }))
+	}
+	for _, t := range other.TransformationTypes() {
+		t := t
+		f.transformations.add(t, TransformationConstructor(func() TransformationPort { return other.NewTransformation(t) }))
+	}
+	for _, t := range other.SinkTypes() {
+		t := t
+		f.sinks.add(t, SinkWriterConstructor(func() SinkWriterPort { return other.NewSinkWriter(t) }))
+	}
+	return nil
+}
+
+func (f *RegistryFactory) register(r *typeRegistry, t string, constructor interface{}) error {
+	if t == "" {
+		return fmt.Errorf("unable to register %s type without name", r.kind)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ex := r.constructors[t]; ex {
+		return ErrTypeCollision{Kind: r.kind, Type: t}
+	}
+	r.add(t, constructor)
+	return nil
+}
+
+func (r *typeRegistry) add(t string, constructor interface{}) {
+	r.types = append(r.types, t)
+	r.constructors[t] = constructor
+}
+
+// constructor returns the constructor of a type, or nil
+func (f *RegistryFactory) constructor(r *typeRegistry, t string) interface{} {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return r.constructors[t]
+}
+
+// typeList returns a copy of the registered types of a kind
+func (f *RegistryFactory) typeList(r *typeRegistry) []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]string{}, r.types...)
+}
+
+// NewRepository creates a new repository.
+func (f *RegistryFactory) NewRepository() Repository {
+	return f.newRepository()
+}
+
+// SinkTypes returns the registered sink types.
+func (f *RegistryFactory) SinkTypes() []string {
+	return f.typeList(&f.sinks)
+}
+
+// NewSinkWriter creates a sink writer of a registered type, or nil.
+func (f *RegistryFactory) NewSinkWriter(sinkType string) SinkWriterPort {
+	if c, ok := f.constructor(&f.sinks, sinkType).(SinkWriterConstructor); ok {
+		return c()
+	}
+	return nil
+}
+
+// TransformationTypes returns the registered transformation types.
+func (f *RegistryFactory) TransformationTypes() []string {
+	return f.typeList(&f.transformations)
+}
+
+// NewTransformation creates a transformation of a registered type, or nil.
+func (f *RegistryFactory) NewTransformation(transformationType string) TransformationPort {
+	if c, ok := f.constructor(&f.transformations, transformationType).(TransformationConstructor); ok {
+		return c()
+	}
+	return nil
+}
+
+// VaultAccessorTypes returns the registered vault types.
+func (f *RegistryFactory) VaultAccessorTypes() []string {
+	return f.typeList(&f.vaults)
+}
+
+// NewVaultAccessor creates a vault accessor of a registered type, or nil.
+func (f *RegistryFactory) NewVaultAccessor(vaultType string) VaultAccessorPort {
+	if c, ok := f.constructor(&f.vaults, vaultType).(VaultAccessorConstructor); ok {
+		return c()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// StepKind is the kind of a processing step
+type StepKind string
+
+const (
+	// StepKindSecret is the retrieval of a secret from a vault
+	StepKindSecret StepKind = "secret"
+
+	// StepKindTransformation is the application of a transformation
+	StepKindTransformation StepKind = "transformation"
+
+	// StepKindSink is the write of a variable to a sink
+	StepKindSink StepKind = "sink"
+)
+
+// StepStatus is the outcome of a processing step
+type StepStatus string
+
+const (
+	// StepSucceeded indicates that the step completed without error
+	StepSucceeded StepStatus = "succeeded"
+
+	// StepFailed indicates that the step returned an error
+	StepFailed StepStatus = "failed"
+
+	// StepSkipped indicates that the step was not run, e.g. because one of its inputs failed
+	StepSkipped StepStatus = "skipped"
+
+	// StepAbsent indicates that an optional secret could not be retrieved
+	StepAbsent StepStatus = "absent"
+)
+
+// StepResult describes the outcome of a single processing step.
+type StepResult struct {
+	// Kind of step
+	Kind StepKind `json:"kind"`
+
+	// Name is the name of the secret, the output of the transformation or the variable of the sink
+	Name string `json:"name"`
+
+	// Type is the type of vault, transformation or sink
+	Type string `json:"type"`
+
+	// Vault is the name of the vault a secret was retrieved from, or should have been
+	Vault string `json:"vault,omitempty"`
+
+	// Status of the step
+	Status StepStatus `json:"status"`
+
+	// Reason explains why the step failed or was skipped
+	Reason string `json:"reason,omitempty"`
+
+	// Err is the error of a failed step
+	Err error `json:"-"`
+}
+
+// Report collects the results of all processing steps, in the order they were processed.
+type Report struct {
+	Steps []StepResult `json:"steps"`
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{
+		Steps: make([]StepResult, 0),
+	}
+}
+
+// Add appends a step result to the report
+func (r *Report) Add(step StepResult) {
+	r.Steps = append(r.Steps, step)
+}
+
+// Count returns the number of steps with given status
+func (r *Report) Count(status StepStatus) int {
+	n := 0
+	for _, step := range r.Steps {
+		if step.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns true if at least one step failed
+func (r *Report) Failed() bool {
+	return r.Count(StepFailed) > 0
+}
+
+// WriteTable writes the report as a human-readable table
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tTYPE\tSTATUS\tREASON")
+	for _, step := range r.Steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", step.Kind, step.Name, step.Type, step.Status, step.Reason)
+	}
+	fmt.Fprintf(tw, "\n%d succeeded, %d failed, %d skipped, %d absent\n",
+		r.Count(StepSucceeded), r.Count(StepFailed), r.Count(StepSkipped), r.Count(StepAbsent))
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as json
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
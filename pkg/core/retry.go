@@ -0,0 +1,286 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the number of attempts if no retry policy is configured, i.e. no retries.
+	DefaultRetryMaxAttempts = 1
+
+	// DefaultRetryInitialBackoff is the delay before the first retry.
+	DefaultRetryInitialBackoff = 500 * time.Millisecond
+
+	// DefaultRetryMaxBackoff caps the delay between two attempts.
+	DefaultRetryMaxBackoff = 30 * time.Second
+
+	// DefaultRetryMultiplier is the factor by which the delay grows after each attempt.
+	DefaultRetryMultiplier = 2.0
+
+	// DefaultRetryJitter is the fraction of the delay that is randomized.
+	DefaultRetryJitter = 0.2
+)
+
+// Duration is a time.Duration that can be read from yaml strings such as "500ms" or "2s".
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string or a plain number of seconds.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %s", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// String returns the duration in time.Duration notation.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// RetryPolicy describes how failed calls to a vault are retried. Fields that are not given
+// are inherited from the policy in Defaults, or from the package defaults. Fields given as
+// zero override inherited values, except for MaxAttempts and Multiplier.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int `yaml:"maxAttempts" validate:"gte=0"`
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff Duration `yaml:"initialBackoff" schema:"string|number" validate:"gte=0"`
+
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff Duration `yaml:"maxBackoff" schema:"string|number" validate:"gte=0"`
+
+	// Multiplier is the factor by which the delay grows after each attempt.
+	Multiplier float64 `yaml:"multiplier" validate:"omitempty,gte=1"`
+
+	// Jitter is the fraction (0..1) of each delay that is randomized.
+	Jitter float64 `yaml:"jitter" validate:"gte=0,lte=1"`
+
+	// Timeout limits a single attempt. Zero means no limit.
+	Timeout Duration `yaml:"timeout" schema:"string|number" validate:"gte=0"`
+
+	// set contains the yaml keys of the fields that were given, so that zero values
+	// can be told apart from missing ones
+	set map[string]bool
+}
+
+// UnmarshalYAML decodes the policy and records which fields were given.
+func (p *RetryPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RetryPolicy
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+
+	var fields map[string]interface{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	p.set = make(map[string]bool, len(fields))
+	for k := range fields {
+		p.set[k] = true
+	}
+	return nil
+}
+
+// NewDefaultRetryPolicy returns a policy with the package defaults, which does not retry.
+func NewDefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		InitialBackoff: Duration(DefaultRetryInitialBackoff),
+		MaxBackoff:     Duration(DefaultRetryMaxBackoff),
+		Multiplier:     DefaultRetryMultiplier,
+		Jitter:         DefaultRetryJitter,
+	}
+}
+
+// Merge returns a copy of p where all fields of other take precedence that are non-zero or
+// were given explicitly, see RetryPolicy.
+func (p RetryPolicy) Merge(other *RetryPolicy) RetryPolicy {
+	if other == nil {
+		return p
+	}
+	if other.MaxAttempts > 0 {
+		p.MaxAttempts = other.MaxAttempts
+	}
+	if other.InitialBackoff > 0 || other.set["initialBackoff"] {
+		p.InitialBackoff = other.InitialBackoff
+	}
+	if other.MaxBackoff > 0 || other.set["maxBackoff"] {
+		p.MaxBackoff = other.MaxBackoff
+	}
+	if other.Multiplier > 0 {
+		p.Multiplier = other.Multiplier
+	}
+	if other.Jitter > 0 || other.set["jitter"] {
+		p.Jitter = other.Jitter
+	}
+	if other.Timeout > 0 || other.set["timeout"] {
+		p.Timeout = other.Timeout
+	}
+
+	// the merged policy is merged again, e.g. profiles into defaults
+	set := make(map[string]bool, len(p.set)+len(other.set))
+	for _, m := range []map[string]bool{p.set, other.set} {
+		for k := range m {
+			set[k] = true
+		}
+	}
+	p.set = set
+	return p
+}
+
+// EffectiveRetryPolicy combines package defaults, the global policy from defaults
+// and the policy of the vault, in this order.
+func EffectiveRetryPolicy(defaults *Defaults, vault *Vault) RetryPolicy {
+	res := NewDefaultRetryPolicy()
+	if defaults != nil {
+		res = res.Merge(defaults.Retry)
+	}
+	if vault != nil {
+		res = res.Merge(vault.Retry)
+	}
+	return res
+}
+
+// Backoff returns the delay before the given retry (starting at 1), without jitter.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	return time.Duration(d)
+}
+
+// RetryClassifier is optionally implemented by a VaultAccessorPort to tell
+// transient errors (e.g. throttling) apart from fatal ones. Errors of vault
+// accessors not implementing it are never retried, except for attempt timeouts
+// that are not an ErrFatal.
+type RetryClassifier interface {
+	// IsRetryable returns true if the call that produced err may succeed when repeated.
+	IsRetryable(err error) bool
+}
+
+// RetryingVaultAccessor is a VaultAccessorPort that retries calls of the wrapped
+// accessor with exponential backoff and jitter, according to a RetryPolicy.
+type RetryingVaultAccessor struct {
+	log    *logging.Logger
+	va     VaultAccessorPort
+	policy RetryPolicy
+	rand   *rand.Rand
+}
+
+// NewRetryingVaultAccessor wraps va with the given policy.
+func NewRetryingVaultAccessor(l *logging.Logger, va VaultAccessorPort, policy RetryPolicy) *RetryingVaultAccessor {
+	return &RetryingVaultAccessor{
+		log:    l,
+		va:     va,
+		policy: policy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// IsRetryable checks if the wrapped accessor classifies err as transient.
+func (r *RetryingVaultAccessor) IsRetryable(err error) bool {
+	if c, ok := r.va.(RetryClassifier); ok {
+		return c.IsRetryable(err)
+	}
+	return false
+}
+
+// RetrieveSecret calls the wrapped accessor until it succeeds, returns a fatal
+// error, the context is done or the maximum number of attempts is reached.
+func (r *RetryingVaultAccessor) RetrieveSecret(ctx context.Context, defaults *Defaults,
+	vault *Vault, secret *Secret) (*Secret, error) {
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var res *Secret
+		var timedOut bool
+		res, timedOut, err = r.attempt(ctx, defaults, vault, secret)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt >= r.policy.MaxAttempts {
+			break
+		}
+		var errFatal ErrFatal
+		if ctx.Err() != nil || !(timedOut || r.IsRetryable(err)) || errors.As(err, &errFatal) {
+			return nil, err
+		}
+
+		delay := r.delay(attempt)
+		r.log.Warn("Retrying secret", logging.Secret(secret.Name), logging.Vault(vault.Name),
+			logging.String("delay", delay.String()), logging.Int("attempt", int64(attempt+1)),
+			logging.Int("maxAttempts", int64(r.policy.MaxAttempts)), logging.Err(err))
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+
+	if r.policy.MaxAttempts > 1 {
+		return nil, fmt.Errorf("giving up on secret %s from vault %s after %d attempts: %w",
+			secret.Name, vault.Name, r.policy.MaxAttempts, err)
+	}
+	return nil, err
+}
+
+// attempt performs a single call, limited by the timeout of the policy. It reports
+// whether the call failed because the attempt timed out.
+func (r *RetryingVaultAccessor) attempt(ctx context.Context, defaults *Defaults,
+	vault *Vault, secret *Secret) (*Secret, bool, error) {
+
+	if r.policy.Timeout <= 0 {
+		res, err := r.va.RetrieveSecret(ctx, defaults, vault, secret)
+		return res, false, err
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(r.policy.Timeout))
+	defer cancel()
+
+	res, err := r.va.RetrieveSecret(attemptCtx, defaults, vault, secret)
+	if err != nil && ctx.Err() == nil &&
+		(errors.Is(err, context.DeadlineExceeded) || errors.Is(attemptCtx.Err(), context.DeadlineExceeded)) {
+		return nil, true, fmt.Errorf("attempt timed out after %s: %w", r.policy.Timeout, err)
+	}
+	return res, false, err
+}
+
+// delay returns the backoff before the given retry, randomized by the jitter fraction.
+func (r *RetryingVaultAccessor) delay(retry int) time.Duration {
+	d := r.policy.Backoff(retry)
+	if r.policy.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	j := float64(d) * r.policy.Jitter
+	return time.Duration(float64(d) - j + 2*j*r.rand.Float64())
+}
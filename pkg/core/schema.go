@@ -0,0 +1,389 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the JSON schema draft used for generated schemas
+const SchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a subset of JSON schema, sufficient to describe configuration files and specs.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+}
+
+// SpecSchemaProvider is optionally implemented by vault accessors, transformations and
+// sink writers to declare the schema of their spec.
+type SpecSchemaProvider interface {
+	// SpecSchema returns the schema of the spec
+	SpecSchema() *Schema
+}
+
+// SchemaError describes a single violation of a schema
+type SchemaError struct {
+	// Path to the offending element, e.g. "spec.mode"
+	Path string
+
+	// Message describes the violation
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// SchemaOf creates a schema from a struct by reflection. Only fields with a yaml tag
+// are considered. validate tags are mapped to required properties, enums and bounds. The
+// types of a field can be overridden by a schema tag, e.g. `schema:"integer|string"`.
+func SchemaOf(v interface{}) *Schema {
+	return schemaOfType(reflect.TypeOf(v))
+}
+
+func schemaOfType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOfType(t.Elem())}
+	case reflect.Struct:
+		res := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: new(bool)}
+		addStructFields(res, t)
+		return res
+	}
+	return &Schema{Type: "object"}
+}
+
+func addStructFields(res *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("yaml")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		tagParts := strings.Split(tag, ",")
+		if len(tagParts) > 1 && tagParts[1] == "inline" {
+			addStructFields(res, field.Type)
+			continue
+		}
+
+		name := tagParts[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		prop := schemaOfType(field.Type)
+		if types, ok := field.Tag.Lookup("schema"); ok {
+			prop.Type = strings.Split(types, "|")
+		}
+		if applyValidateTag(prop, field.Tag.Get("validate")) {
+			res.Required = append(res.Required, name)
+		}
+		res.Properties[name] = prop
+	}
+}
+
+// applyValidateTag maps validator rules to the schema and reports whether the field is required
+func applyValidateTag(s *Schema, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+		switch kv[0] {
+		case "dive":
+			return required
+		case "required":
+			required = true
+		case "oneof":
+			for _, e := range strings.Fields(kv[1]) {
+				s.Enum = append(s.Enum, e)
+			}
+		case "gte":
+			if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				s.Minimum = &f
+			}
+		case "lte":
+			if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				s.Maximum = &f
+			}
+		}
+	}
+	return required
+}
+
+// NewConfigSchema returns the schema of a configuration file, including the specs of all
+// vault, transformation and sink types of the given factory.
+func NewConfigSchema(f Factory) *Schema {
+	res := SchemaOf(Config{})
+	res.Schema = SchemaVersion
+	res.Title = "go-secretshelper configuration"
+
+	addSpecSchemas(res.Properties["vaults"].Items, f.VaultAccessorTypes(), func(t string) interface{} {
+		return f.NewVaultAccessor(t)
+	})
+	addSpecSchemas(res.Properties["transformations"].Items, f.TransformationTypes(), func(t string) interface{} {
+		return f.NewTransformation(t)
+	})
+	addSpecSchemas(res.Properties["sinks"].Items, f.SinkTypes(), func(t string) interface{} {
+		return f.NewSinkWriter(t)
+	})
+
+	res.Properties["secrets"].Items.Properties["type"].Enum = stringsToInterfaces(ValidSecretTypes())
+
+	return res
+}
+
+// addSpecSchemas restricts the type of an element to the given types and adds a
+// conditional spec schema for each type that declares one.
+func addSpecSchemas(s *Schema, types []string, newPort func(string) interface{}) {
+	s.Properties["type"].Enum = stringsToInterfaces(types)
+
+	for _, t := range types {
+		p, ok := newPort(t).(SpecSchemaProvider)
+		if !ok {
+			continue
+		}
+		then := &Schema{Properties: map[string]*Schema{"spec": p.SpecSchema()}}
+		if len(p.SpecSchema().Required) > 0 {
+			then.Required = []string{"spec"}
+		}
+		s.AllOf = append(s.AllOf, &Schema{
+			If: &Schema{
+				Properties: map[string]*Schema{"type": {Const: t}},
+				Required:   []string{"type"},
+			},
+			Then: then,
+		})
+	}
+}
+
+func stringsToInterfaces(in []string) []interface{} {
+	res := make([]interface{}, len(in))
+	for i, s := range in {
+		res[i] = s
+	}
+	return res
+}
+
+// Validate checks a value, as decoded from yaml, against the schema and returns all violations.
+func (s *Schema) Validate(v interface{}) []SchemaError {
+	return s.validate("", v)
+}
+
+func (s *Schema) validate(path string, v interface{}) []SchemaError {
+	res := make([]SchemaError, 0)
+
+	if s.Type != nil && !matchesType(s.Type, v) {
+		return append(res, SchemaError{Path: path, Message: fmt.Sprintf("must be of type %s", typeString(s.Type))})
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		res = append(res, SchemaError{Path: path, Message: fmt.Sprintf("must be %v", s.Const)})
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+			}
+		}
+		if !found {
+			res = append(res, SchemaError{Path: path, Message: fmt.Sprintf("must be one of %v", s.Enum)})
+		}
+	}
+
+	if f, ok := toFloat(v); ok {
+		if s.Minimum != nil && f < *s.Minimum {
+			res = append(res, SchemaError{Path: path, Message: fmt.Sprintf("must be >= %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			res = append(res, SchemaError{Path: path, Message: fmt.Sprintf("must be <= %v", *s.Maximum)})
+		}
+	}
+
+	if m, ok := toMap(v); ok {
+		res = append(res, s.validateObject(path, m)...)
+	}
+
+	if a, ok := v.([]interface{}); ok && s.Items != nil {
+		for i, e := range a {
+			res = append(res, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e)...)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		res = append(res, sub.validate(path, v)...)
+	}
+
+	if s.If != nil && s.Then != nil && len(s.If.validate(path, v)) == 0 {
+		res = append(res, s.Then.validate(path, v)...)
+	}
+
+	return res
+}
+
+func (s *Schema) validateObject(path string, m map[string]interface{}) []SchemaError {
+	res := make([]SchemaError, 0)
+
+	for _, r := range s.Required {
+		if _, ex := m[r]; !ex {
+			res = append(res, SchemaError{Path: joinPath(path, r), Message: "is required"})
+		}
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		prop, ex := s.Properties[k]
+		if !ex {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				msg := "unknown property"
+				if hint := closestMatch(k, s.propertyNames()); hint != "" {
+					msg = fmt.Sprintf("unknown property, did you mean %q?", hint)
+				}
+				res = append(res, SchemaError{Path: joinPath(path, k), Message: msg})
+			}
+			continue
+		}
+		if m[k] == nil {
+			// yaml keys without a value are treated like missing keys
+			continue
+		}
+		res = append(res, prop.validate(joinPath(path, k), m[k])...)
+	}
+
+	return res
+}
+
+func (s *Schema) propertyNames() []string {
+	res := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func matchesType(t interface{}, v interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchesSingleType(tt, v)
+	case []string:
+		for _, e := range tt {
+			if matchesSingleType(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(t string, v interface{}) bool {
+	switch t {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "integer":
+		f, ok := toFloat(v)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "object":
+		_, ok := toMap(v)
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func typeString(t interface{}) string {
+	if tt, ok := t.([]string); ok {
+		return strings.Join(tt, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// toMap converts the generic maps produced by yaml and json decoders to a map with string keys.
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, e := range m {
+			res[fmt.Sprint(k)] = e
+		}
+		return res, true
+	case VaultSpec:
+		return toMap(map[interface{}]interface{}(m))
+	case TransformationSpec:
+		return toMap(map[interface{}]interface{}(m))
+	case SinkSpec:
+		return toMap(map[interface{}]interface{}(m))
+	}
+	return nil, false
+}
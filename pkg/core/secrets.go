@@ -7,15 +7,31 @@ type Secrets []*Secret
 
 // Secret defines a named secrets, referenced in a named Vault.
 type Secret struct {
-	// Name of the secret within the vault.
+	// Name of the secret, which is the variable it is stored as, and its key within the vault
+	// unless Key is given.
 	Name string `yaml:"name" validate:"required"`
 
+	// Key of the secret within the vault, if it differs from Name.
+	Key string `yaml:"key"`
+
 	// VaultName specifies in which vault the secret is stored.
 	VaultName string `yaml:"vault" validate:"required"`
 
 	// Type of secret
 	Type string `yaml:"type" validate:"required,valid-secret-type"`
 
+	// Optional secrets may be absent from all vaults without failing the run.
+	Optional bool `yaml:"optional"`
+
+	// Default is the value of an optional secret if it is absent.
+	Default *string `yaml:"default" validate:"excluded_with=DefaultFrom"`
+
+	// DefaultFrom names a previously defined secret whose value is used if an optional secret is absent.
+	DefaultFrom string `yaml:"defaultFrom"`
+
+	// FallbackVaults are tried in order if the secret cannot be retrieved from VaultName.
+	FallbackVaults []string `yaml:"fallbackVaults" validate:"dive,required"`
+
 	// RawContent contains the secret.
 	RawContent []byte
 
@@ -30,6 +46,11 @@ func ValidSecretTypes() []string {
 	}
 }
 
+// HasDefault returns true if the secret defines a value to use when it is absent.
+func (s Secret) HasDefault() bool {
+	return s.Default != nil || s.DefaultFrom != ""
+}
+
 // String returns a string representation of a secret.
 func (s Secret) String() string {
 	set := false
@@ -42,3 +63,9 @@ func (s Secret) String() string {
 		set,
 		s.RawContentType)
 }
+
+// GoString returns the string representation of a secret for %#v, so that its
+// content is not formatted either.
+func (s Secret) GoString() string {
+	return s.String()
+}
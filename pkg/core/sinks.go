@@ -18,8 +18,22 @@ type Sink struct {
 
 	// Spec optionally defines properties of the sink.
 	Spec SinkSpec `yaml:"spec" validate:""`
+
+	// IfAbsent defines what happens if Var depends on an absent optional secret.
+	IfAbsent string `yaml:"ifAbsent" validate:"omitempty,oneof=skip default remove"`
 }
 
+const (
+	// SinkIfAbsentSkip leaves the sink untouched if its variable is absent.
+	SinkIfAbsentSkip = "skip"
+
+	// SinkIfAbsentDefault writes the variable as produced from the defaults of absent secrets.
+	SinkIfAbsentDefault = "default"
+
+	// SinkIfAbsentRemove removes what has been written to the sink before, e.g. a stale file.
+	SinkIfAbsentRemove = "remove"
+)
+
 // SinkSpec contains details about where and how it should be written.
 type SinkSpec map[interface{}]interface{}
 
@@ -34,3 +48,11 @@ type SinkWriterPort interface {
 	// Write takes the raw content of given secret and writes it to the sink using the defaults.
 	Write(context.Context, *Defaults, *Secret, *Sink) error
 }
+
+// SinkRemoverPort is optionally implemented by a SinkWriterPort that is able to remove
+// what it has written before.
+type SinkRemoverPort interface {
+
+	// Remove removes the content of the sink.
+	Remove(context.Context, *Defaults, *Sink) error
+}
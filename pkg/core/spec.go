@@ -0,0 +1,279 @@
+package core
+
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SpecValidator is optionally implemented by vault accessors, transformations and sink
+// writers to check a spec beyond its schema, e.g. by parsing a template.
+type SpecValidator interface {
+	// ValidateSpec returns an error if the spec cannot be used
+	ValidateSpec(map[interface{}]interface{}) error
+}
+
+// SpecError describes an invalid field of a spec
+type SpecError struct {
+	// Field is the path to the offending field within the spec, e.g. "mode"
+	Field string
+
+	// Message describes the problem
+	Message string
+}
+
+func (e SpecError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// SpecErrors is a list of problems found while decoding a spec
+type SpecErrors []SpecError
+
+func (e SpecErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// DecodeSpec decodes a generic spec map into out, which must be a pointer to a struct with
+// yaml tags. Unknown keys are rejected with a hint to the closest known key, values are
+// converted to the field types and the result is checked by the validator. Decoding
+// continues after errors, so that all problems are reported as SpecErrors.
+func DecodeSpec(in map[interface{}]interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("internal error: unable to decode spec into %T", out)
+	}
+
+	fields := specFields(rv.Elem())
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make([]string, 0, len(in))
+	values := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		key := fmt.Sprint(k)
+		keys = append(keys, key)
+		values[key] = v
+	}
+	sort.Strings(keys)
+
+	errs := make(SpecErrors, 0)
+	for _, key := range keys {
+		field, ex := fields[key]
+		if !ex {
+			msg := "unknown field"
+			if hint := closestMatch(key, names); hint != "" {
+				msg = fmt.Sprintf("unknown field, did you mean %q?", hint)
+			}
+			errs = append(errs, SpecError{Field: key, Message: msg})
+			continue
+		}
+		if values[key] == nil {
+			continue
+		}
+		if err := assignSpecValue(field, values[key]); err != nil {
+			errs = append(errs, SpecError{Field: key, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return strings.Split(f.Tag.Get("yaml"), ",")[0]
+	})
+	if err := v.Struct(out); err != nil {
+		verrs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		for _, verr := range verrs {
+			// like in the schema, required means that the key is present
+			if _, ex := values[verr.Field()]; ex && verr.Tag() == "required" {
+				continue
+			}
+			errs = append(errs, SpecError{Field: verr.Field(), Message: validationMessage(verr)})
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	return nil
+}
+
+// specFields maps the yaml names of all settable fields of a struct to the fields
+func specFields(v reflect.Value) map[string]reflect.Value {
+	res := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("yaml")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		tagParts := strings.Split(tag, ",")
+		if len(tagParts) > 1 && tagParts[1] == "inline" {
+			for k, f := range specFields(v.Field(i)) {
+				res[k] = f
+			}
+			continue
+		}
+		name := tagParts[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		res[name] = v.Field(i)
+	}
+	return res
+}
+
+// assignSpecValue converts a value as decoded from yaml to the type of the field and sets it.
+// Numbers given as strings are accepted for numeric fields.
+func assignSpecValue(field reflect.Value, value interface{}) error {
+	target := reflect.New(field.Type())
+
+	// yaml would silently turn numbers and booleans into strings
+	if _, isString := value.(string); !isString && indirectKind(field.Type()) == reflect.String {
+		return fmt.Errorf("must be %s", typeDescription(field.Type()))
+	}
+
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, target.Interface()); err != nil {
+		s, isString := value.(string)
+		if !isString || !setNumberFromString(target.Elem(), s) {
+			return fmt.Errorf("must be %s", typeDescription(field.Type()))
+		}
+	}
+
+	field.Set(target.Elem())
+	return nil
+}
+
+func setNumberFromString(v reflect.Value, s string) bool {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetFloat(n)
+	default:
+		return false
+	}
+	return true
+}
+
+func indirectKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+func typeDescription(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "a map"
+	}
+	return t.String()
+}
+
+func validationMessage(verr validator.FieldError) string {
+	switch verr.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", verr.Param())
+	case "gte", "min":
+		return fmt.Sprintf("must be at least %s", verr.Param())
+	case "lte", "max":
+		return fmt.Sprintf("must be at most %s", verr.Param())
+	}
+	return fmt.Sprintf("failed on %s validation", verr.Tag())
+}
+
+// closestMatch returns the candidate with the smallest edit distance to s, if it is close enough
+// to be a likely typo.
+func closestMatch(s string, candidates []string) string {
+	best, bestDist := "", len(s)/2+2
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(s), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang/mock/gomock"
-	"go-secretshelper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
 	"os"
 	"strings"
 	"testing"
@@ -95,6 +95,38 @@ sinks:
 	}
 }
 
+func TestConfigSpecOctals(t *testing.T) {
+	t.Setenv("MODE", "0600")
+	cfg, err := core.NewConfigWithEnvSubst(strings.NewReader(`
+defaults:
+  retry:
+    maxAttempts: 010
+sinks:
+  - type: mock
+    var: test
+    spec: {path: ./test.txt, mode: 0640, user: 1000}
+  - type: mock
+    var: test
+    spec:
+      mode: ${MODE}
+      dirs: [0750]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// leading zeros are kept within specs only
+	if cfg.Sinks[0].Spec["mode"] != "0640" || cfg.Sinks[0].Spec["user"] != 1000 || cfg.Sinks[0].Spec["path"] != "./test.txt" {
+		t.Errorf("Expected digits of mode as written, got %v", cfg.Sinks[0].Spec)
+	}
+	if cfg.Sinks[1].Spec["mode"] != "0600" || fmt.Sprint(cfg.Sinks[1].Spec["dirs"]) != "[0750]" {
+		t.Errorf("Expected digits of substituted mode as written, got %v", cfg.Sinks[1].Spec)
+	}
+	if cfg.Defaults.Retry.MaxAttempts != 8 {
+		t.Errorf("Expected octal number outside of specs, got %d", cfg.Defaults.Retry.MaxAttempts)
+	}
+}
+
 func DumpValidationErrors(err error) {
 	if err != nil {
 
@@ -325,3 +357,93 @@ sinks:
 	}
 
 }
+
+func TestValidationForOptionalSecrets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	for _, tc := range []struct {
+		name  string
+		extra string
+		sink  string
+		valid bool
+	}{
+		{"no ifAbsent", "", "", false},
+		{"skip", "", "skip", true},
+		{"default without default", "", "default", false},
+		{"default", "default: foo", "default", true},
+		{"defaultFrom", "defaultFrom: test", "default", true},
+		{"defaultFrom later secret", "defaultFrom: opt", "default", false},
+		{"default and defaultFrom", "default: foo\n    defaultFrom: test", "default", false},
+		{"remove unsupported by mock", "", "remove", false},
+		{"unknown fallback vault", "fallbackVaults: [ nonex ]", "skip", false},
+		{"fallback vault", "fallbackVaults: [ kv1 ]", "skip", true},
+	} {
+		cfg, err := core.NewConfig(strings.NewReader(fmt.Sprintf(`
+vaults:
+  - name: kv1
+    type: mock
+
+secrets:
+  - type: secret
+    vault: kv1
+    name: test
+  - type: secret
+    vault: kv1
+    name: opt
+    optional: true
+    %s
+
+transformations:
+  - in:
+    - test
+    - opt
+    out: testout
+    type: mock
+
+sinks:
+  - type: mock
+    var: testout
+    ifAbsent: %s
+`, tc.extra, tc.sink)))
+		if err != nil {
+			t.Errorf("%s: Expected nil got err=%#v", tc.name, err)
+			continue
+		}
+
+		err = cfg.Validate(mf)
+		if tc.valid && err != nil {
+			t.Errorf("%s: Expected nil got err=%s", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Expected validation error, got nil", tc.name)
+		}
+	}
+
+	// a default requires the secret to be optional
+	cfg, err := core.NewConfig(strings.NewReader(`
+vaults:
+  - name: kv1
+    type: mock
+
+secrets:
+  - type: secret
+    vault: kv1
+    name: test
+    default: foo
+
+sinks:
+  - type: mock
+    var: test
+`))
+	if err != nil {
+		t.Errorf("Expected nil got err=%#v", err)
+	}
+
+	err = cfg.Validate(mf)
+	if err == nil {
+		t.Errorf("Expected validation error, got nil")
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"errors"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSubstituteEnv(t *testing.T) {
+	env := map[string]string{
+		"A":     "a",
+		"EMPTY": "",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ex := env[name]
+		return v, ex
+	}
+
+	for _, tc := range []struct {
+		in, exp string
+		problem string
+	}{
+		{"${A}", "a", ""},
+		{"x-${A}-${A}", "x-a-a", ""},
+		{"${UNDEF}", "", "environment variable UNDEF is not defined"},
+		{"${UNDEF:-def}", "def", ""},
+		{"${EMPTY:-def}", "def", ""},
+		{"${EMPTY-def}", "", ""},
+		{"${UNDEF=def}", "def", ""},
+		{"${A:?must be set}", "a", ""},
+		{"${UNDEF:?must be set}", "", "environment variable UNDEF: must be set"},
+		{"${EMPTY:?}", "", "environment variable EMPTY: is empty"},
+		{"$${A}", "${A}", ""},
+		{"p4$$word$", "p4$$word$", ""},
+		{"${A", "${A", ""},
+		{"${1A}", "", "invalid variable reference ${1A}"},
+		{"${A:abc}", "", "invalid variable reference ${A:abc}, expected one of the operators :- := :? - = ?"},
+		{"${A:}", "", "invalid variable reference ${A:}, expected one of the operators :- := :? - = ?"},
+		{"${A:--x}", "a", ""},
+		{"${UNDEF:=a:b}", "a:b", ""},
+		{"Bearer ${{ secrets.token }}-${A}", "Bearer ${{ secrets.token }}-a", ""},
+	} {
+		res, problems := core.SubstituteEnv(tc.in, lookup, true)
+		if res != tc.exp {
+			t.Errorf("Expected %q for %q, got %q", tc.exp, tc.in, res)
+		}
+		if tc.problem == "" && len(problems) > 0 {
+			t.Errorf("Unexpected problems for %q: %v", tc.in, problems)
+		}
+		if tc.problem != "" && (len(problems) != 1 || problems[0].Message != tc.problem) {
+			t.Errorf("Expected problem %q for %q, got %v", tc.problem, tc.in, problems)
+		}
+	}
+
+	// lenient substitution of undefined variables
+	if res, problems := core.SubstituteEnv("${UNDEF}", lookup, false); res != "" || len(problems) > 0 {
+		t.Errorf("Expected empty result without problems, got %q, %v", res, problems)
+	}
+
+	// unknown operators are no undefined variables, they fail in lenient mode as well
+	if _, problems := core.SubstituteEnv("${A:abc}", lookup, false); len(problems) != 1 || problems[0].Var != "A" {
+		t.Errorf("Expected problem for unknown operator, got %v", problems)
+	}
+	_, err := core.NewConfigWithEnvSubst(strings.NewReader("sinks:\n  - type: mock\n    var: ${A:abc}\n"))
+	var errs core.EnvSubstErrors
+	if !errors.As(err, &errs) || err.Error() != "3:10: invalid variable reference ${A:abc}, expected one of the operators :- := :? - = ?" {
+		t.Errorf("Expected EnvSubstErrors for unknown operator, got %v", err)
+	}
+}
+
+func TestStrictEnvSubst(t *testing.T) {
+	inp := `vaults:
+  - name: ${TEST_VAULT_NAME}
+    type: mock
+    spec:
+      url: "$${NOT_SUBSTITUTED}"
+      description: ${TEST_MULTILINE}
+
+secrets:
+  - type: secret
+    vault: ${TEST_UNDEFINED_VAULT}
+    name: test
+    optional: ${TEST_OPTIONAL}
+
+sinks:
+  - type: mock
+    var: ${TEST_UNDEFINED_VAR:?name of the variable}
+`
+
+	os.Setenv("TEST_VAULT_NAME", "kv1")
+	os.Setenv("TEST_MULTILINE", "key: value\n- item")
+	os.Setenv("TEST_OPTIONAL", "true")
+	defer os.Unsetenv("TEST_VAULT_NAME")
+	defer os.Unsetenv("TEST_MULTILINE")
+	defer os.Unsetenv("TEST_OPTIONAL")
+
+	_, err := core.NewConfigWithStrictEnvSubst(strings.NewReader(inp))
+	var errs core.EnvSubstErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected EnvSubstErrors, got %v", err)
+	}
+	if err.Error() != "10:12: environment variable TEST_UNDEFINED_VAULT is not defined; "+
+		"16:10: environment variable TEST_UNDEFINED_VAR: name of the variable" {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	os.Setenv("TEST_UNDEFINED_VAULT", "kv1")
+	os.Setenv("TEST_UNDEFINED_VAR", "test")
+	defer os.Unsetenv("TEST_UNDEFINED_VAULT")
+	defer os.Unsetenv("TEST_UNDEFINED_VAR")
+
+	cfg, err := core.NewConfigWithStrictEnvSubst(strings.NewReader(inp))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	if cfg.Vaults[0].Name != "kv1" || cfg.Secrets[0].VaultName != "kv1" || cfg.Sinks[0].Var != "test" {
+		t.Errorf("Expected substituted names, got %v, %v, %v", cfg.Vaults[0], cfg.Secrets[0], cfg.Sinks[0])
+	}
+	if cfg.Vaults[0].Spec["url"] != "${NOT_SUBSTITUTED}" {
+		t.Errorf("Expected escaped reference, got %v", cfg.Vaults[0].Spec["url"])
+	}
+	if cfg.Vaults[0].Spec["description"] != "key: value\n- item" {
+		t.Errorf("Expected value to be kept as a string, got %#v", cfg.Vaults[0].Spec["description"])
+	}
+	if !cfg.Secrets[0].Optional {
+		t.Error("Expected optional to be substituted as boolean")
+	}
+	if pos := cfg.PositionOf("sinks[0].var"); pos.String() != "16:5" {
+		t.Errorf("Expected positions of the original source, got %s", pos)
+	}
+}
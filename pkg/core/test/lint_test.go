@@ -0,0 +1,71 @@
+package test
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	cfg, err := core.NewConfig(strings.NewReader(`vaults:
+  - name: kv1
+    type: mock
+  - name: kv1
+    type: mock
+
+secrets:
+  - type: secret
+    vault: kv1
+    name: s1
+  - type: secret
+    vault: kv2
+    name: s2
+  - type: secret
+    vault: kv1
+    name: s1
+
+transformations:
+  - type: mock
+    in:
+      - s1
+      - missing
+    out: t1
+
+sinks:
+  - type: nosuchsink
+    var: s1
+`))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	exp := []string{
+		"4:5: warning: duplicate vault name kv1",
+		"12:5: error: invalid vault kv2 referenced in secret s2",
+		"13:5: warning: secret s2 is not used by any transformation or sink",
+		"16:5: warning: duplicate variable name s1",
+		"22:9: error: unknown input variable: missing",
+		"23:5: warning: output t1 is not used by any transformation or sink",
+		"26:5: error: unknown sink type: nosuchsink",
+	}
+	findings := cfg.Lint(mf)
+	if len(findings) != len(exp) {
+		t.Fatalf("Expected %d findings, got %v", len(exp), findings)
+	}
+	for i, finding := range findings {
+		if finding.String() != exp[i] {
+			t.Errorf("Expected %q, got %q", exp[i], finding)
+		}
+	}
+
+	// Validate reports the first error only
+	if err := cfg.Validate(mf); err == nil || err.Error() != "12:5: invalid vault kv2 referenced in secret s2" {
+		t.Errorf("Expected first error, got %v", err)
+	}
+}
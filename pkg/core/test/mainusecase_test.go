@@ -2,10 +2,11 @@ package test
 
 import (
 	"context"
+	"errors"
 	"github.com/golang/mock/gomock"
-	"go-secretshelper/pkg/core"
-	"io/ioutil"
-	"log"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
 	"testing"
 )
 
@@ -46,7 +47,7 @@ func TestMainUseCase(t *testing.T) {
 	}
 	defaults := &core.Defaults{}
 
-	useCase := core.NewMainUseCaseImpl(log.New(ioutil.Discard, "", 0))
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
 
 	// set up expectations
 	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return((*secrets)[0], nil).Times(1)
@@ -61,3 +62,307 @@ func TestMainUseCase(t *testing.T) {
 	}
 
 }
+
+func TestMainUseCaseErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{
+			Name: "test",
+			Type: "mock",
+		},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{
+			Name:      "test",
+			Type:      "secret",
+			VaultName: "test",
+		},
+	}
+	sinks := &core.Sinks{
+		&core.Sink{
+			Type: "mock",
+			Var:  "test",
+		},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
+
+	// vault access fails
+	errDenied := errors.New("permission denied")
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return(nil, errDenied).Times(1)
+
+	err := useCase.Process(ctx, mf, defaults, vaults, secrets, nil, sinks)
+	var errVaultAccess core.ErrVaultAccess
+	if !errors.As(err, &errVaultAccess) {
+		t.Fatalf("Expected ErrVaultAccess, got %#v", err)
+	}
+	if errVaultAccess.Vault != "test" || errVaultAccess.Secret != "test" {
+		t.Errorf("Unexpected vault or secret in %#v", errVaultAccess)
+	}
+	if !errors.Is(err, errDenied) {
+		t.Errorf("Expected error to wrap vault error, got %s", err)
+	}
+
+	// sink fails
+	errDiskFull := errors.New("disk full")
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return((*secrets)[0], nil).Times(1)
+	mf.GetMockRepository().EXPECT().Put(gomock.Any(), (*secrets)[0]).Times(1)
+	mf.GetMockRepository().EXPECT().Get("test").Return((*secrets)[0], nil).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, (*secrets)[0], (*sinks)[0]).Return(errDiskFull).Times(1)
+
+	err = useCase.Process(ctx, mf, defaults, vaults, secrets, nil, sinks)
+	var errSink core.ErrSink
+	if !errors.As(err, &errSink) {
+		t.Fatalf("Expected ErrSink, got %#v", err)
+	}
+	if errSink.Type != "mock" || errSink.Var != "test" {
+		t.Errorf("Unexpected type or var in %#v", errSink)
+	}
+	if !errors.Is(err, errDiskFull) {
+		t.Errorf("Expected error to wrap sink error, got %s", err)
+	}
+}
+
+func TestMainUseCaseKeepGoing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{
+			Name: "test",
+			Type: "mock",
+		},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{
+			Name:      "missing",
+			Type:      "secret",
+			VaultName: "test",
+		},
+		&core.Secret{
+			Name:      "present",
+			Type:      "secret",
+			VaultName: "test",
+		},
+	}
+	transformations := &core.Transformations{
+		&core.Transformation{
+			Input:  []string{"missing"},
+			Output: "missing-out",
+			Type:   "mock",
+		},
+	}
+	sinks := &core.Sinks{
+		&core.Sink{
+			Type: "mock",
+			Var:  "missing-out",
+		},
+		&core.Sink{
+			Type: "mock",
+			Var:  "present",
+		},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard(), core.WithKeepGoing(true))
+
+	errNotFound := errors.New("not found")
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return(nil, errNotFound).Times(1)
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[1]).Return((*secrets)[1], nil).Times(1)
+	mf.GetMockRepository().EXPECT().Put("present", (*secrets)[1]).Times(1)
+	mf.GetMockRepository().EXPECT().Get("present").Return((*secrets)[1], nil).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, (*secrets)[1], (*sinks)[1]).Times(1)
+
+	report, err := useCase.ProcessWithReport(ctx, mf, defaults, vaults, secrets, transformations, sinks)
+	if !errors.Is(err, errNotFound) {
+		t.Errorf("Expected error of failed step, got %v", err)
+	}
+
+	expected := []core.StepStatus{core.StepFailed, core.StepSucceeded, core.StepSkipped, core.StepSkipped, core.StepSucceeded}
+	if len(report.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d", len(expected), len(report.Steps))
+	}
+	for i, status := range expected {
+		if report.Steps[i].Status != status {
+			t.Errorf("Expected step %d to be %s, got %s", i, status, report.Steps[i].Status)
+		}
+	}
+	if !report.Failed() {
+		t.Error("Expected report to be failed")
+	}
+
+	b := new(strings.Builder)
+	if err := report.WriteTable(b); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+	if !strings.Contains(b.String(), "input missing is unavailable") {
+		t.Errorf("Expected skip reason in table, got %s", b.String())
+	}
+}
+
+func TestMainUseCaseOptionalSecrets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{
+			Name: "primary",
+			Type: "mock",
+		},
+		&core.Vault{
+			Name: "fallback",
+			Type: "mock",
+		},
+	}
+	defaultValue := "default"
+	secrets := &core.Secrets{
+		&core.Secret{
+			Name:           "withfallback",
+			Type:           "secret",
+			VaultName:      "primary",
+			FallbackVaults: []string{"fallback"},
+		},
+		&core.Secret{
+			Name:      "withdefault",
+			Type:      "secret",
+			VaultName: "primary",
+			Optional:  true,
+			Default:   &defaultValue,
+		},
+		&core.Secret{
+			Name:      "absent",
+			Type:      "secret",
+			VaultName: "primary",
+			Optional:  true,
+		},
+	}
+	sinks := &core.Sinks{
+		&core.Sink{
+			Type: "mock",
+			Var:  "withfallback",
+		},
+		&core.Sink{
+			Type:     "mock",
+			Var:      "withdefault",
+			IfAbsent: core.SinkIfAbsentDefault,
+		},
+		&core.Sink{
+			Type:     "mock",
+			Var:      "absent",
+			IfAbsent: core.SinkIfAbsentSkip,
+		},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
+
+	errNotFound := core.ErrNotFound{Err: errors.New("not found")}
+	va := mf.GetMockVaultAccessor("mock")
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return(nil, errNotFound).Times(1)
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[1], (*secrets)[0]).Return((*secrets)[0], nil).Times(1)
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[1]).Return(nil, errNotFound).Times(1)
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[2]).Return(nil, errNotFound).Times(1)
+
+	var defaulted *core.Secret
+	mf.GetMockRepository().EXPECT().Put("withfallback", (*secrets)[0]).Times(1)
+	mf.GetMockRepository().EXPECT().Put("withdefault", gomock.Any()).Do(func(name string, content interface{}) {
+		defaulted = content.(*core.Secret)
+	}).Times(1)
+	mf.GetMockRepository().EXPECT().Get("withfallback").Return((*secrets)[0], nil).Times(1)
+	mf.GetMockRepository().EXPECT().Get("withdefault").DoAndReturn(func(name string) (interface{}, error) {
+		return defaulted, nil
+	}).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, (*secrets)[0], (*sinks)[0]).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, gomock.Any(), (*sinks)[1]).Times(1)
+
+	report, err := useCase.ProcessWithReport(ctx, mf, defaults, vaults, secrets, nil, sinks)
+	if err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+
+	if defaulted == nil || string(defaulted.RawContent) != defaultValue {
+		t.Errorf("Expected default value, got %v", defaulted)
+	}
+
+	expected := []core.StepStatus{core.StepSucceeded, core.StepAbsent, core.StepAbsent,
+		core.StepSucceeded, core.StepSucceeded, core.StepSkipped}
+	if len(report.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d", len(expected), len(report.Steps))
+	}
+	for i, status := range expected {
+		if report.Steps[i].Status != status {
+			t.Errorf("Expected step %d to be %s, got %s", i, status, report.Steps[i].Status)
+		}
+	}
+}
+
+func TestMainUseCaseOptionalSecretsAccessErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{
+			Name: "primary",
+			Type: "mock",
+		},
+		&core.Vault{
+			Name: "fallback",
+			Type: "mock",
+		},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{
+			Name:           "optional",
+			Type:           "secret",
+			VaultName:      "primary",
+			FallbackVaults: []string{"fallback"},
+			Optional:       true,
+		},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
+
+	// the secret is missing in the fallback vault, but the primary vault denies access
+	errDenied := errors.New("access denied")
+	va := mf.GetMockVaultAccessor("mock")
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).Return(nil, errDenied).Times(1)
+	va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[1], (*secrets)[0]).
+		Return(nil, core.ErrNotFound{Err: errors.New("not found")}).Times(1)
+
+	sinks := &core.Sinks{
+		&core.Sink{
+			Type:     "mock",
+			Var:      "optional",
+			IfAbsent: core.SinkIfAbsentSkip,
+		},
+	}
+
+	report, err := useCase.ProcessWithReport(ctx, mf, defaults, vaults, secrets, nil, sinks)
+	var errVaultAccess core.ErrVaultAccess
+	if !errors.As(err, &errVaultAccess) || errVaultAccess.Vault != "primary" || !errors.Is(err, errDenied) {
+		t.Errorf("Expected access error of primary vault, got %v", err)
+	}
+	if len(report.Steps) != 2 || report.Steps[0].Status != core.StepFailed {
+		t.Errorf("Expected failed step, got %v", report.Steps)
+	}
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+	}
+	return dir
+}
+
+func TestNewConfigFromFiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"vaults.yaml": `defaults:
+  retry:
+    maxAttempts: 3
+vaults:
+  - name: kv1
+    type: mock
+    spec:
+      url: https://one.example.com
+  - name: kv2
+    type: mock
+`,
+		"service.yaml": `include:
+  - vaults.yaml
+secrets:
+  - type: secret
+    vault: kv1
+    name: s1
+sinks:
+  - type: mock
+    var: s1
+`,
+		"overlay.yaml": `defaults:
+  retry:
+    timeout: 5
+vaults:
+  - name: kv1
+    type: mock
+    spec:
+      url: https://two.example.com
+secrets:
+  - type: secret
+    vault: kv2
+    name: s2
+`,
+	})
+
+	cfg, err := core.NewConfigFromFiles([]string{
+		filepath.Join(dir, "service.yaml"),
+		filepath.Join(dir, "overlay.yaml"),
+	}, core.NoEnvSubst)
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	if len(cfg.Vaults) != 2 || cfg.Vaults[0].Name != "kv1" || cfg.Vaults[1].Name != "kv2" {
+		t.Fatalf("Expected vaults kv1, kv2, got %v", cfg.Vaults)
+	}
+	if cfg.Vaults[0].Spec["url"] != "https://two.example.com" {
+		t.Errorf("Expected kv1 to be replaced by overlay, got %v", cfg.Vaults[0].Spec)
+	}
+	if len(cfg.Secrets) != 2 || len(cfg.Sinks) != 1 {
+		t.Errorf("Expected 2 secrets and 1 sink, got %d, %d", len(cfg.Secrets), len(cfg.Sinks))
+	}
+	if cfg.Defaults.Retry == nil || cfg.Defaults.Retry.MaxAttempts != 3 || cfg.Defaults.Retry.Timeout == 0 {
+		t.Errorf("Expected merged retry defaults, got %#v", cfg.Defaults.Retry)
+	}
+
+	for path, exp := range map[string]string{
+		"vaults[0].spec.url":         "overlay.yaml:8:7",
+		"vaults[1].name":             "vaults.yaml:9:5",
+		"secrets[0].name":            "service.yaml:6:5",
+		"secrets[1].vault":           "overlay.yaml:11:5",
+		"defaults.retry.maxAttempts": "vaults.yaml:3:5",
+		"defaults.retry.timeout":     "overlay.yaml:3:5",
+	} {
+		pos := cfg.PositionOf(path)
+		if filepath.Base(pos.File)+strings.TrimPrefix(pos.String(), pos.File) != exp {
+			t.Errorf("Expected position %s for %s, got %s", exp, path, pos)
+		}
+	}
+}
+
+func TestNewConfigFromFilesIncludeErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml":       "include: [b.yaml]\n",
+		"b.yaml":       "include: [a.yaml]\n",
+		"missing.yaml": "include:\n  - nosuchfile.yaml\n",
+	})
+
+	_, err := core.NewConfigFromFile(filepath.Join(dir, "a.yaml"), false)
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expected include cycle error, got %v", err)
+	}
+
+	_, err = core.NewConfigFromFile(filepath.Join(dir, "missing.yaml"), false)
+	if err == nil || !strings.Contains(err.Error(), "missing.yaml:2:5: unable to include nosuchfile.yaml") {
+		t.Errorf("Expected include error with position, got %v", err)
+	}
+}
+
+func TestNewConfigFromFilesWithoutFiles(t *testing.T) {
+	_, err := core.NewConfigFromFiles(nil, core.NoEnvSubst)
+	if err == nil || err.Error() != "no configuration file given" {
+		t.Errorf("Expected error without files, got %v", err)
+	}
+}
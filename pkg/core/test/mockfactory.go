@@ -2,8 +2,8 @@ package test
 
 import (
 	"github.com/golang/mock/gomock"
-	"go-secretshelper/pkg/core"
-	"go-secretshelper/pkg/core/mocks"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core/mocks"
 	"testing"
 )
 
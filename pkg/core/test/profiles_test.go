@@ -0,0 +1,173 @@
+package test
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+)
+
+const profilesConfig = `vaults:
+  - name: kv1
+    type: mock
+    spec:
+      url: https://dev.example.com
+      tenant: dev
+
+secrets:
+  - type: secret
+    vault: kv1
+    name: s1
+
+sinks:
+  - type: mock
+    var: s1
+    spec:
+      path: ./s1.txt
+
+profiles:
+  prod:
+    vaults:
+      - name: kv1
+        spec:
+          url: https://prod.example.com
+      - name: kv2
+        type: mock
+    secrets:
+      - name: s1
+        vault: kv2
+    sinks:
+      - var: s1
+        spec:
+          path: /etc/s1.txt
+  staging:
+    secrets:
+      - name: s1
+        vault: kv3
+`
+
+func TestProfiles(t *testing.T) {
+	cfg, err := core.NewConfig(strings.NewReader(profilesConfig))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	if names := cfg.ProfileNames(); len(names) != 2 || names[0] != "prod" || names[1] != "staging" {
+		t.Errorf("Expected profiles prod, staging, got %v", names)
+	}
+
+	prod, err := cfg.WithProfile("prod")
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	if len(prod.Vaults) != 2 {
+		t.Fatalf("Expected 2 vaults, got %v", prod.Vaults)
+	}
+	if prod.Vaults[0].Spec["url"] != "https://prod.example.com" || prod.Vaults[0].Spec["tenant"] != "dev" {
+		t.Errorf("Expected spec to be merged, got %v", prod.Vaults[0].Spec)
+	}
+	if prod.Secrets[0].VaultName != "kv2" || prod.Secrets[0].Type != "secret" {
+		t.Errorf("Expected secret to be patched, got %#v", prod.Secrets[0])
+	}
+	if prod.Sinks[0].Spec["path"] != "/etc/s1.txt" || prod.Sinks[0].Type != "mock" {
+		t.Errorf("Expected sink to be patched, got %#v", prod.Sinks[0])
+	}
+	if pos := prod.PositionOf("vaults[0].spec.url"); pos.String() != "24:11" {
+		t.Errorf("Expected position of profile element, got %s", pos)
+	}
+	if pos := prod.PositionOf("vaults[0].spec.tenant"); pos.String() != "6:7" {
+		t.Errorf("Expected position of base element, got %s", pos)
+	}
+
+	// the original configuration is untouched
+	if cfg.Vaults[0].Spec["url"] != "https://dev.example.com" || cfg.Secrets[0].VaultName != "kv1" {
+		t.Errorf("Expected configuration to be unchanged, got %v, %v", cfg.Vaults[0], cfg.Secrets[0])
+	}
+
+	if _, err := cfg.WithProfile("nosuchprofile"); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
+
+func TestProfilesRemoteKey(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	cfg, err := core.NewConfig(strings.NewReader(`vaults:
+  - name: kv1
+    type: mock
+
+secrets:
+  - type: secret
+    vault: kv1
+    name: db-password
+
+sinks:
+  - type: mock
+    var: db-password
+
+profiles:
+  prod:
+    secrets:
+      - name: db-password
+        key: prod-db-password
+`))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	prod, err := cfg.WithProfile("prod")
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	if prod.Secrets[0].Name != "db-password" || prod.Secrets[0].Key != "prod-db-password" || cfg.Secrets[0].Key != "" {
+		t.Errorf("Expected key of secret to be patched, got %#v", prod.Secrets[0])
+	}
+
+	// the vault is asked for the key, the variable keeps the name
+	ctx := context.TODO()
+	defaults := &core.Defaults{}
+	var stored *core.Secret
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, prod.Vaults[0], gomock.Any()).DoAndReturn(
+		func(ctx context.Context, defaults *core.Defaults, vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+			if secret.Name != "prod-db-password" {
+				t.Errorf("Expected vault to be asked for prod-db-password, got %s", secret.Name)
+			}
+			return &core.Secret{Name: secret.Name, Type: secret.Type, RawContent: []byte("s3cr3t")}, nil
+		}).Times(1)
+	mf.GetMockRepository().EXPECT().Put("db-password", gomock.Any()).Do(func(name string, content interface{}) {
+		stored = content.(*core.Secret)
+	}).Times(1)
+	mf.GetMockRepository().EXPECT().Get("db-password").DoAndReturn(func(name string) (interface{}, error) {
+		return stored, nil
+	}).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, gomock.Any(), prod.Sinks[0]).Times(1)
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
+	if err := useCase.Process(ctx, mf, defaults, &prod.Vaults, &prod.Secrets, nil, &prod.Sinks); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+	if stored == nil || stored.Name != "db-password" || string(stored.RawContent) != "s3cr3t" {
+		t.Errorf("Expected secret stored as db-password, got %v", stored)
+	}
+}
+
+func TestValidationForProfiles(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	cfg, err := core.NewConfig(strings.NewReader(profilesConfig))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	err = cfg.Validate(mf)
+	if err == nil || err.Error() != "37:9: profile staging: invalid vault kv3 referenced in secret s1" {
+		t.Errorf("Expected error in staging profile, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core/mocks"
+	"reflect"
+	"testing"
+)
+
+func newTestRegistryFactory(t *testing.T, ctrl *gomock.Controller) *core.RegistryFactory {
+	f := core.NewRegistryFactory(func() core.Repository { return mocks.NewMockRepository(ctrl) })
+	for _, err := range []error{
+		f.RegisterVault("kv", func() core.VaultAccessorPort { return mocks.NewMockVaultAccessorPort(ctrl) }),
+		f.RegisterVault("env", func() core.VaultAccessorPort { return mocks.NewMockVaultAccessorPort(ctrl) }),
+		f.RegisterTransformation("upper", func() core.TransformationPort { return mocks.NewMockTransformationPort(ctrl) }),
+		f.RegisterSink("file", func() core.SinkWriterPort { return mocks.NewMockSinkWriterPort(ctrl) }),
+	} {
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	return f
+}
+
+func TestRegistryFactory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := newTestRegistryFactory(t, ctrl)
+
+	if !reflect.DeepEqual(f.VaultAccessorTypes(), []string{"kv", "env"}) ||
+		!reflect.DeepEqual(f.TransformationTypes(), []string{"upper"}) ||
+		!reflect.DeepEqual(f.SinkTypes(), []string{"file"}) {
+		t.Errorf("Expected types in order of registration, got %v, %v, %v",
+			f.VaultAccessorTypes(), f.TransformationTypes(), f.SinkTypes())
+	}
+	if f.NewVaultAccessor("kv") == nil || f.NewTransformation("upper") == nil || f.NewSinkWriter("file") == nil {
+		t.Error("Expected ports of registered types")
+	}
+	if f.NewVaultAccessor("file") != nil || f.NewSinkWriter("kv") != nil || f.NewTransformation("nosuchtype") != nil {
+		t.Error("Expected nil for unregistered types")
+	}
+	if f.NewRepository() == nil {
+		t.Error("Expected repository")
+	}
+
+	err := f.RegisterVault("kv", func() core.VaultAccessorPort { return nil })
+	var errCollision core.ErrTypeCollision
+	if !errors.As(err, &errCollision) || err.Error() != "vault type kv is already registered" {
+		t.Errorf("Expected collision, got %v", err)
+	}
+	if err := f.RegisterSink("", func() core.SinkWriterPort { return nil }); err == nil {
+		t.Error("Expected error for type without name")
+	}
+	if err := f.RegisterTransformation("lower", nil); err == nil {
+		t.Error("Expected error for nil constructor")
+	}
+}
+
+func TestRegistryFactoryLayers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := newTestRegistryFactory(t, ctrl)
+
+	other := core.NewRegistryFactory(nil)
+	other.RegisterVault("exec", func() core.VaultAccessorPort { return mocks.NewMockVaultAccessorPort(ctrl) })
+	other.RegisterSink("file", func() core.SinkWriterPort { return mocks.NewMockSinkWriterPort(ctrl) })
+
+	// no type of a colliding factory is registered
+	err := f.RegisterFactory(other)
+	if err == nil || err.Error() != "sink type file is already registered" {
+		t.Errorf("Expected collision, got %v", err)
+	}
+	if f.NewVaultAccessor("exec") != nil {
+		t.Error("Expected types of colliding factory not to be registered")
+	}
+
+	other = core.NewRegistryFactory(nil)
+	other.RegisterVault("exec", func() core.VaultAccessorPort { return mocks.NewMockVaultAccessorPort(ctrl) })
+	other.RegisterTransformation("lower", func() core.TransformationPort { return mocks.NewMockTransformationPort(ctrl) })
+	if err := f.RegisterFactory(other); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(f.VaultAccessorTypes(), []string{"kv", "env", "exec"}) || f.NewTransformation("lower") == nil {
+		t.Errorf("Expected types of layered factory, got %v", f.VaultAccessorTypes())
+	}
+
+	// types provided twice by the same factory collide as well
+	f = core.NewRegistryFactory(nil)
+	err = f.RegisterFactory(duplicateTypesFactory{NewMockFactory(ctrl, t)})
+	if err == nil || err.Error() != "vault type mock is already registered" {
+		t.Errorf("Expected collision, got %v", err)
+	}
+	if len(f.VaultAccessorTypes()) != 0 {
+		t.Error("Expected types of colliding factory not to be registered")
+	}
+}
+
+// duplicateTypesFactory provides the vault type mock twice
+type duplicateTypesFactory struct {
+	*MockFactory
+}
+
+func (f duplicateTypesFactory) VaultAccessorTypes() []string {
+	return []string{"mock", "mock"}
+}
@@ -0,0 +1,212 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+// flakyVaultAccessor fails a given number of times before returning a secret
+type flakyVaultAccessor struct {
+	failures int
+	calls    int
+	err      error
+	block    bool
+	fatal    bool
+}
+
+func (f *flakyVaultAccessor) RetrieveSecret(ctx context.Context, defaults *core.Defaults,
+	vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+	f.calls++
+	if f.block {
+		<-ctx.Done()
+		if f.fatal {
+			return nil, core.ErrFatal{Err: ctx.Err()}
+		}
+		return nil, ctx.Err()
+	}
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	return secret, nil
+}
+
+func (f *flakyVaultAccessor) IsRetryable(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	cfg, err := core.NewConfig(strings.NewReader(`
+defaults:
+  retry:
+    maxAttempts: 3
+    initialBackoff: 100ms
+    timeout: 5
+
+vaults:
+  - name: kv1
+    type: mock
+    retry:
+      maxAttempts: 5
+      maxBackoff: 1s
+  - name: kv2
+    type: mock
+`))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	p := core.EffectiveRetryPolicy(&cfg.Defaults, cfg.Vaults[0])
+	if p.MaxAttempts != 5 {
+		t.Errorf("Expected maxAttempts=5, got %d", p.MaxAttempts)
+	}
+	if time.Duration(p.MaxBackoff) != time.Second {
+		t.Errorf("Expected maxBackoff=1s, got %s", p.MaxBackoff)
+	}
+	if time.Duration(p.InitialBackoff) != 100*time.Millisecond {
+		t.Errorf("Expected initialBackoff=100ms, got %s", p.InitialBackoff)
+	}
+	if time.Duration(p.Timeout) != 5*time.Second {
+		t.Errorf("Expected timeout=5s, got %s", p.Timeout)
+	}
+
+	p = core.EffectiveRetryPolicy(&cfg.Defaults, cfg.Vaults[1])
+	if p.MaxAttempts != 3 {
+		t.Errorf("Expected maxAttempts=3, got %d", p.MaxAttempts)
+	}
+	if p.Multiplier != core.DefaultRetryMultiplier {
+		t.Errorf("Expected default multiplier, got %f", p.Multiplier)
+	}
+
+	p = core.EffectiveRetryPolicy(&core.Defaults{}, &core.Vault{})
+	if p.MaxAttempts != core.DefaultRetryMaxAttempts {
+		t.Errorf("Expected default maxAttempts, got %d", p.MaxAttempts)
+	}
+
+	p.InitialBackoff = core.Duration(time.Second)
+	p.MaxBackoff = core.Duration(3 * time.Second)
+	for retry, exp := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if b := p.Backoff(retry + 1); b != exp {
+			t.Errorf("Expected backoff %s for retry %d, got %s", exp, retry+1, b)
+		}
+	}
+}
+
+func TestRetryPolicyExplicitZero(t *testing.T) {
+	cfg, err := core.NewConfig(strings.NewReader(`
+defaults:
+  retry:
+    jitter: 0.5
+    timeout: 5s
+
+vaults:
+  - name: kv1
+    type: mock
+    retry:
+      jitter: 0
+      timeout: 0
+  - name: kv2
+    type: mock
+
+profiles:
+  local:
+    defaults:
+      retry:
+        timeout: 0
+`))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	p := core.EffectiveRetryPolicy(&cfg.Defaults, cfg.Vaults[0])
+	if p.Jitter != 0 || p.Timeout != 0 {
+		t.Errorf("Expected jitter and timeout of vault to override defaults, got %v, %s", p.Jitter, p.Timeout)
+	}
+	p = core.EffectiveRetryPolicy(&cfg.Defaults, cfg.Vaults[1])
+	if p.Jitter != 0.5 || time.Duration(p.Timeout) != 5*time.Second {
+		t.Errorf("Expected jitter and timeout of defaults, got %v, %s", p.Jitter, p.Timeout)
+	}
+
+	local, err := cfg.WithProfile("local")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	p = core.EffectiveRetryPolicy(&local.Defaults, local.Vaults[1])
+	if p.Jitter != 0.5 || p.Timeout != 0 {
+		t.Errorf("Expected timeout of profile to override defaults, got %v, %s", p.Jitter, p.Timeout)
+	}
+}
+
+func TestRetryingVaultAccessor(t *testing.T) {
+	l := logging.Discard()
+	vault := &core.Vault{Name: "test", Type: "mock"}
+	secret := &core.Secret{Name: "test", Type: "secret", VaultName: "test"}
+	policy := core.NewDefaultRetryPolicy().Merge(&core.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: core.Duration(time.Millisecond),
+	})
+
+	// succeeds after transient errors
+	va := &flakyVaultAccessor{failures: 2, err: errTransient}
+	res, err := core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+	if res != secret {
+		t.Errorf("Expected secret, got %v", res)
+	}
+	if va.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", va.calls)
+	}
+
+	// gives up after max attempts
+	va = &flakyVaultAccessor{failures: 5, err: errTransient}
+	_, err = core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if !errors.Is(err, errTransient) {
+		t.Errorf("Expected wrapped transient error, got %v", err)
+	}
+	if va.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", va.calls)
+	}
+
+	// fatal errors are not retried
+	va = &flakyVaultAccessor{failures: 5, err: errors.New("permission denied")}
+	_, err = core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if va.calls != 1 {
+		t.Errorf("Expected 1 call, got %d", va.calls)
+	}
+
+	// attempts that time out are retried
+	va = &flakyVaultAccessor{block: true}
+	policy.Timeout = core.Duration(time.Millisecond)
+	_, err = core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if va.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", va.calls)
+	}
+
+	// unless the accessor reports the timeout as fatal
+	va = &flakyVaultAccessor{block: true, fatal: true}
+	_, err = core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if !errors.Is(err, context.DeadlineExceeded) || va.calls != 1 {
+		t.Errorf("Expected 1 call with deadline exceeded, got %d, %v", va.calls, err)
+	}
+
+	// fatal errors are not retried, even if classified as transient
+	va = &flakyVaultAccessor{failures: 5, err: core.ErrFatal{Err: errTransient}}
+	_, err = core.NewRetryingVaultAccessor(l, va, policy).RetrieveSecret(context.TODO(), &core.Defaults{}, vault, secret)
+	if !errors.Is(err, errTransient) || va.calls != 1 {
+		t.Errorf("Expected 1 call with fatal error, got %d, %v", va.calls, err)
+	}
+}
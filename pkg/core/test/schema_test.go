@@ -0,0 +1,96 @@
+package test
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"gopkg.in/yaml.v2"
+	"strings"
+	"testing"
+)
+
+type schemaTestSpec struct {
+	Path    string   `yaml:"path" validate:"required"`
+	Mode    *int     `yaml:"mode" schema:"integer|string"`
+	Level   string   `yaml:"level" validate:"omitempty,oneof=low high"`
+	Ratio   float64  `yaml:"ratio" validate:"gte=0,lte=1"`
+	Tags    []string `yaml:"tags" validate:"dive,required"`
+	Ignored string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := core.SchemaOf(schemaTestSpec{})
+
+	if s.Type != "object" {
+		t.Errorf("Expected type object, got %v", s.Type)
+	}
+	if len(s.Properties) != 5 {
+		t.Errorf("Expected 5 properties, got %d", len(s.Properties))
+	}
+	if len(s.Required) != 1 || s.Required[0] != "path" {
+		t.Errorf("Expected path to be required, got %v", s.Required)
+	}
+	if s.Properties["tags"].Items == nil || s.Properties["tags"].Items.Type != "string" {
+		t.Errorf("Expected tags to be an array of strings, got %#v", s.Properties["tags"])
+	}
+	if len(s.Properties["level"].Enum) != 2 {
+		t.Errorf("Expected enum for level, got %v", s.Properties["level"].Enum)
+	}
+
+	for _, tc := range []struct {
+		spec   map[interface{}]interface{}
+		errors int
+	}{
+		{map[interface{}]interface{}{"path": "x"}, 0},
+		{map[interface{}]interface{}{"path": "x", "mode": 400, "level": "low", "ratio": 0.5, "tags": []interface{}{"a"}}, 0},
+		{map[interface{}]interface{}{"path": "x", "mode": "400"}, 0},
+		{map[interface{}]interface{}{}, 1},
+		{map[interface{}]interface{}{"path": 1}, 1},
+		{map[interface{}]interface{}{"path": "x", "mode": true}, 1},
+		{map[interface{}]interface{}{"path": "x", "level": "medium"}, 1},
+		{map[interface{}]interface{}{"path": "x", "ratio": 2}, 1},
+		{map[interface{}]interface{}{"path": "x", "tags": []interface{}{1}}, 1},
+		{map[interface{}]interface{}{"path": "x", "Ignored": "x", "pth": "x"}, 2},
+	} {
+		errs := s.Validate(tc.spec)
+		if len(errs) != tc.errors {
+			t.Errorf("Expected %d errors for %v, got %v", tc.errors, tc.spec, errs)
+		}
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	s := core.NewConfigSchema(NewMockFactory(mockCtrl, t))
+	if s.Schema != core.SchemaVersion {
+		t.Errorf("Expected $schema to be set, got %s", s.Schema)
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(`
+vaults:
+  - name: kv1
+    type: mock
+secrets:
+  - type: secret
+    vault: kv1
+    name: test
+sinks:
+  - type: nonex
+    var: test
+    extra: true
+`), &raw); err != nil {
+		t.Fatalf("Unexpected: %s", err)
+	}
+
+	errs := s.Validate(raw)
+	if len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got %v", errs)
+	}
+	for _, e := range errs {
+		if !strings.HasPrefix(e.Path, "sinks[0].") {
+			t.Errorf("Unexpected path in %s", e)
+		}
+	}
+}
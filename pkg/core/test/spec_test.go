@@ -0,0 +1,86 @@
+package test
+
+import (
+	"errors"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"strings"
+	"testing"
+)
+
+type testSpec struct {
+	Path    string `yaml:"path" validate:"required"`
+	Mode    *int   `yaml:"mode"`
+	Enabled bool   `yaml:"enabled"`
+	Kind    string `yaml:"kind" validate:"omitempty,oneof=a b"`
+}
+
+func TestDecodeSpec(t *testing.T) {
+	var spec testSpec
+	err := core.DecodeSpec(map[interface{}]interface{}{
+		"path":    "/tmp/x",
+		"mode":    "600",
+		"enabled": true,
+	}, &spec)
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	if spec.Path != "/tmp/x" || spec.Mode == nil || *spec.Mode != 600 || !spec.Enabled {
+		t.Errorf("Unexpected spec: %#v", spec)
+	}
+
+	for _, tc := range []struct {
+		in  map[interface{}]interface{}
+		exp string
+	}{
+		{map[interface{}]interface{}{}, "path: is required"},
+		{map[interface{}]interface{}{"path": 1}, "path: must be a string"},
+		{map[interface{}]interface{}{"path": "x", "mode": "rw"}, "mode: must be an integer"},
+		{map[interface{}]interface{}{"path": "x", "kind": "c"}, "kind: must be one of a b"},
+		{map[interface{}]interface{}{"pth": "x", "enabld": true},
+			`enabld: unknown field, did you mean "enabled"?; pth: unknown field, did you mean "path"?`},
+		{map[interface{}]interface{}{"path": "x", "colour": "red"}, "colour: unknown field"},
+	} {
+		err := core.DecodeSpec(tc.in, &testSpec{})
+		if err == nil || err.Error() != tc.exp {
+			t.Errorf("Expected error %q for %v, got %v", tc.exp, tc.in, err)
+		}
+		var specErrs core.SpecErrors
+		if !errors.As(err, &specErrs) {
+			t.Errorf("Expected SpecErrors, got %T", err)
+		}
+	}
+}
+
+func TestConfigPositions(t *testing.T) {
+	cfg, err := core.NewConfig(strings.NewReader(`vaults:
+  - name: test-vault
+    type: mock
+    spec:
+      url: https://example.com
+
+secrets:
+  - type: secret
+    vault: test-vault
+    name: test-secret
+`))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	for path, exp := range map[string]string{
+		"vaults":              "1:1",
+		"vaults[0]":           "2:5",
+		"vaults[0].spec.url":  "5:7",
+		"vaults[0].spec.path": "4:5",
+		"secrets[0].name":     "10:5",
+		"sinks[0].spec":       "1:1",
+	} {
+		if pos := cfg.PositionOf(path); pos.String() != exp {
+			t.Errorf("Expected position %s for %s, got %s", exp, path, pos)
+		}
+	}
+
+	if pos := (&core.Config{}).PositionOf("vaults"); pos.IsValid() {
+		t.Errorf("Expected invalid position, got %s", pos)
+	}
+}
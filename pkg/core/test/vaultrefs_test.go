@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+)
+
+func TestOrderSecrets(t *testing.T) {
+	vaults := &core.Vaults{
+		&core.Vault{Name: "kv1", Type: "mock"},
+		&core.Vault{Name: "kv2", Type: "mock", Spec: core.VaultSpec{
+			"url":   "https://example.com",
+			"token": "Bearer ${{ secrets.token }}",
+		}},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{Name: "s1", VaultName: "kv2"},
+		&core.Secret{Name: "s2", VaultName: "kv1"},
+		&core.Secret{Name: "token", VaultName: "kv1"},
+	}
+
+	if refs := (*vaults)[1].SecretRefs(); len(refs) != 1 || refs[0] != "token" {
+		t.Errorf("Expected reference to token, got %v", refs)
+	}
+
+	ordered, err := core.OrderSecrets(vaults, secrets)
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+	names := make([]string, len(ordered))
+	for i, secret := range ordered {
+		names[i] = secret.Name
+	}
+	if strings.Join(names, ",") != "s2,token,s1" {
+		t.Errorf("Expected order s2,token,s1, got %v", names)
+	}
+
+	// token is now retrieved from the vault needing it
+	(*secrets)[2].VaultName = "kv2"
+	_, err = core.OrderSecrets(vaults, secrets)
+	if err == nil || err.Error() != "vaults depend on each other in a cycle: secret token -> vault kv2 -> secret token" {
+		t.Errorf("Expected cycle, got %v", err)
+	}
+}
+
+func TestValidationForVaultRefs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	inp := `vaults:
+  - name: kv1
+    type: mock
+  - name: kv2
+    type: mock
+    spec:
+      token: ${{ secrets.token }}
+      password: ${{ secrets.nosuchsecret }}
+
+secrets:
+  - type: secret
+    vault: kv2
+    name: s1
+  - type: secret
+    vault: kv1
+    name: token
+
+sinks:
+  - type: mock
+    var: s1
+`
+	cfg, err := core.NewConfig(strings.NewReader(inp))
+	if err != nil {
+		t.Fatalf("Expected err=nil, got err=%s", err)
+	}
+
+	err = cfg.Validate(mf)
+	if err == nil || err.Error() != "6:5: invalid secret nosuchsecret referenced in spec of vault kv2" {
+		t.Errorf("Expected undefined reference, got %v", err)
+	}
+
+	cfg.Secrets[1].VaultName = "kv2"
+	delete(cfg.Vaults[1].Spec, "password")
+	err = cfg.Validate(mf)
+	if err == nil || !strings.HasPrefix(err.Error(), "1:1: vaults depend on each other in a cycle") {
+		t.Errorf("Expected cycle, got %v", err)
+	}
+}
+
+func TestMainUseCaseVaultRefs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{
+			Name: "bootstrap",
+			Type: "mock",
+		},
+		&core.Vault{
+			Name: "main",
+			Type: "mock",
+			Spec: core.VaultSpec{"token": "${{ secrets.token }}"},
+		},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{
+			Name:      "password",
+			Type:      "secret",
+			VaultName: "main",
+		},
+		&core.Secret{
+			Name:      "token",
+			Type:      "secret",
+			VaultName: "bootstrap",
+		},
+	}
+	sinks := &core.Sinks{
+		&core.Sink{
+			Type: "mock",
+			Var:  "password",
+		},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard())
+
+	token := &core.Secret{Name: "token", RawContent: []byte("s3cr3t")}
+	va := mf.GetMockVaultAccessor("mock")
+	gomock.InOrder(
+		va.EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[1]).Return(token, nil).Times(1),
+		va.EXPECT().RetrieveSecret(ctx, defaults, gomock.Any(), (*secrets)[0]).DoAndReturn(
+			func(ctx context.Context, defaults *core.Defaults, vault *core.Vault, secret *core.Secret) (*core.Secret, error) {
+				if vault.Spec["token"] != "s3cr3t" {
+					t.Errorf("Expected resolved token in spec, got %v", vault.Spec)
+				}
+				return secret, nil
+			}).Times(1),
+	)
+	mf.GetMockRepository().EXPECT().Put("token", token).Times(1)
+	mf.GetMockRepository().EXPECT().Put("password", (*secrets)[0]).Times(1)
+	mf.GetMockRepository().EXPECT().Get("token").Return(token, nil).Times(1)
+	mf.GetMockRepository().EXPECT().Get("password").Return((*secrets)[0], nil).Times(1)
+	mf.GetMockSinkWriter("mock").EXPECT().Write(ctx, defaults, (*secrets)[0], (*sinks)[0]).Times(1)
+
+	if err := useCase.Process(ctx, mf, defaults, vaults, secrets, nil, sinks); err != nil {
+		t.Errorf("Unexpected: %s", err)
+	}
+	if (*vaults)[1].Spec["token"] != "${{ secrets.token }}" {
+		t.Errorf("Expected vault to be unchanged, got %v", (*vaults)[1].Spec)
+	}
+}
+
+func TestMainUseCaseVaultRefsUnavailable(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx := context.TODO()
+
+	mf := NewMockFactory(mockCtrl, t)
+
+	vaults := &core.Vaults{
+		&core.Vault{Name: "bootstrap", Type: "mock"},
+		&core.Vault{Name: "main", Type: "mock", Spec: core.VaultSpec{"token": "${{ secrets.token }}"}},
+	}
+	secrets := &core.Secrets{
+		&core.Secret{Name: "token", Type: "secret", VaultName: "bootstrap"},
+		&core.Secret{Name: "password", Type: "secret", VaultName: "main"},
+	}
+	sinks := &core.Sinks{
+		&core.Sink{Type: "mock", Var: "password"},
+	}
+	defaults := &core.Defaults{}
+
+	useCase := core.NewMainUseCaseImpl(logging.Discard(), core.WithKeepGoing(true))
+
+	mf.GetMockVaultAccessor("mock").EXPECT().RetrieveSecret(ctx, defaults, (*vaults)[0], (*secrets)[0]).
+		Return(nil, errors.New("denied")).Times(1)
+
+	report, err := useCase.ProcessWithReport(ctx, mf, defaults, vaults, secrets, nil, sinks)
+	if err == nil {
+		t.Error("Expected error")
+	}
+	if s := report.Steps[1]; s.Status != core.StepSkipped || s.Reason != "input token is unavailable" {
+		t.Errorf("Expected secret to be skipped, got %#v", s)
+	}
+}
@@ -18,5 +18,9 @@ type UseCase interface {
 	// WriteToSink writes output a single sink by pulling it from the repository
 	WriteToSink(context.Context, Factory, *Defaults, Repository, *Sink) error
 
+	// Process runs all steps, i.e. pulls secrets, applies transformations and writes to sinks
 	Process(context.Context, Factory, *Defaults, *Vaults, *Secrets, *Transformations, *Sinks) error
+
+	// ProcessWithReport works like Process and additionally reports the outcome of each step
+	ProcessWithReport(context.Context, Factory, *Defaults, *Vaults, *Secrets, *Transformations, *Sinks) (*Report, error)
 }
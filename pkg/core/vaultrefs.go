@@ -0,0 +1,207 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// secretRefPattern matches references to secrets within vault specs, e.g. ${{ secrets.token }}
+var secretRefPattern = regexp.MustCompile(`\$\{\{\s*secrets\.([^\s}]+)\s*\}\}`)
+
+// hasSecretRefs returns true if a spec references secrets
+func hasSecretRefs(spec map[interface{}]interface{}) bool {
+	found := false
+	walkSpecStrings(spec, func(s string) string {
+		found = found || secretRefPattern.MatchString(s)
+		return s
+	})
+	return found
+}
+
+// SecretRefs returns the names of all secrets referenced in the spec of the vault,
+// e.g. by ${{ secrets.token }}, in order of their keys.
+func (v *Vault) SecretRefs() []string {
+	res := make([]string, 0)
+	if v.Spec == nil {
+		return res
+	}
+	seen := make(map[string]struct{})
+	walkSpecStrings(map[interface{}]interface{}(v.Spec), func(s string) string {
+		for _, m := range secretRefPattern.FindAllStringSubmatch(s, -1) {
+			if _, ex := seen[m[1]]; !ex {
+				seen[m[1]] = struct{}{}
+				res = append(res, m[1])
+			}
+		}
+		return s
+	})
+	return res
+}
+
+// ResolveSecretRefs returns a copy of the vault, where all references to secrets in its spec
+// are replaced by the contents of the secrets in the repository. The vault itself is returned
+// if it does not reference any secrets.
+func (v *Vault) ResolveSecretRefs(repository Repository) (*Vault, error) {
+	if len(v.SecretRefs()) == 0 {
+		return v, nil
+	}
+
+	var firstErr error
+	spec := walkSpecStrings(map[interface{}]interface{}(v.Spec), func(s string) string {
+		return secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			name := secretRefPattern.FindStringSubmatch(ref)[1]
+			content, err := repository.Get(name)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("secret %s referenced in spec of vault %s is unavailable: %w", name, v.Name, err)
+				}
+				return ref
+			}
+			return string(content.(*Secret).RawContent)
+		})
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	res := *v
+	res.Spec = VaultSpec(spec.(map[interface{}]interface{}))
+	return &res, nil
+}
+
+// walkSpecStrings applies f to all strings within a spec and returns a copy with the results
+func walkSpecStrings(v interface{}, f func(string) string) interface{} {
+	switch vv := v.(type) {
+	case string:
+		return f(vv)
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(vv))
+		byKey := make(map[string]interface{}, len(vv))
+		for k := range vv {
+			keys = append(keys, fmt.Sprint(k))
+			byKey[fmt.Sprint(k)] = k
+		}
+		sort.Strings(keys)
+
+		res := make(map[interface{}]interface{}, len(vv))
+		for _, k := range keys {
+			res[byKey[k]] = walkSpecStrings(vv[byKey[k]], f)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(vv))
+		for i, e := range vv {
+			res[i] = walkSpecStrings(e, f)
+		}
+		return res
+	}
+	return v
+}
+
+// secretDependencies returns the secrets referenced by the vaults a secret may be retrieved
+// from, and the secret its default is taken from
+func secretDependencies(vaults *Vaults, secret *Secret) []string {
+	res := make([]string, 0)
+	if secret.DefaultFrom != "" {
+		res = append(res, secret.DefaultFrom)
+	}
+	for _, vaultName := range append([]string{secret.VaultName}, secret.FallbackVaults...) {
+		if vault := vaults.GetVaultByName(vaultName); vault != nil {
+			res = append(res, vault.SecretRefs()...)
+		}
+	}
+	return res
+}
+
+// OrderSecrets returns the secrets in the order they have to be retrieved, so that secrets
+// referenced in the spec of a vault are retrieved before the vault is accessed. The order of
+// the configuration is kept where possible. It fails if vaults depend on each other in a cycle.
+func OrderSecrets(vaults *Vaults, secrets *Secrets) (Secrets, error) {
+	res := make(Secrets, 0, len(*secrets))
+	done := make(map[string]struct{})
+	defined := make(map[string]struct{})
+	for _, secret := range *secrets {
+		defined[secret.Name] = struct{}{}
+	}
+
+	pending := append(Secrets{}, *secrets...)
+	for len(pending) > 0 {
+		next := -1
+		for i, secret := range pending {
+			ready := true
+			for _, ref := range secretDependencies(vaults, secret) {
+				_, isDone := done[ref]
+				_, isDefined := defined[ref]
+				// undefined references are reported when the vault is accessed
+				if isDefined && !isDone {
+					ready = false
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("vaults depend on each other in a cycle: %s",
+				strings.Join(vaultDependencyCycle(vaults, pending), " -> "))
+		}
+
+		res = append(res, pending[next])
+		done[pending[next].Name] = struct{}{}
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+
+	return res, nil
+}
+
+// vaultDependencyCycle returns a cycle of dependencies between given secrets and their vaults
+func vaultDependencyCycle(vaults *Vaults, secrets Secrets) []string {
+	byName := make(map[string]*Secret)
+	for _, secret := range secrets {
+		byName[secret.Name] = secret
+	}
+
+	var path []string
+	onPath := make(map[string]int)
+	var visit func(secret *Secret) []string
+	visit = func(secret *Secret) []string {
+		if i, ex := onPath[secret.Name]; ex {
+			return append(path[i:], "secret "+secret.Name)
+		}
+		onPath[secret.Name] = len(path)
+		path = append(path, "secret "+secret.Name)
+		if next, ex := byName[secret.DefaultFrom]; ex {
+			if cycle := visit(next); cycle != nil {
+				return cycle
+			}
+		}
+		for _, vaultName := range append([]string{secret.VaultName}, secret.FallbackVaults...) {
+			vault := vaults.GetVaultByName(vaultName)
+			if vault == nil {
+				continue
+			}
+			path = append(path, "vault "+vault.Name)
+			for _, ref := range vault.SecretRefs() {
+				if next, ex := byName[ref]; ex {
+					if cycle := visit(next); cycle != nil {
+						return cycle
+					}
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		path = path[:len(path)-1]
+		delete(onPath, secret.Name)
+		return nil
+	}
+
+	for _, secret := range secrets {
+		if cycle := visit(secret); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
@@ -18,6 +18,9 @@ type Vault struct {
 
 	// Detailed specification
 	Spec VaultSpec `yaml:"spec" validate:""`
+
+	// Retry optionally overrides the retry policy from the defaults for this vault
+	Retry *RetryPolicy `yaml:"retry" validate:"omitempty"`
 }
 
 // VaultSpec declares details of how to connect to the vault
@@ -38,7 +41,8 @@ func (vaults *Vaults) GetVaultByName(name string) *Vault {
 	return nil
 }
 
-// VaultAccessorPort is able to pull secrets from a Vault
+// VaultAccessorPort is able to pull secrets from a Vault. Errors for secrets that do not exist
+// in a vault match os.ErrNotExist, e.g. by ErrNotFound.
 type VaultAccessorPort interface {
 	RetrieveSecret(context.Context, *Defaults, *Vault, *Secret) (*Secret, error)
 }
@@ -0,0 +1,220 @@
+// Package engine runs configurations from within Go programs. It validates a configuration,
+// retrieves its secrets, applies its transformations and writes to its sinks, like the
+// run command does.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/adapters"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"sync"
+)
+
+// Engine runs configurations with a factory and settings that are shared by all runs.
+// It is safe for concurrent use.
+type Engine struct {
+	log       *logging.Logger
+	fs        afero.Fs
+	factory   core.Factory
+	hooks     []core.Hooks
+	keepGoing bool
+	profile   string
+	inMemory  bool
+}
+
+// Option is the fluent-style configuration option func
+type Option func(*Engine)
+
+// WithLogger sets the logger, which discards all output by default.
+func WithLogger(l *logging.Logger) Option {
+	return func(e *Engine) {
+		e.log = l
+	}
+}
+
+// WithFS sets the file system of the builtin factory, the os file system by default.
+func WithFS(fs afero.Fs) Option {
+	return func(e *Engine) {
+		e.fs = fs
+	}
+}
+
+// WithFactory sets the factory creating vaults, transformations and sinks, instead of
+// the builtin factory.
+func WithFactory(f core.Factory) Option {
+	return func(e *Engine) {
+		e.factory = f
+	}
+}
+
+// WithHooks adds hooks that are called at the boundaries of processing steps. It may be
+// given several times, e.g. for metrics and tracing.
+func WithHooks(hooks core.Hooks) Option {
+	return func(e *Engine) {
+		e.hooks = append(e.hooks, hooks)
+	}
+}
+
+// WithKeepGoing lets processing continue after a failed step. Only steps depending
+// on the output of a failed step are skipped.
+func WithKeepGoing(keepGoing bool) Option {
+	return func(e *Engine) {
+		e.keepGoing = keepGoing
+	}
+}
+
+// WithProfile applies the named profile of the configuration.
+func WithProfile(name string) Option {
+	return func(e *Engine) {
+		e.profile = name
+	}
+}
+
+// WithInMemory keeps the secrets in memory instead of writing them to sinks. The secrets
+// the sinks would have received are returned in Result.Secrets.
+func WithInMemory(inMemory bool) Option {
+	return func(e *Engine) {
+		e.inMemory = inMemory
+	}
+}
+
+// New creates an engine
+func New(opts ...Option) *Engine {
+	res := &Engine{
+		log: logging.Discard(),
+		fs:  afero.NewOsFs(),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.factory == nil {
+		res.factory = adapters.NewBuiltinFactory(res.log, res.fs)
+	}
+	return res
+}
+
+// Run runs a configuration with an engine created from the given options.
+func Run(ctx context.Context, cfg *core.Config, opts ...Option) (*Result, error) {
+	return New(opts...).Run(ctx, cfg)
+}
+
+// ErrInvalidConfig is returned by Run for configurations that fail validation or lack
+// the selected profile. It wraps the core.ConfigError, if any.
+type ErrInvalidConfig struct {
+	Err error
+}
+
+func (e ErrInvalidConfig) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e ErrInvalidConfig) Unwrap() error { return e.Err }
+
+// Result is the outcome of a run.
+type Result struct {
+	// Report contains the outcome of each step
+	Report *core.Report
+
+	// Secrets maps the variables of all sinks to the secrets written to them, or
+	// kept in memory instead. This includes the secrets of collect sinks.
+	Secrets map[string]*core.Secret
+
+	// Repository contains all variables, i.e. retrieved secrets and outputs of transformations
+	Repository core.Repository
+}
+
+// Get returns the secret of any variable, or an error if it has not been produced
+func (r *Result) Get(varName string) (*core.Secret, error) {
+	v, err := r.Repository.Get(varName)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*core.Secret), nil
+}
+
+// Run validates the configuration and processes it. The result is returned along with the
+// error of the first failed step, see core.UseCase. For invalid configurations, it returns
+// an ErrInvalidConfig and no result.
+func (e *Engine) Run(ctx context.Context, cfg *core.Config) (*Result, error) {
+	if err := cfg.Validate(e.factory); err != nil {
+		return nil, ErrInvalidConfig{Err: fmt.Errorf("error validating configuration: %w", err)}
+	}
+
+	if e.profile != "" {
+		var err error
+		if cfg, err = cfg.WithProfile(e.profile); err != nil {
+			return nil, ErrInvalidConfig{Err: err}
+		}
+	}
+
+	// collect sinks need a collector, their secrets are captured like those of other sinks
+	if adapters.CollectorFrom(ctx) == nil {
+		ctx = adapters.WithCollector(ctx, adapters.NewCollector())
+	}
+
+	f := &capturingFactory{Factory: e.factory, inMemory: e.inMemory, secrets: make(map[string]*core.Secret)}
+	repo := e.factory.NewRepository()
+	uc := core.NewMainUseCaseImpl(e.log, core.WithKeepGoing(e.keepGoing), core.WithHooks(core.JoinHooks(e.hooks...)),
+		core.WithRepository(repo))
+
+	report, err := uc.ProcessWithReport(ctx, f, &cfg.Defaults, &cfg.Vaults, &cfg.Secrets, &cfg.Transformations, &cfg.Sinks)
+
+	return &Result{
+		Report:     report,
+		Secrets:    f.secrets,
+		Repository: repo,
+	}, err
+}
+
+// capturingFactory creates sink writers that record the secrets written to them, and
+// only record them in memory mode.
+type capturingFactory struct {
+	core.Factory
+	inMemory bool
+
+	mu      sync.Mutex
+	secrets map[string]*core.Secret
+}
+
+// NewSinkWriter creates a capturing sink writer for a type of the underlying factory
+func (f *capturingFactory) NewSinkWriter(sinkType string) core.SinkWriterPort {
+	sw := f.Factory.NewSinkWriter(sinkType)
+	if sw == nil {
+		return nil
+	}
+	return &capturingSinkWriter{factory: f, sw: sw}
+}
+
+type capturingSinkWriter struct {
+	factory *capturingFactory
+	sw      core.SinkWriterPort
+}
+
+// Write records the secret and writes it to the underlying sink, unless in memory mode
+func (w *capturingSinkWriter) Write(ctx context.Context, defaults *core.Defaults, secret *core.Secret, sink *core.Sink) error {
+	if !w.factory.inMemory {
+		if err := w.sw.Write(ctx, defaults, secret, sink); err != nil {
+			return err
+		}
+	}
+
+	w.factory.mu.Lock()
+	defer w.factory.mu.Unlock()
+	w.factory.secrets[sink.Var] = secret
+	return nil
+}
+
+// Remove removes the content of the underlying sink, unless in memory mode
+func (w *capturingSinkWriter) Remove(ctx context.Context, defaults *core.Defaults, sink *core.Sink) error {
+	if w.factory.inMemory {
+		return nil
+	}
+	sr, ok := w.sw.(core.SinkRemoverPort)
+	if !ok {
+		return errors.New("sink does not support removal")
+	}
+	return sr.Remove(ctx, defaults, sink)
+}
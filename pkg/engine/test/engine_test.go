@@ -0,0 +1,183 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/engine"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+vaults:
+  - name: files
+    type: file
+    spec:
+      dir: /run/secrets
+secrets:
+  - name: db-password
+    type: secret
+    vault: files
+transformations:
+  - type: template
+    in: [db-password]
+    out: dsn
+    spec:
+      template: "postgres://app:{{ index . \"db-password\" }}@db/app"
+sinks:
+  - type: file
+    var: dsn
+    spec:
+      path: /etc/app/dsn
+profiles:
+  prod:
+    vaults:
+      - name: files
+        spec:
+          dir: /run/prod-secrets
+`
+
+func setupEngineTest(t *testing.T) (afero.Fs, *core.Config) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/run/secrets/db-password", []byte("s3cr3t"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := core.NewConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return fs, cfg
+}
+
+func TestEngineRun(t *testing.T) {
+	fs, cfg := setupEngineTest(t)
+
+	steps := make([]string, 0)
+	res, err := engine.Run(context.TODO(), cfg, engine.WithFS(fs), engine.WithHooks(core.Hooks{
+		BeforeStep: func(ctx context.Context, step core.StepResult) {
+			steps = append(steps, "before "+step.Name)
+		},
+		AfterStep: func(ctx context.Context, step core.StepResult, d time.Duration) {
+			steps = append(steps, "after "+step.Name+" "+string(step.Status))
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if b, err := afero.ReadFile(fs, "/etc/app/dsn"); err != nil || string(b) != "postgres://app:s3cr3t@db/app" {
+		t.Errorf("Expected sink to be written, got %q, %v", b, err)
+	}
+	if s := res.Secrets["dsn"]; s == nil || string(s.RawContent) != "postgres://app:s3cr3t@db/app" {
+		t.Errorf("Expected written secret in result, got %v", res.Secrets)
+	}
+	if res.Report.Count(core.StepSucceeded) != 3 {
+		t.Errorf("Expected 3 succeeded steps, got %v", res.Report.Steps)
+	}
+	expected := "before db-password,after db-password succeeded,before dsn,after dsn succeeded,before dsn,after dsn succeeded"
+	if strings.Join(steps, ",") != expected {
+		t.Errorf("Expected hooks at step boundaries, got %v", steps)
+	}
+}
+
+func TestEngineInMemory(t *testing.T) {
+	fs, cfg := setupEngineTest(t)
+
+	res, err := engine.New(engine.WithFS(fs), engine.WithInMemory(true)).Run(context.TODO(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if s := res.Secrets["dsn"]; s == nil || string(s.RawContent) != "postgres://app:s3cr3t@db/app" {
+		t.Errorf("Expected secret in result, got %v", res.Secrets)
+	}
+	if ex, _ := afero.Exists(fs, "/etc/app/dsn"); ex {
+		t.Error("Expected sink not to be written")
+	}
+	if s, err := res.Get("db-password"); err != nil || string(s.RawContent) != "s3cr3t" {
+		t.Errorf("Expected variable in result, got %v, %v", s, err)
+	}
+	if _, err := res.Get("nosuchvar"); err == nil {
+		t.Error("Expected error for unknown variable")
+	}
+}
+
+func TestEngineCollectSink(t *testing.T) {
+	fs, cfg := setupEngineTest(t)
+	cfg.Sinks = append(cfg.Sinks, &core.Sink{Type: "collect", Var: "db-password"})
+
+	res, err := engine.Run(context.TODO(), cfg, engine.WithFS(fs))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if s := res.Secrets["db-password"]; s == nil || string(s.RawContent) != "s3cr3t" {
+		t.Errorf("Expected collected secret in result, got %v", res.Secrets)
+	}
+	if b, err := afero.ReadFile(fs, "/etc/app/dsn"); err != nil || string(b) != "postgres://app:s3cr3t@db/app" {
+		t.Errorf("Expected file sink to be written, got %q, %v", b, err)
+	}
+}
+
+func TestEngineLogging(t *testing.T) {
+	fs, cfg := setupEngineTest(t)
+
+	var b bytes.Buffer
+	_, err := engine.Run(context.TODO(), cfg, engine.WithFS(fs),
+		engine.WithLogger(logging.New(&b, logging.FormatJSON, logging.LevelDebug)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Contains(b.String(), "s3cr3t") {
+		t.Errorf("Expected secret values to be redacted, got %s", b.String())
+	}
+
+	steps := 0
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if rec["msg"] == "Step succeeded" && rec["duration"] != nil && rec["level"] == "info" {
+			steps++
+		}
+		if rec["msg"] == "Retrieved secret" && (rec["vault"] != "files" || rec["secret"] != "db-password") {
+			t.Errorf("Expected fields of vault and secret, got %v", rec)
+		}
+	}
+	if steps != 3 {
+		t.Errorf("Expected a record for each step, got %d", steps)
+	}
+}
+
+func TestEngineErrors(t *testing.T) {
+	fs, cfg := setupEngineTest(t)
+	e := engine.New(engine.WithFS(fs), engine.WithProfile("prod"))
+
+	// the profile points to a directory without secrets
+	res, err := e.Run(context.TODO(), cfg)
+	var errVaultAccess core.ErrVaultAccess
+	if !errors.As(err, &errVaultAccess) || errVaultAccess.Secret != "db-password" {
+		t.Errorf("Expected vault access error, got %v", err)
+	}
+	if res == nil || !res.Report.Failed() || len(res.Secrets) != 0 {
+		t.Errorf("Expected report of failed run, got %v", res)
+	}
+
+	res, err = engine.Run(context.TODO(), cfg, engine.WithFS(fs), engine.WithProfile("staging"))
+	var errInvalidConfig engine.ErrInvalidConfig
+	if !errors.As(err, &errInvalidConfig) || res != nil {
+		t.Errorf("Expected invalid config error, got %v", err)
+	}
+
+	cfg.Sinks[0].Type = "nosuchtype"
+	_, err = engine.Run(context.TODO(), cfg, engine.WithFS(fs))
+	if !errors.As(err, &errInvalidConfig) || !strings.HasPrefix(err.Error(), "error validating configuration: ") {
+		t.Errorf("Expected invalid config error, got %v", err)
+	}
+}
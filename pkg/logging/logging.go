@@ -0,0 +1,308 @@
+// Package logging contains a levelled, structured logger. Log records consist of a message
+// and fields, which are created by the constructors of this package. None of them takes
+// arbitrary values, so that secret values cannot end up in logs by accident.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record
+type Level int
+
+const (
+	// LevelDebug is for details of processing, e.g. the urls of vaults
+	LevelDebug Level = iota
+
+	// LevelInfo is for the outcome of processing steps
+	LevelInfo
+
+	// LevelWarn is for problems that processing recovers from, e.g. retries
+	LevelWarn
+
+	// LevelError is for failed processing steps
+	LevelError
+
+	// LevelOff disables all records
+	LevelOff
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelOff {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the given name, i.e. debug, info, warn, error or off
+func ParseLevel(name string) (Level, error) {
+	for idx, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(idx), nil
+		}
+	}
+	return LevelDebug, fmt.Errorf("invalid log level: %s", name)
+}
+
+// Format is the format of log records
+type Format string
+
+const (
+	// FormatText writes records as key=value pairs, one record per line
+	FormatText Format = "text"
+
+	// FormatJSON writes records as json objects, one record per line
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the format of the given name, i.e. text or json
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	}
+	return FormatText, fmt.Errorf("invalid log format: %s", name)
+}
+
+// Field is a key-value pair of a log record
+type Field struct {
+	key   string
+	value interface{}
+}
+
+// String creates a field with a string value. It must not be used for secret values.
+func String(key string, value string) Field {
+	return Field{key: key, value: value}
+}
+
+// Strings creates a field with a list of strings, e.g. variable names
+func Strings(key string, values []string) Field {
+	return Field{key: key, value: append([]string{}, values...)}
+}
+
+// Int creates a field with an integer value
+func Int(key string, value int64) Field {
+	return Field{key: key, value: value}
+}
+
+// Bool creates a field with a boolean value
+func Bool(key string, value bool) Field {
+	return Field{key: key, value: value}
+}
+
+// Duration creates the duration field
+func Duration(d time.Duration) Field {
+	return Field{key: "duration", value: d}
+}
+
+// Err creates the error field
+func Err(err error) Field {
+	if err == nil {
+		return Field{key: "error", value: ""}
+	}
+	return Field{key: "error", value: err.Error()}
+}
+
+// Vault creates the vault field from the name of a vault
+func Vault(name string) Field {
+	return String("vault", name)
+}
+
+// Secret creates the secret field from the name of a secret. There is no field for
+// the value of a secret.
+func Secret(name string) Field {
+	return String("secret", name)
+}
+
+// Var creates the var field from the name of a variable
+func Var(name string) Field {
+	return String("var", name)
+}
+
+// Type creates the type field from the type of a vault, transformation or sink
+func Type(t string) Field {
+	return String("type", t)
+}
+
+// Sink creates the sink field from the type of a sink
+func Sink(t string) Field {
+	return String("sink", t)
+}
+
+// Transformation creates the transformation field from the type of a transformation
+func Transformation(t string) Field {
+	return String("transformation", t)
+}
+
+// output is shared by a logger and all loggers derived from it
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+	level  Level
+}
+
+// Logger writes records of at least its level. It is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields []Field
+}
+
+// New creates a logger writing records of at least the given level to w
+func New(w io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		out: &output{w: w, format: format, level: level},
+	}
+}
+
+// Discard creates a logger that drops all records
+func Discard() *Logger {
+	return New(ioutil.Discard, FormatText, LevelOff)
+}
+
+// With returns a logger adding the given fields to all records
+func (l *Logger) With(fields ...Field) *Logger {
+	return &Logger{
+		out:    l.out,
+		fields: append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...),
+	}
+}
+
+// Enabled returns true if records of the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Debug writes a record of level debug
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.Log(LevelDebug, msg, fields...)
+}
+
+// Info writes a record of level info
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.Log(LevelInfo, msg, fields...)
+}
+
+// Warn writes a record of level warn
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.Log(LevelWarn, msg, fields...)
+}
+
+// Error writes a record of level error
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.Log(LevelError, msg, fields...)
+}
+
+// Log writes a record of the given level, if enabled
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := append(append(make([]Field, 0, len(l.fields)+len(fields)+3),
+		String("time", time.Now().UTC().Format(time.RFC3339Nano)),
+		String("level", level.String()),
+		String("msg", msg)), l.fields...)
+	all = append(all, fields...)
+
+	var buf bytes.Buffer
+	if l.out.format == FormatJSON {
+		writeJSON(&buf, all)
+	} else {
+		writeText(&buf, all)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// Writer returns a writer logging each line written to it as the message of a record,
+// e.g. for the stderr of child processes
+func (l *Logger) Writer(level Level, fields ...Field) io.Writer {
+	return &lineWriter{log: l.With(fields...), level: level}
+}
+
+type lineWriter struct {
+	log   *Logger
+	level Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(w.buf[:idx]), "\r"); line != "" {
+			w.log.Log(w.level, line)
+		}
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+func writeText(buf *bytes.Buffer, fields []Field) {
+	for idx, f := range fields {
+		if idx > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+
+		var s string
+		switch v := f.value.(type) {
+		case string:
+			s = v
+		case []string:
+			s = strings.Join(v, ",")
+		default:
+			s = fmt.Sprint(v)
+		}
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"=\\") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, fields []Field) {
+	buf.WriteByte('{')
+	for idx, f := range fields {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		value := f.value
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(err.Error())
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+}
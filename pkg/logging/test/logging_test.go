@@ -0,0 +1,91 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerText(t *testing.T) {
+	var b bytes.Buffer
+	l := logging.New(&b, logging.FormatText, logging.LevelInfo).With(logging.Vault("kv"))
+
+	l.Debug("Not written")
+	l.Info("Retrieved secret", logging.Secret("db password"), logging.Duration(1500*time.Millisecond),
+		logging.Strings("in", []string{"a", "b"}), logging.Err(errors.New(`quoted "error"`)))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one record, got %q", b.String())
+	}
+	if !strings.HasPrefix(lines[0], "time=") {
+		t.Errorf("Expected time first, got %s", lines[0])
+	}
+	expected := `level=info msg="Retrieved secret" vault=kv secret="db password" duration=1.5s in=a,b error="quoted \"error\""`
+	if !strings.HasSuffix(lines[0], expected) {
+		t.Errorf("Expected record %s, got %s", expected, lines[0])
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var b bytes.Buffer
+	l := logging.New(&b, logging.FormatJSON, logging.LevelDebug)
+
+	l.Warn("Retrying secret", logging.Secret("db-password"), logging.Int("attempt", 2), logging.Bool("final", false))
+	w := l.Writer(logging.LevelInfo, logging.String("plugin", "./plugin"))
+	fmt.Fprint(w, "first line\nsecond ")
+	fmt.Fprint(w, "line\n")
+
+	dec := json.NewDecoder(&b)
+	records := make([]map[string]interface{}, 0)
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %v", records)
+	}
+	if r := records[0]; r["level"] != "warn" || r["msg"] != "Retrying secret" || r["secret"] != "db-password" ||
+		r["attempt"] != float64(2) || r["final"] != false || r["time"] == nil {
+		t.Errorf("Unexpected record %v", r)
+	}
+	if r := records[2]; r["level"] != "info" || r["msg"] != "second line" || r["plugin"] != "./plugin" {
+		t.Errorf("Expected line of writer, got %v", r)
+	}
+}
+
+func TestLevelsAndFormats(t *testing.T) {
+	for _, name := range []string{"debug", "info", "WARN", "error", "off"} {
+		level, err := logging.ParseLevel(name)
+		if err != nil || level.String() != strings.ToLower(name) {
+			t.Errorf("Expected level %s, got %s, %v", name, level, err)
+		}
+	}
+	if _, err := logging.ParseLevel("verbose"); err == nil || err.Error() != "invalid log level: verbose" {
+		t.Errorf("Expected error, got %v", err)
+	}
+	if _, err := logging.ParseFormat("xml"); err == nil || err.Error() != "invalid log format: xml" {
+		t.Errorf("Expected error, got %v", err)
+	}
+	if logging.Discard().Enabled(logging.LevelError) {
+		t.Error("Expected discarding logger to drop errors")
+	}
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	s := &core.Secret{Name: "db-password", Type: "secret", RawContent: []byte("s3cr3t")}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		if res := fmt.Sprintf(format, s); strings.Contains(res, "s3cr3t") {
+			t.Errorf("Expected %s to redact secret, got %s", format, res)
+		}
+	}
+}
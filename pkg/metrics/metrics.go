@@ -0,0 +1,184 @@
+// Package metrics instruments processing with Prometheus metrics. It hooks into the
+// boundaries of processing steps, see core.Hooks, so that vaults, transformations and
+// sinks are covered without instrumenting each of them.
+package metrics
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Namespace prefixes the names of all metrics
+const Namespace = "secretshelper"
+
+// Metrics collects the metrics of all runs it is hooked into. It is safe for concurrent use.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	vaultDuration          *prometheus.HistogramVec
+	vaultErrors            *prometheus.CounterVec
+	transformationDuration *prometheus.HistogramVec
+	sinkLastSuccess        *prometheus.GaugeVec
+	secretsChanged         prometheus.Counter
+
+	// digests maps the names of secrets to the digests of their values of the previous
+	// run, to count changed secrets
+	mu      sync.Mutex
+	digests map[string][sha256.Size]byte
+}
+
+// New creates the metrics, registered with a registry of their own
+func New() *Metrics {
+	res := &Metrics{
+		registry: prometheus.NewRegistry(),
+		vaultDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "vault_retrieval_duration_seconds",
+			Help:      "Duration of retrieving a secret from a vault, including retries and fallbacks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"vault", "type"}),
+		vaultErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "vault_errors_total",
+			Help:      "Number of secrets that could not be retrieved, by vault and class of error.",
+		}, []string{"vault", "type", "class"}),
+		transformationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "transformation_duration_seconds",
+			Help:      "Duration of applying a transformation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type", "out"}),
+		sinkLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "sink_last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful write of a variable to a sink.",
+		}, []string{"type", "var"}),
+		secretsChanged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "secrets_changed_total",
+			Help:      "Number of secrets whose value differs from the previous run.",
+		}),
+		digests: make(map[string][sha256.Size]byte),
+	}
+
+	res.registry.MustRegister(res.vaultDuration, res.vaultErrors, res.transformationDuration,
+		res.sinkLastSuccess, res.secretsChanged)
+	return res
+}
+
+// Registry returns the registry of the metrics, e.g. to register further collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Hooks returns the hooks updating the metrics
+func (m *Metrics) Hooks() core.Hooks {
+	return core.Hooks{
+		AfterStep: m.afterStep,
+		Produced:  m.produced,
+	}
+}
+
+func (m *Metrics) afterStep(ctx context.Context, step core.StepResult, d time.Duration) {
+	if step.Status == core.StepSkipped {
+		return
+	}
+
+	switch step.Kind {
+	case core.StepKindSecret:
+		m.vaultDuration.WithLabelValues(step.Vault, step.Type).Observe(d.Seconds())
+		if step.Status == core.StepFailed {
+			m.vaultErrors.WithLabelValues(step.Vault, step.Type, ErrorClass(step.Err)).Inc()
+		}
+	case core.StepKindTransformation:
+		m.transformationDuration.WithLabelValues(step.Type, step.Name).Observe(d.Seconds())
+	case core.StepKindSink:
+		if step.Status == core.StepSucceeded {
+			m.sinkLastSuccess.WithLabelValues(step.Type, step.Name).SetToCurrentTime()
+		}
+	}
+}
+
+// produced counts retrieved secrets whose value changed since the previous run. Only digests
+// of values are kept, and only in memory.
+func (m *Metrics) produced(ctx context.Context, step core.StepResult, secret *core.Secret) {
+	if step.Kind != core.StepKindSecret {
+		return
+	}
+	digest := sha256.Sum256(secret.RawContent)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prev, ex := m.digests[step.Name]; ex && prev != digest {
+		m.secretsChanged.Inc()
+	}
+	m.digests[step.Name] = digest
+}
+
+// ErrorClass classifies the error of a failed step as timeout, canceled, not-found,
+// permission or other
+func ErrorClass(err error) string {
+	var errNet net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, os.ErrNotExist):
+		return "not-found"
+	case errors.Is(err, os.ErrPermission):
+		return "permission"
+	case errors.As(err, &errNet) && errNet.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+// Handler returns a handler serving the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Listen starts an HTTP server serving the metrics at /metrics on the given address, e.g.
+// ":9090". It returns after the listener has been opened, the address of the returned server
+// is the one listened on. The server is stopped by Close.
+func (m *Metrics) Listen(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	srv := &http.Server{Addr: l.Addr().String(), Handler: mux}
+	go srv.Serve(l)
+
+	return srv, nil
+}
+
+// WriteTextfile writes the metrics to a file for the textfile collector of the node exporter.
+// The file is replaced atomically, its name has to end with .prom. Changed secrets are left
+// out, as they are only counted across runs of the same process.
+func (m *Metrics) WriteTextfile(fileName string) error {
+	return prometheus.WriteToTextfile(fileName, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := m.registry.Gather()
+		res := make([]*dto.MetricFamily, 0, len(mfs))
+		for _, mf := range mfs {
+			if mf.GetName() != prometheus.BuildFQName(Namespace, "", "secrets_changed_total") {
+				res = append(res, mf)
+			}
+		}
+		return res, err
+	}))
+}
@@ -0,0 +1,155 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/core"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/engine"
+	"github.com/vladislavprovich/secrets-cloud-helper/pkg/metrics"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+vaults:
+  - name: files
+    type: file
+    spec:
+      dir: /run/secrets
+secrets:
+  - name: db-password
+    type: secret
+    vault: files
+  - name: api-key
+    type: secret
+    vault: files
+transformations:
+  - type: template
+    in: [db-password]
+    out: dsn
+    spec:
+      template: "postgres://app:{{ index . \"db-password\" }}@db/app"
+sinks:
+  - type: file
+    var: dsn
+    spec:
+      path: /etc/app/dsn
+  - type: file
+    var: api-key
+    spec:
+      path: /etc/app/api-key
+`
+
+func TestMetrics(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cfg, err := core.NewConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	m := metrics.New()
+	e := engine.New(engine.WithFS(fs), engine.WithHooks(m.Hooks()), engine.WithKeepGoing(true))
+
+	// the api key is missing in the first run, the password changes in the third one
+	for idx, password := range []string{"s3cr3t", "s3cr3t", "changed"} {
+		if err := afero.WriteFile(fs, "/run/secrets/db-password", []byte(password), 0400); err != nil {
+			t.Fatal(err)
+		}
+		if idx == 1 {
+			if err := afero.WriteFile(fs, "/run/secrets/api-key", []byte("key"), 0400); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := e.Run(context.TODO(), cfg)
+		if (idx == 0) != (err != nil) {
+			t.Fatalf("Unexpected error of run %d: %v", idx, err)
+		}
+	}
+
+	expected := `
+# HELP secretshelper_secrets_changed_total Number of secrets whose value differs from the previous run.
+# TYPE secretshelper_secrets_changed_total counter
+secretshelper_secrets_changed_total 1
+# HELP secretshelper_vault_errors_total Number of secrets that could not be retrieved, by vault and class of error.
+# TYPE secretshelper_vault_errors_total counter
+secretshelper_vault_errors_total{class="not-found",type="file",vault="files"} 1
+`
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(expected),
+		"secretshelper_secrets_changed_total", "secretshelper_vault_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	if n, err := testutil.GatherAndCount(m.Registry(), "secretshelper_sink_last_success_timestamp_seconds"); n != 2 || err != nil {
+		t.Errorf("Expected a timestamp for each sink, got %d, %v", n, err)
+	}
+	if n, err := testutil.GatherAndCount(m.Registry(), "secretshelper_transformation_duration_seconds"); n != 1 || err != nil {
+		t.Errorf("Expected durations of the transformation, got %d, %v", n, err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "secretshelper.prom")
+	if err := m.WriteTextfile(fileName); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil || !strings.Contains(string(b), `secretshelper_vault_retrieval_duration_seconds_count{type="file",vault="files"} 6`) {
+		t.Errorf("Expected histogram of retrievals in textfile, got %s, %v", b, err)
+	}
+	if strings.Contains(string(b), "secrets_changed_total") {
+		t.Error("Expected no changed secrets in textfile")
+	}
+	if strings.Contains(string(b), "s3cr3t") {
+		t.Error("Expected no secret values in metrics")
+	}
+}
+
+func TestMetricsListen(t *testing.T) {
+	m := metrics.New()
+	srv, err := m.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer srv.Close()
+
+	_, err = m.Listen(srv.Addr)
+	if err == nil {
+		t.Error("Expected error for address in use")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := metrics.New()
+	m.Hooks().AfterStep(context.TODO(), core.StepResult{Kind: core.StepKindSecret, Vault: "kv",
+		Type: "env", Status: core.StepFailed, Err: context.DeadlineExceeded}, 0)
+
+	srv, err := m.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer srv.Close()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", srv.Addr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(b), `secretshelper_vault_errors_total{class="timeout",type="env",vault="kv"} 1`) {
+		t.Errorf("Expected error counter, got %s", b)
+	}
+
+	for err, class := range map[error]string{
+		context.Canceled:                            "canceled",
+		core.ErrVaultAccess{Err: os.ErrNotExist}:    "not-found",
+		fmt.Errorf("wrapped: %w", os.ErrPermission): "permission",
+		fmt.Errorf("access denied"):                 "other",
+	} {
+		if res := metrics.ErrorClass(err); res != class {
+			t.Errorf("Expected class %s of %v, got %s", class, err, res)
+		}
+	}
+}